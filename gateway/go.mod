@@ -3,8 +3,10 @@ module github.com/openfaas/faas/gateway
 go 1.24
 
 require (
+	github.com/andybalholm/brotli v1.2.0
 	github.com/docker/distribution v2.8.3+incompatible
 	github.com/gorilla/mux v1.8.1
+	github.com/klauspost/compress v1.18.0
 	github.com/openfaas/faas-provider v0.25.8
 	github.com/openfaas/nats-queue-worker v0.0.0-20250415083406-ed5414157b54
 	github.com/prometheus/client_golang v1.23.0
@@ -20,7 +22,6 @@ require (
 	github.com/hashicorp/go-hclog v1.6.3 // indirect
 	github.com/hashicorp/go-msgpack/v2 v2.1.3 // indirect
 	github.com/hashicorp/raft v1.7.3 // indirect
-	github.com/klauspost/compress v1.18.0 // indirect
 	github.com/minio/highwayhash v1.0.3 // indirect
 	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
 	github.com/nats-io/jwt/v2 v2.8.0 // indirect
@@ -12,14 +12,87 @@ import (
 	"log"
 	"math"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/openfaas/faas/gateway/pkg/middleware"
 	"github.com/openfaas/faas/gateway/requests"
 	"github.com/openfaas/faas/gateway/scaling"
 )
 
+const (
+	// alertRetention is how long alerts are remembered to drop repeated
+	// notifications
+	alertRetention = time.Hour
+)
+
+// AlertScalingConfig configures how functions are scaled by alerts
+type AlertScalingConfig struct {
+	// DefaultNamespace is used for alerts without a namespace
+	DefaultNamespace string
+
+	// Ceiling caps the replicas of every function
+	Ceiling *scaling.ReplicaCeiling
+
+	// ScaleDownStep is the number of replicas removed at a time once an
+	// alert resolves, 0 scales straight to the minimum replicas
+	ScaleDownStep uint64
+
+	// ScaleDownCooldown is how long after a function was last scaled that
+	// each step down is made
+	ScaleDownCooldown time.Duration
+
+	// Schedule gives the minimum replicas of functions with an active
+	// replica schedule, it can be nil
+	Schedule *scaling.ReplicaScheduler
+
+	// Events records each change of replicas, it can be nil
+	Events *scaling.ScaleEventLog
+}
+
+// alertScaleState is the last scale event of a function
+type alertScaleState struct {
+	lastScaled time.Time
+
+	// generation changes whenever the function is scaled by an alert, so
+	// that a pending step down is cancelled
+	generation int
+}
+
+// handledAlert is an alert which has been acted on
+type handledAlert struct {
+	status   string
+	startsAt time.Time
+	handled  time.Time
+}
+
+// AlertScaler scales functions from AlertManager notifications. Firing
+// alerts scale a function up by its scaling factor, and resolved alerts
+// scale it down to its minimum replicas, either at once or one step at a
+// time after a cooldown. Repeated notifications of a resolved alert, and
+// firing notifications which arrive after the alert resolved, are dropped.
+type AlertScaler struct {
+	config  AlertScalingConfig
+	service scaling.ServiceQuery
+
+	functions map[string]*alertScaleState
+	alerts    map[string]handledAlert
+	lock      sync.Mutex
+}
+
+// NewAlertScaler creates an AlertScaler which scales functions through
+// service
+func NewAlertScaler(config AlertScalingConfig, service scaling.ServiceQuery) *AlertScaler {
+	return &AlertScaler{
+		config:    config,
+		service:   service,
+		functions: make(map[string]*alertScaleState),
+		alerts:    make(map[string]handledAlert),
+	}
+}
+
 // MakeAlertHandler handles alerts from Prometheus Alertmanager
-func MakeAlertHandler(service scaling.ServiceQuery, defaultNamespace string) http.HandlerFunc {
+func MakeAlertHandler(scaler *AlertScaler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		if r.Body == nil {
@@ -46,7 +119,7 @@ func MakeAlertHandler(service scaling.ServiceQuery, defaultNamespace string) htt
 			return
 		}
 
-		errors := handleAlerts(req, service, defaultNamespace)
+		errors := scaler.handleAlerts(req)
 		if len(errors) > 0 {
 			log.Println(errors)
 			var errorOutput string
@@ -62,10 +135,14 @@ func MakeAlertHandler(service scaling.ServiceQuery, defaultNamespace string) htt
 	}
 }
 
-func handleAlerts(req requests.PrometheusAlert, service scaling.ServiceQuery, defaultNamespace string) []error {
+func (a *AlertScaler) handleAlerts(req requests.PrometheusAlert) []error {
 	var errors []error
 	for _, alert := range req.Alerts {
-		if err := scaleService(alert, service, defaultNamespace); err != nil {
+		if a.duplicate(alert, time.Now()) {
+			continue
+		}
+
+		if err := a.scaleService(alert); err != nil {
 			log.Println(err)
 			errors = append(errors, err)
 		}
@@ -74,24 +151,80 @@ func handleAlerts(req requests.PrometheusAlert, service scaling.ServiceQuery, de
 	return errors
 }
 
-func scaleService(alert requests.PrometheusInnerAlert, service scaling.ServiceQuery, defaultNamespace string) error {
+// duplicate records the alert and reports whether it has already been
+// acted on, or arrived after the alert resolved. Alerts without a
+// fingerprint or start time can't be told apart and are never dropped.
+func (a *AlertScaler) duplicate(alert requests.PrometheusInnerAlert, now time.Time) bool {
+	if len(alert.Fingerprint) == 0 && alert.StartsAt.IsZero() {
+		return false
+	}
+	key := alertKey(alert)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for k, handled := range a.alerts {
+		if now.Sub(handled.handled) > alertRetention {
+			delete(a.alerts, k)
+		}
+	}
+
+	if prev, ok := a.alerts[key]; ok && prev.status == "resolved" {
+		if alert.Status == "resolved" && prev.startsAt.Equal(alert.StartsAt) {
+			log.Printf("[Scale] dropped repeated resolved alert for %s", alert.Labels.FunctionName)
+			return true
+		}
+		if alert.Status == "firing" && !alert.StartsAt.After(prev.startsAt) {
+			log.Printf("[Scale] dropped firing alert for %s which has since resolved", alert.Labels.FunctionName)
+			return true
+		}
+	}
+
+	a.alerts[key] = handledAlert{status: alert.Status, startsAt: alert.StartsAt, handled: now}
+	return false
+}
+
+// alertKey identifies an alert by its fingerprint, or by its labels and
+// start time for payloads without one
+func alertKey(alert requests.PrometheusInnerAlert) string {
+	if len(alert.Fingerprint) > 0 {
+		return alert.Fingerprint
+	}
+	return fmt.Sprintf("%s/%s/%s/%d", alert.Labels.AlertName, alert.Labels.FunctionName, alert.Labels.Namespace, alert.StartsAt.UnixNano())
+}
+
+func (a *AlertScaler) scaleService(alert requests.PrometheusInnerAlert) error {
 	var err error
 
+	defaultNamespace := a.config.DefaultNamespace
+	if len(alert.Labels.Namespace) > 0 {
+		defaultNamespace = alert.Labels.Namespace
+	}
 	serviceName, namespace := middleware.GetNamespace(defaultNamespace, alert.Labels.FunctionName)
 
 	if len(serviceName) > 0 {
-		queryResponse, getErr := service.GetReplicas(serviceName, namespace)
+		if alert.Status != "firing" && a.config.ScaleDownStep > 0 {
+			a.scheduleScaleDown(serviceName, namespace)
+			return nil
+		}
+
+		queryResponse, getErr := a.service.GetReplicas(serviceName, namespace)
 		if getErr == nil {
 			status := alert.Status
 
-			newReplicas := CalculateReplicas(status, queryResponse.Replicas, uint64(queryResponse.MaxReplicas), queryResponse.MinReplicas, queryResponse.ScalingFactor)
+			maxReplicas, _ := a.config.Ceiling.Max(namespace)
+			minReplicas := a.minReplicas(serviceName, namespace, queryResponse.MinReplicas)
+			newReplicas := CalculateReplicas(status, queryResponse.Replicas, uint64(queryResponse.MaxReplicas), minReplicas, queryResponse.ScalingFactor, maxReplicas)
+
+			// Cancel any step down in progress
+			a.scaled(serviceName, namespace, true)
 
 			log.Printf("[Scale] function=%s %d => %d.\n", serviceName, queryResponse.Replicas, newReplicas)
 			if newReplicas == queryResponse.Replicas {
 				return nil
 			}
 
-			updateErr := service.SetReplicas(serviceName, namespace, newReplicas)
+			updateErr := a.setReplicas(serviceName, namespace, queryResponse.Replicas, newReplicas)
 			if updateErr != nil {
 				err = updateErr
 			}
@@ -100,11 +233,129 @@ func scaleService(alert requests.PrometheusInnerAlert, service scaling.ServiceQu
 	return err
 }
 
-// CalculateReplicas decides what replica count to set depending on current/desired amount
-func CalculateReplicas(status string, currentReplicas uint64, maxReplicas uint64, minReplicas uint64, scalingFactor uint64) uint64 {
+// scaled records a scale event for a function and gives its generation,
+// a new generation cancels the pending step down
+func (a *AlertScaler) scaled(functionName, namespace string, newGeneration bool) int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	key := functionName + "." + namespace
+	state, ok := a.functions[key]
+	if !ok {
+		state = &alertScaleState{}
+		a.functions[key] = state
+	}
+
+	state.lastScaled = time.Now()
+	if newGeneration {
+		state.generation++
+	}
+	return state.generation
+}
+
+// scheduleScaleDown starts scaling a function down one step at a time, the
+// first step is made once the cooldown has passed since it was last scaled
+func (a *AlertScaler) scheduleScaleDown(functionName, namespace string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	key := functionName + "." + namespace
+	state, ok := a.functions[key]
+	if !ok {
+		state = &alertScaleState{}
+		a.functions[key] = state
+	}
+
+	state.generation++
+	generation := state.generation
+	delay := a.config.ScaleDownCooldown - time.Since(state.lastScaled)
+
+	time.AfterFunc(max(delay, 0), func() {
+		a.stepDown(functionName, namespace, generation)
+	})
+}
+
+// stepDown removes ScaleDownStep replicas from a function, then schedules
+// the next step until it reaches its minimum replicas
+func (a *AlertScaler) stepDown(functionName, namespace string, generation int) {
+	key := functionName + "." + namespace
+
+	a.lock.Lock()
+	current := a.functions[key].generation
+	a.lock.Unlock()
+
+	if current != generation {
+		return
+	}
+
+	queryResponse, err := a.service.GetReplicas(functionName, namespace)
+	if err != nil {
+		log.Printf("[Scale] function=%s unable to scale down: %s", functionName, err)
+		return
+	}
+
+	minReplicas := a.minReplicas(functionName, namespace, queryResponse.MinReplicas)
+	if queryResponse.Replicas <= minReplicas {
+		return
+	}
+
+	newReplicas := minReplicas
+	if queryResponse.Replicas-minReplicas > a.config.ScaleDownStep {
+		newReplicas = queryResponse.Replicas - a.config.ScaleDownStep
+	}
+
+	log.Printf("[Scale] function=%s %d => %d.\n", functionName, queryResponse.Replicas, newReplicas)
+	if err := a.setReplicas(functionName, namespace, queryResponse.Replicas, newReplicas); err != nil {
+		log.Printf("[Scale] function=%s unable to scale down: %s", functionName, err)
+		return
+	}
+
+	if a.scaled(functionName, namespace, false) == generation && newReplicas > minReplicas {
+		time.AfterFunc(a.config.ScaleDownCooldown, func() {
+			a.stepDown(functionName, namespace, generation)
+		})
+	}
+}
+
+// setReplicas scales a function and records the event
+func (a *AlertScaler) setReplicas(functionName, namespace string, from, to uint64) error {
+	start := time.Now()
+	err := a.service.SetReplicas(functionName, namespace, to)
+
+	event := scaling.ScaleEvent{
+		Name:            functionName,
+		Namespace:       namespace,
+		Trigger:         scaling.TriggerAlert,
+		From:            from,
+		To:              to,
+		DurationSeconds: time.Since(start).Seconds(),
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	a.config.Events.Record(event)
+	return err
+}
+
+// minReplicas gives the replicas a function is scaled down to, which are
+// its scheduled replicas while a replica schedule is active
+func (a *AlertScaler) minReplicas(functionName, namespace string, minReplicas uint64) uint64 {
+	if scheduled, ok := a.config.Schedule.Scheduled(functionName, namespace); ok {
+		return scheduled
+	}
+	return minReplicas
+}
+
+// CalculateReplicas decides what replica count to set depending on current/desired amount,
+// maxReplicas is capped at the ceiling, and a maxReplicas of 0 means the function has
+// no maximum of its own
+func CalculateReplicas(status string, currentReplicas uint64, maxReplicas uint64, minReplicas uint64, scalingFactor uint64, ceiling uint64) uint64 {
 	var newReplicas uint64
 
-	maxReplicas = uint64(math.Min(float64(maxReplicas), float64(scaling.DefaultMaxReplicas)))
+	if maxReplicas == 0 {
+		maxReplicas = ceiling
+	}
+	maxReplicas = uint64(math.Min(float64(maxReplicas), float64(ceiling)))
 	step := uint64(math.Ceil(float64(maxReplicas) / 100 * float64(scalingFactor)))
 
 	if status == "firing" && step > 0 {
@@ -6,15 +6,22 @@
 package handlers
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	types "github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/faas/gateway/requests"
 	"github.com/openfaas/faas/gateway/scaling"
 )
 
 func TestDisabledScale(t *testing.T) {
 	minReplicas := uint64(1)
 	scalingFactor := uint64(0)
-	got := CalculateReplicas("firing", scaling.DefaultMinReplicas, scaling.DefaultMaxReplicas, minReplicas, scalingFactor)
+	got := CalculateReplicas("firing", scaling.DefaultMinReplicas, scaling.DefaultMaxReplicas, minReplicas, scalingFactor, scaling.DefaultMaxReplicas)
 	if got != minReplicas {
 		t.Logf("Expected not to scale, but replicas were: %d", got)
 		t.Fail()
@@ -24,7 +31,7 @@ func TestDisabledScale(t *testing.T) {
 func TestParameterEdge(t *testing.T) {
 	minReplicas := uint64(0)
 	scalingFactor := uint64(0)
-	got := CalculateReplicas("firing", scaling.DefaultMinReplicas, scaling.DefaultMaxReplicas, minReplicas, scalingFactor)
+	got := CalculateReplicas("firing", scaling.DefaultMinReplicas, scaling.DefaultMaxReplicas, minReplicas, scalingFactor, scaling.DefaultMaxReplicas)
 	if got != 0 {
 		t.Log("Expected not to scale")
 		t.Fail()
@@ -36,7 +43,7 @@ func TestScaling_SameUpperLowerLimit(t *testing.T) {
 	maxReplicas := uint64(5)
 	scalingFactor := uint64(10)
 
-	got := CalculateReplicas("firing", minReplicas, minReplicas, maxReplicas, scalingFactor)
+	got := CalculateReplicas("firing", minReplicas, minReplicas, maxReplicas, scalingFactor, scaling.DefaultMaxReplicas)
 
 	want := minReplicas
 	if want != got {
@@ -48,7 +55,7 @@ func TestScaling_SameUpperLowerLimit(t *testing.T) {
 func TestMaxScale(t *testing.T) {
 	minReplicas := uint64(1)
 	scalingFactor := uint64(100)
-	got := CalculateReplicas("firing", scaling.DefaultMinReplicas, scaling.DefaultMaxReplicas*2, minReplicas, scalingFactor)
+	got := CalculateReplicas("firing", scaling.DefaultMinReplicas, scaling.DefaultMaxReplicas*2, minReplicas, scalingFactor, scaling.DefaultMaxReplicas)
 	if got != scaling.DefaultMaxReplicas {
 		t.Fatalf("want ceiling: %d, but got: %d", scaling.DefaultMaxReplicas, got)
 	}
@@ -57,7 +64,7 @@ func TestMaxScale(t *testing.T) {
 func TestInitialScale_From1_Factor10(t *testing.T) {
 	minReplicas := uint64(1)
 	scalingFactor := uint64(10)
-	got := CalculateReplicas("firing", scaling.DefaultMinReplicas, scaling.DefaultMaxReplicas, minReplicas, scalingFactor)
+	got := CalculateReplicas("firing", scaling.DefaultMinReplicas, scaling.DefaultMaxReplicas, minReplicas, scalingFactor, scaling.DefaultMaxReplicas)
 	want := uint64(2)
 
 	if got != want {
@@ -71,7 +78,7 @@ func TestScale_midrange_factor25(t *testing.T) {
 	current := uint64(4)
 	maxReplicas := uint64(scaling.DefaultMaxReplicas)
 
-	got := CalculateReplicas("firing", current, maxReplicas, minReplicas, scalingFactor)
+	got := CalculateReplicas("firing", current, maxReplicas, minReplicas, scalingFactor, scaling.DefaultMaxReplicas)
 	want := uint64(5)
 	if want != got {
 		t.Fatalf("want: %d, but got: %d", want, got)
@@ -83,7 +90,7 @@ func TestScale_Ceiling_IsDefaultMaxReplicas(t *testing.T) {
 	scalingFactor := uint64(10)
 	current := uint64(scaling.DefaultMaxReplicas)
 
-	got := CalculateReplicas("firing", current, scaling.DefaultMaxReplicas, minReplicas, scalingFactor)
+	got := CalculateReplicas("firing", current, scaling.DefaultMaxReplicas, minReplicas, scalingFactor, scaling.DefaultMaxReplicas)
 	if got != scaling.DefaultMaxReplicas {
 		t.Fatalf("want: %d, but got: %d", scaling.DefaultMaxReplicas, got)
 	}
@@ -92,7 +99,7 @@ func TestScale_Ceiling_IsDefaultMaxReplicas(t *testing.T) {
 func TestScaleCeilingReplicasOver(t *testing.T) {
 	minReplicas := uint64(1)
 	scalingFactor := uint64(10)
-	got := CalculateReplicas("firing", 19, scaling.DefaultMaxReplicas, minReplicas, scalingFactor)
+	got := CalculateReplicas("firing", 19, scaling.DefaultMaxReplicas, minReplicas, scalingFactor, scaling.DefaultMaxReplicas)
 
 	if got != scaling.DefaultMaxReplicas {
 		t.Fatalf("want: %d, but got: %d", scaling.DefaultMaxReplicas, got)
@@ -102,7 +109,7 @@ func TestScaleCeilingReplicasOver(t *testing.T) {
 func TestBackingOff(t *testing.T) {
 	minReplicas := uint64(1)
 	scalingFactor := uint64(10)
-	got := CalculateReplicas("resolved", 8, scaling.DefaultMaxReplicas, minReplicas, scalingFactor)
+	got := CalculateReplicas("resolved", 8, scaling.DefaultMaxReplicas, minReplicas, scalingFactor, scaling.DefaultMaxReplicas)
 	if got != 1 {
 		t.Log("Expected backing off to 1 replica")
 		t.Fail()
@@ -113,7 +120,7 @@ func TestScaledUpFrom1(t *testing.T) {
 	currentReplicas := uint64(1)
 	maxReplicas := uint64(5)
 	scalingFactor := uint64(30)
-	got := CalculateReplicas("firing", currentReplicas, maxReplicas, scaling.DefaultMinReplicas, scalingFactor)
+	got := CalculateReplicas("firing", currentReplicas, maxReplicas, scaling.DefaultMinReplicas, scalingFactor, scaling.DefaultMaxReplicas)
 	if got <= currentReplicas {
 		t.Log("Expected got > currentReplica")
 		t.Fail()
@@ -124,9 +131,201 @@ func TestScaledUpWithSmallParam(t *testing.T) {
 	currentReplicas := uint64(1)
 	maxReplicas := uint64(4)
 	scalingFactor := uint64(1)
-	got := CalculateReplicas("firing", currentReplicas, maxReplicas, scaling.DefaultMinReplicas, scalingFactor)
+	got := CalculateReplicas("firing", currentReplicas, maxReplicas, scaling.DefaultMinReplicas, scalingFactor, scaling.DefaultMaxReplicas)
 	if got <= currentReplicas {
 		t.Log("Expected got > currentReplica")
 		t.Fail()
 	}
 }
+
+func TestScale_ConfiguredCeiling(t *testing.T) {
+	minReplicas := uint64(1)
+	scalingFactor := uint64(20)
+	maxReplicas := uint64(50)
+
+	got := CalculateReplicas("firing", 5, maxReplicas, minReplicas, scalingFactor, 50)
+	if got != 15 {
+		t.Fatalf("want: %d, but got: %d", 15, got)
+	}
+
+	got = CalculateReplicas("firing", 7, maxReplicas, minReplicas, scalingFactor, 8)
+	if got != 8 {
+		t.Fatalf("want ceiling: %d, but got: %d", 8, got)
+	}
+}
+
+func TestScale_NoFunctionMaximumUsesCeiling(t *testing.T) {
+	minReplicas := uint64(1)
+	scalingFactor := uint64(20)
+
+	got := CalculateReplicas("firing", 5, 0, minReplicas, scalingFactor, 50)
+	if got != 15 {
+		t.Fatalf("want: %d, but got: %d", 15, got)
+	}
+}
+
+// alertServiceQuery holds the replicas of one function and records each
+// change
+type alertServiceQuery struct {
+	replicas  uint64
+	min       uint64
+	namespace string
+	set       []uint64
+	lock      sync.Mutex
+}
+
+func (q *alertServiceQuery) GetReplicas(service, namespace string) (scaling.ServiceQueryResponse, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return scaling.ServiceQueryResponse{Replicas: q.replicas, MinReplicas: q.min, MaxReplicas: 10, ScalingFactor: 20}, nil
+}
+
+func (q *alertServiceQuery) SetReplicas(service, namespace string, count uint64) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.replicas = count
+	q.namespace = namespace
+	q.set = append(q.set, count)
+	return nil
+}
+
+func (q *alertServiceQuery) changes() []uint64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return append([]uint64{}, q.set...)
+}
+
+func newTestAlertScaler(query scaling.ServiceQuery, step uint64, cooldown time.Duration) *AlertScaler {
+	config := AlertScalingConfig{
+		DefaultNamespace:  "openfaas-fn",
+		Ceiling:           scaling.NewReplicaCeiling(scaling.DefaultMaxReplicas*2, nil, 0),
+		ScaleDownStep:     step,
+		ScaleDownCooldown: cooldown,
+	}
+	return NewAlertScaler(config, query)
+}
+
+func testAlert(status string, startsAt time.Time) requests.PrometheusAlert {
+	return requests.PrometheusAlert{
+		Status: status,
+		Alerts: []requests.PrometheusInnerAlert{{
+			Status:      status,
+			Labels:      requests.PrometheusInnerAlertLabel{AlertName: "APIHighInvocationRate", FunctionName: "api"},
+			StartsAt:    startsAt,
+			Fingerprint: "c50a3a8e0e0b5f12",
+		}},
+	}
+}
+
+func Test_AlertScaler_ScalesDownInSteps(t *testing.T) {
+	query := &alertServiceQuery{replicas: 6, min: 1}
+	scaler := newTestAlertScaler(query, 2, time.Millisecond*10)
+
+	scaler.handleAlerts(testAlert("resolved", time.Now()))
+
+	deadline := time.Now().Add(time.Second)
+	for len(query.changes()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	got := query.changes()
+	if len(got) != 3 || got[0] != 4 || got[1] != 2 || got[2] != 1 {
+		t.Errorf("want steps [4 2 1], got: %v", got)
+	}
+}
+
+func Test_AlertScaler_FiringCancelsScaleDown(t *testing.T) {
+	query := &alertServiceQuery{replicas: 6, min: 1}
+	scaler := newTestAlertScaler(query, 2, time.Millisecond*50)
+
+	now := time.Now()
+	scaler.handleAlerts(testAlert("firing", now.Add(-time.Minute)))
+	scaler.handleAlerts(testAlert("resolved", now.Add(-time.Minute)))
+
+	next := testAlert("firing", now)
+	next.Alerts[0].Fingerprint = "d1e2"
+	scaler.handleAlerts(next)
+
+	time.Sleep(time.Millisecond * 150)
+
+	got := query.changes()
+	if len(got) != 2 || got[0] != 8 || got[1] != 10 {
+		t.Errorf("want only the scale ups [8 10], got: %v", got)
+	}
+}
+
+func Test_AlertScaler_DropsRepeatedAlerts(t *testing.T) {
+	query := &alertServiceQuery{replicas: 4, min: 1}
+	scaler := newTestAlertScaler(query, 0, 0)
+
+	startsAt := time.Now().Add(-time.Minute)
+	scaler.handleAlerts(testAlert("resolved", startsAt))
+	scaler.handleAlerts(testAlert("resolved", startsAt))
+	scaler.handleAlerts(testAlert("firing", startsAt))
+
+	got := query.changes()
+	if len(got) != 1 || got[0] != 1 {
+		t.Errorf("want one scale down to 1, got: %v", got)
+	}
+
+	scaler.handleAlerts(testAlert("firing", time.Now()))
+	if got := query.changes(); len(got) != 2 {
+		t.Errorf("want a new firing alert to scale up, got: %v", got)
+	}
+}
+
+func Test_AlertScaler_KeepsAlertsWithoutIdentity(t *testing.T) {
+	query := &alertServiceQuery{replicas: 4, min: 1}
+	scaler := newTestAlertScaler(query, 0, 0)
+
+	resolved := testAlert("resolved", time.Time{})
+	resolved.Alerts[0].Fingerprint = ""
+	firing := testAlert("firing", time.Time{})
+	firing.Alerts[0].Fingerprint = ""
+
+	scaler.handleAlerts(resolved)
+	scaler.handleAlerts(firing)
+
+	if got := query.changes(); len(got) != 2 || got[1] != 3 {
+		t.Errorf("want a scale down then a scale up to 3, got: %v", got)
+	}
+}
+
+func Test_MakeAlertHandler_NamespaceLabel(t *testing.T) {
+	query := &alertServiceQuery{replicas: 1, min: 1}
+	handler := MakeAlertHandler(newTestAlertScaler(query, 0, 0))
+
+	body := `{"status":"firing","alerts":[{"status":"firing","labels":{"alertname":"APIHighInvocationRate","function_name":"api","namespace":"staging"},"startsAt":"2024-03-15T15:52:57.805Z","fingerprint":"c50a"}]}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/system/alert", strings.NewReader(body)))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status want: %d, got: %d", http.StatusOK, rec.Code)
+	}
+	if query.namespace != "staging" {
+		t.Errorf("want function scaled in namespace staging, got: %q", query.namespace)
+	}
+}
+
+func Test_AlertScaler_ScheduledMinimum(t *testing.T) {
+	scheduler := scaling.NewReplicaScheduler(scaling.ReplicaSchedulerConfig{Ceiling: scaling.NewReplicaCeiling(20, nil, 0)}, &alertServiceQuery{})
+	scheduler.Update([]types.FunctionStatus{{
+		Name:        "api",
+		Namespace:   "openfaas-fn",
+		Replicas:    3,
+		Annotations: &map[string]string{scaling.ScheduleAnnotation: "* * * * * 3"},
+	}})
+
+	query := &alertServiceQuery{replicas: 6, min: 1}
+	scaler := newTestAlertScaler(query, 0, 0)
+	scaler.config.Schedule = scheduler
+
+	scaler.handleAlerts(testAlert("resolved", time.Now()))
+
+	if got := query.changes(); len(got) != 1 || got[0] != 3 {
+		t.Errorf("want a scale down to the scheduled 3 replicas, got: %v", got)
+	}
+}
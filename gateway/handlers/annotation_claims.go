@@ -0,0 +1,61 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/openfaas/faas-provider/types"
+)
+
+// AnnotationClaimer checks whether a function can be given the resources,
+// such as domains or routes, which are claimed in its annotations
+type AnnotationClaimer interface {
+	Claim(functionName, namespace string, annotations map[string]string) error
+}
+
+// MakeAnnotationClaimHandler rejects deployments and updates whose
+// annotations are invalid or claim a resource owned by another function,
+// with a 400 or a 409 respectively.
+func MakeAnnotationClaimHandler(next http.HandlerFunc, claimers ...AnnotationClaimer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		req := types.FunctionDeployment{}
+		if err := json.Unmarshal(body, &req); err != nil || req.Annotations == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		for _, claimer := range claimers {
+			if err := claimer.Claim(req.Service, req.Namespace, *req.Annotations); err != nil {
+				status := http.StatusConflict
+				if errors.Is(err, errInvalidDomain) || errors.Is(err, errInvalidRoute) {
+					status = http.StatusBadRequest
+				}
+				http.Error(w, err.Error(), status)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
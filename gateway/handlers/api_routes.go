@@ -0,0 +1,416 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/faas/gateway/pkg/middleware"
+)
+
+// RoutesAnnotation lists the external routes served by a function, as an
+// optional method and a path separated by commas, i.e.
+// "GET /api/users/{id},POST /api/users"
+const RoutesAnnotation = "com.openfaas.routes"
+
+// RouteParamHeaderPrefix is prepended to the name of each path parameter
+// to pass its value to the function, i.e. X-Route-Param-Id
+const RouteParamHeaderPrefix = "X-Route-Param-"
+
+var (
+	errInvalidRoute  = errors.New("invalid route")
+	errRouteConflict = errors.New("route already claimed")
+)
+
+// reservedRoutePrefixes are served by the gateway and can't be claimed,
+// along with any path below them
+var reservedRoutePrefixes = []string{"/function", "/async-function", "/system", "/ui", "/healthz"}
+
+// routeParam matches a mux path parameter such as {id} or {id:[0-9]+}
+var routeParam = regexp.MustCompile(`\{[^}]*\}`)
+
+// APIRoute sends requests for Path, and Method when set, to Function which
+// is given as function or function.namespace. The static prefix of Path
+// is removed, so that GET /api/users/{id} calls the function with /{id}.
+type APIRoute struct {
+	Method   string
+	Path     string
+	Function string
+}
+
+// pattern is the route's path with its parameters' names removed, so that
+// routes which would match the same requests compare equal
+func (a APIRoute) pattern() string {
+	return routeParam.ReplaceAllString(a.Path, "{}")
+}
+
+func (a APIRoute) String() string {
+	method := a.Method
+	if len(method) == 0 {
+		method = "*"
+	}
+	return method + " " + a.Path
+}
+
+// overlaps is true when a and b match the same method and path
+func (a APIRoute) overlaps(b APIRoute) bool {
+	return a.pattern() == b.pattern() &&
+		(a.Method == b.Method || len(a.Method) == 0 || len(b.Method) == 0)
+}
+
+// prefix is the static part of the route's path which is removed before
+// calling the function
+func (a APIRoute) prefix() string {
+	index := strings.Index(a.Path, "{")
+	if index == -1 {
+		return strings.TrimSuffix(a.Path, "/")
+	}
+	return a.Path[:strings.LastIndex(a.Path[:index], "/")]
+}
+
+// ParseAPIRoutes reads a route table with one route per line as
+// "[METHOD] PATH FUNCTION", blank lines and lines starting with # are ignored
+func ParseAPIRoutes(reader io.Reader) ([]APIRoute, error) {
+	var routes []APIRoute
+
+	scanner := bufio.NewScanner(reader)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		var route APIRoute
+		switch len(fields) {
+		case 2:
+			route = APIRoute{Path: fields[0], Function: fields[1]}
+		case 3:
+			route = APIRoute{Method: strings.ToUpper(fields[0]), Path: fields[1], Function: fields[2]}
+		default:
+			return nil, fmt.Errorf("line %d: expected [METHOD] PATH FUNCTION, got: %q", line, text)
+		}
+
+		if route.Method == "*" {
+			route.Method = ""
+		}
+		routes = append(routes, route)
+	}
+
+	return routes, scanner.Err()
+}
+
+// parseRoutesAnnotation reads the RoutesAnnotation of function, entries
+// which are not "[METHOD] PATH" are returned as errors and skipped
+func parseRoutesAnnotation(value, function string) ([]APIRoute, []error) {
+	var routes []APIRoute
+	var errs []error
+	for _, part := range strings.Split(value, ",") {
+		fields := strings.Fields(part)
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			routes = append(routes, APIRoute{Path: fields[0], Function: function})
+		case 2:
+			method := strings.ToUpper(fields[0])
+			if method == "*" {
+				method = ""
+			}
+			routes = append(routes, APIRoute{Method: method, Path: fields[1], Function: function})
+		default:
+			errs = append(errs, fmt.Errorf("%w: %q for %s in the %s annotation, expected [METHOD] PATH", errInvalidRoute, strings.TrimSpace(part), function, RoutesAnnotation))
+		}
+	}
+	return routes, errs
+}
+
+// APIRouter holds a table of APIRoute from the gateway's config and the
+// RoutesAnnotation of functions. The routes are compiled into a mux.Router
+// which is replaced when they change.
+type APIRouter struct {
+	defaultNamespace string
+	static           []APIRoute
+
+	routes    []APIRoute
+	router    *mux.Router
+	byRoute   map[*mux.Route]APIRoute
+	conflicts map[string]bool
+	lock      sync.RWMutex
+}
+
+// NewAPIRouter creates an APIRouter for the static routes, which are
+// validated and must not conflict with each other.
+func NewAPIRouter(static []APIRoute, defaultNamespace string) (*APIRouter, error) {
+	a := &APIRouter{
+		defaultNamespace: defaultNamespace,
+		conflicts:        map[string]bool{},
+	}
+
+	for _, route := range static {
+		name, namespace := middleware.GetNamespace(defaultNamespace, route.Function)
+		route.Function = name + "." + namespace
+
+		if err := validateRoute(a.static, route); err != nil {
+			return nil, err
+		}
+		a.static = append(a.static, route)
+	}
+
+	a.routes = a.static
+	a.router, a.byRoute = compileRoutes(a.routes)
+
+	return a, nil
+}
+
+// Update rebuilds the routes from the RoutesAnnotation of functions. A route
+// which is claimed by more than one function stays with its current owner,
+// or the first function in name order, and the conflict is logged. Routes
+// of functions missing from functions are removed, so it must be given a
+// complete list, as the service watcher does.
+func (a *APIRouter) Update(functions []types.FunctionStatus) {
+	sorted := make([]types.FunctionStatus, len(functions))
+	copy(sorted, functions)
+	sort.Slice(sorted, func(i, j int) bool {
+		return functionTarget(sorted[i], a.defaultNamespace) < functionTarget(sorted[j], a.defaultNamespace)
+	})
+
+	a.lock.RLock()
+	previous := map[string]bool{}
+	for _, route := range a.routes {
+		previous[route.Function+" "+route.String()] = true
+	}
+	a.lock.RUnlock()
+
+	routes := append([]APIRoute{}, a.static...)
+
+	var errs []error
+	claimed := map[string]bool{}
+
+	// Current owners claim first so that a new function can't take a
+	// route which is already in use
+	for _, currentOwners := range []bool{true, false} {
+		for _, function := range sorted {
+			if function.Annotations == nil {
+				continue
+			}
+
+			target := functionTarget(function, a.defaultNamespace)
+			parsed, parseErrs := parseRoutesAnnotation((*function.Annotations)[RoutesAnnotation], target)
+			if currentOwners {
+				errs = append(errs, parseErrs...)
+			}
+
+			for _, route := range parsed {
+				key := target + " " + route.String()
+				if previous[key] != currentOwners || claimed[key] {
+					continue
+				}
+				claimed[key] = true
+
+				if err := validateRoute(routes, route); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				routes = append(routes, route)
+			}
+		}
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	conflicts := map[string]bool{}
+	for _, err := range errs {
+		if !a.conflicts[err.Error()] {
+			log.Printf("API routes: %s", err)
+		}
+		conflicts[err.Error()] = true
+	}
+	a.conflicts = conflicts
+
+	if routeTable(routes) != routeTable(a.routes) {
+		a.routes = routes
+		a.router, a.byRoute = compileRoutes(routes)
+	}
+}
+
+// Claim checks whether the function can be given the routes in its
+// annotations, without changing the routes.
+func (a *APIRouter) Claim(functionName, namespace string, annotations map[string]string) error {
+	if len(namespace) == 0 {
+		namespace = a.defaultNamespace
+	}
+	target := functionName + "." + namespace
+
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	routes, errs := parseRoutesAnnotation(annotations[RoutesAnnotation], target)
+	if len(errs) > 0 {
+		return errs[0]
+	}
+
+	for _, route := range routes {
+		if err := validateRoute(a.routes, route); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Match is a mux.MatcherFunc which is true for requests with a path
+// served by a route
+func (a *APIRouter) Match(r *http.Request, _ *mux.RouteMatch) bool {
+	_, _, err := a.lookup(r)
+	return err != mux.ErrNotFound
+}
+
+// lookup finds the route for a request and its path parameters, err is
+// mux.ErrNotFound or mux.ErrMethodMismatch when there is no route.
+func (a *APIRouter) lookup(r *http.Request) (APIRoute, map[string]string, error) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	match := mux.RouteMatch{}
+	if !a.router.Match(r, &match) {
+		if match.MatchErr == mux.ErrMethodMismatch {
+			return APIRoute{}, nil, mux.ErrMethodMismatch
+		}
+		return APIRoute{}, nil, mux.ErrNotFound
+	}
+
+	return a.byRoute[match.Route], match.Vars, nil
+}
+
+// compileRoutes builds a mux.Router for routes, with routes which have more
+// static path segments first so that /api/users/me matches before
+// /api/users/{id}
+func compileRoutes(routes []APIRoute) (*mux.Router, map[*mux.Route]APIRoute) {
+	sorted := append([]APIRoute{}, routes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].prefix()) > len(sorted[j].prefix())
+	})
+
+	router := mux.NewRouter()
+	byRoute := map[*mux.Route]APIRoute{}
+
+	for _, route := range sorted {
+		muxRoute := router.Path(route.Path)
+		if len(route.Method) > 0 {
+			muxRoute.Methods(route.Method)
+		}
+		byRoute[muxRoute] = route
+	}
+
+	return router, byRoute
+}
+
+// MakeAPIRouteHandler serves requests matched by the APIRouter, by rewriting
+// the path to the function's route with the static prefix of the APIRoute
+// removed, passing the path parameters as headers and calling functionProxy.
+func MakeAPIRouteHandler(router *APIRouter, functionProxy http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		route, vars, err := router.lookup(r)
+		if err == mux.ErrMethodMismatch {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		} else if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		for name := range r.Header {
+			if strings.HasPrefix(name, RouteParamHeaderPrefix) {
+				r.Header.Del(name)
+			}
+		}
+		for name, value := range vars {
+			r.Header.Set(RouteParamHeaderPrefix+name, value)
+		}
+
+		// The prefix is removed from the escaped path, so that encoded
+		// characters such as %2F are passed on to the function as sent
+		rawPath := trimPathSegments(r.URL.EscapedPath(), strings.Count(route.prefix(), "/"))
+		path, err := url.PathUnescape(rawPath)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid path: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		r.URL.Path = "/function/" + route.Function + path
+		r.URL.RawPath = "/function/" + route.Function + rawPath
+
+		functionProxy(w, r)
+	}
+}
+
+// trimPathSegments removes the first n segments of path, the rest of the
+// path starts with a /
+func trimPathSegments(path string, n int) string {
+	for i := 0; i < n && len(path) > 0; i++ {
+		next := strings.Index(path[1:], "/")
+		if next == -1 {
+			return "/"
+		}
+		path = path[next+1:]
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// validateRoute checks that route is a valid mux path, does not use a path
+// served by the gateway and does not overlap a route for another function
+func validateRoute(routes []APIRoute, route APIRoute) error {
+	if !strings.HasPrefix(route.Path, "/") || route.Path == "/" {
+		return fmt.Errorf("%w: %s for %s must start with / and not be the root path", errInvalidRoute, route, route.Function)
+	}
+
+	if err := mux.NewRouter().Path(route.Path).GetError(); err != nil {
+		return fmt.Errorf("%w: %s for %s: %s", errInvalidRoute, route, route.Function, err)
+	}
+
+	for _, prefix := range reservedRoutePrefixes {
+		if route.Path == prefix || strings.HasPrefix(route.Path, prefix+"/") {
+			return fmt.Errorf("%w: %s for %s uses %s which is served by the gateway", errRouteConflict, route, route.Function, prefix)
+		}
+	}
+
+	for _, existing := range routes {
+		if existing.overlaps(route) && existing.Function != route.Function {
+			return fmt.Errorf("%w: %s for %s overlaps %s for %s", errRouteConflict, route, route.Function, existing, existing.Function)
+		}
+	}
+
+	return nil
+}
+
+// routeTable is a string which is equal for equal sets of routes
+func routeTable(routes []APIRoute) string {
+	lines := make([]string, 0, len(routes))
+	for _, route := range routes {
+		lines = append(lines, route.String()+" "+route.Function)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,192 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openfaas/faas-provider/types"
+)
+
+func Test_ParseAPIRoutes(t *testing.T) {
+	routes, err := ParseAPIRoutes(strings.NewReader(`
+# users API
+GET /api/users/{id} users
+post /api/orders orders.shop
+/api/health health
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []APIRoute{
+		{Method: "GET", Path: "/api/users/{id}", Function: "users"},
+		{Method: "POST", Path: "/api/orders", Function: "orders.shop"},
+		{Path: "/api/health", Function: "health"},
+	}
+	if len(routes) != len(want) {
+		t.Fatalf("routes want: %d, got: %d", len(want), len(routes))
+	}
+	for i := range want {
+		if routes[i] != want[i] {
+			t.Errorf("route %d want: %+v, got: %+v", i, want[i], routes[i])
+		}
+	}
+
+	if _, err := ParseAPIRoutes(strings.NewReader("GET /api/users/{id} users extra")); err == nil {
+		t.Errorf("want error for a line with too many fields")
+	}
+}
+
+func Test_NewAPIRouter_RejectsConflicts(t *testing.T) {
+	scenarios := []struct {
+		name    string
+		routes  []APIRoute
+		wantErr error
+	}{
+		{
+			name:    "same path and method for two functions",
+			routes:  []APIRoute{{Method: "GET", Path: "/api/users/{id}", Function: "users"}, {Method: "GET", Path: "/api/users/{name}", Function: "people"}},
+			wantErr: errRouteConflict,
+		},
+		{
+			name:    "any method overlaps a method",
+			routes:  []APIRoute{{Path: "/api/users", Function: "users"}, {Method: "POST", Path: "/api/users", Function: "signup"}},
+			wantErr: errRouteConflict,
+		},
+		{
+			name:    "path served by the gateway",
+			routes:  []APIRoute{{Path: "/system/functions", Function: "users"}},
+			wantErr: errRouteConflict,
+		},
+		{
+			name:    "path below a path served by the gateway",
+			routes:  []APIRoute{{Path: "/healthz/ready", Function: "users"}},
+			wantErr: errRouteConflict,
+		},
+		{
+			name:   "path sharing a prefix with a path served by the gateway",
+			routes: []APIRoute{{Path: "/healthzfoo", Function: "users"}, {Path: "/systems/{id}", Function: "inventory"}},
+		},
+		{
+			name:    "invalid path",
+			routes:  []APIRoute{{Path: "api/users", Function: "users"}},
+			wantErr: errInvalidRoute,
+		},
+		{
+			name:   "different methods for two functions",
+			routes: []APIRoute{{Method: "GET", Path: "/api/users", Function: "users"}, {Method: "POST", Path: "/api/users", Function: "signup"}},
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			_, err := NewAPIRouter(s.routes, "openfaas-fn")
+			if s.wantErr == nil && err != nil {
+				t.Errorf("want no error, got: %s", err)
+			}
+			if s.wantErr != nil && !errors.Is(err, s.wantErr) {
+				t.Errorf("want: %s, got: %v", s.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_MakeAPIRouteHandler_RewritesPath(t *testing.T) {
+	router, err := NewAPIRouter([]APIRoute{
+		{Method: "GET", Path: "/api/users/{id}", Function: "users"},
+		{Method: "GET", Path: "/api/users/me", Function: "profile"},
+		{Method: "GET", Path: "/api/files/{path:.*}", Function: "files"},
+	}, "openfaas-fn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.Update([]types.FunctionStatus{
+		{Name: "orders", Namespace: "shop", Annotations: &map[string]string{RoutesAnnotation: "POST /api/orders"}},
+	})
+
+	var gotURL, gotParam string
+	handler := MakeAPIRouteHandler(router, func(w http.ResponseWriter, r *http.Request) {
+		gotURL = r.URL.RequestURI()
+		gotParam = r.Header.Get(RouteParamHeaderPrefix + "id")
+	})
+
+	scenarios := []struct {
+		method     string
+		path       string
+		wantURL    string
+		wantParam  string
+		wantStatus int
+	}{
+		{method: http.MethodGet, path: "/api/users/42?full=1", wantURL: "/function/users.openfaas-fn/42?full=1", wantParam: "42", wantStatus: http.StatusOK},
+		{method: http.MethodGet, path: "/api/users/me", wantURL: "/function/profile.openfaas-fn/", wantStatus: http.StatusOK},
+		{method: http.MethodPost, path: "/api/orders", wantURL: "/function/orders.shop/", wantStatus: http.StatusOK},
+		{method: http.MethodGet, path: "/api/files/reports%2F2024.csv", wantURL: "/function/files.openfaas-fn/reports%2F2024.csv", wantStatus: http.StatusOK},
+		{method: http.MethodDelete, path: "/api/users/42", wantStatus: http.StatusMethodNotAllowed},
+		{method: http.MethodGet, path: "/api/invoices", wantStatus: http.StatusNotFound},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.method+" "+s.path, func(t *testing.T) {
+			gotURL, gotParam = "", ""
+			req := httptest.NewRequest(s.method, s.path, nil)
+			req.Header.Set(RouteParamHeaderPrefix+"Id", "spoofed")
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != s.wantStatus {
+				t.Errorf("status want: %d, got: %d", s.wantStatus, rec.Code)
+			}
+			if gotURL != s.wantURL {
+				t.Errorf("url want: %q, got: %q", s.wantURL, gotURL)
+			}
+			if gotParam != s.wantParam {
+				t.Errorf("param want: %q, got: %q", s.wantParam, gotParam)
+			}
+		})
+	}
+
+	if router.Match(httptest.NewRequest(http.MethodGet, "/api/invoices", nil), nil) {
+		t.Errorf("want no match for a path without a route")
+	}
+}
+
+func Test_APIRouter_Claim(t *testing.T) {
+	router, _ := NewAPIRouter([]APIRoute{{Method: "GET", Path: "/api/users/{id}", Function: "users"}}, "openfaas-fn")
+
+	err := router.Claim("people", "", map[string]string{RoutesAnnotation: "GET /api/users/{name}"})
+	if !errors.Is(err, errRouteConflict) {
+		t.Errorf("want: %s, got: %v", errRouteConflict, err)
+	}
+
+	if err := router.Claim("users", "", map[string]string{RoutesAnnotation: "GET /api/users/{id}, PUT /api/users/{id}"}); err != nil {
+		t.Errorf("want no error, got: %s", err)
+	}
+
+	err = router.Claim("users", "", map[string]string{RoutesAnnotation: "GET /api/users/{id} users"})
+	if !errors.Is(err, errInvalidRoute) {
+		t.Errorf("want: %s for a malformed entry, got: %v", errInvalidRoute, err)
+	}
+}
+
+func Test_APIRouter_Update_SkipsMalformedEntries(t *testing.T) {
+	router, _ := NewAPIRouter(nil, "openfaas-fn")
+	router.Update([]types.FunctionStatus{
+		{Name: "orders", Annotations: &map[string]string{RoutesAnnotation: "POST /api/orders extra, GET /api/orders,"}},
+	})
+
+	if len(router.routes) != 1 || router.routes[0].String() != "GET /api/orders" {
+		t.Errorf("want only the well-formed route, got: %v", router.routes)
+	}
+	if len(router.conflicts) != 1 {
+		t.Errorf("want the malformed entry logged once, got: %v", router.conflicts)
+	}
+}
@@ -0,0 +1,358 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	fhttputil "github.com/openfaas/faas-provider/httputil"
+	"github.com/openfaas/faas/gateway/metrics"
+	"github.com/openfaas/faas/gateway/pkg/middleware"
+	"github.com/openfaas/faas/gateway/scaling"
+)
+
+const (
+	// CircuitBreakerAnnotation enables a circuit breaker for a function,
+	// the value is the percentage of failed requests in the window which
+	// opens the circuit i.e. "50"
+	CircuitBreakerAnnotation = "com.openfaas.circuitbreaker"
+
+	// CircuitBreakerLatencyAnnotation counts requests slower than the given
+	// duration as failures i.e. "2s"
+	CircuitBreakerLatencyAnnotation = "com.openfaas.circuitbreaker.latency"
+
+	// CircuitBreakerWindowAnnotation is the sliding window over which the
+	// failure rate is measured, defaults to 30s
+	CircuitBreakerWindowAnnotation = "com.openfaas.circuitbreaker.window"
+
+	// CircuitBreakerRequestsAnnotation is the number of requests needed in
+	// the window before the circuit can open, defaults to 20
+	CircuitBreakerRequestsAnnotation = "com.openfaas.circuitbreaker.requests"
+
+	// CircuitBreakerOpenAnnotation is how long the circuit stays open before
+	// trial requests are let through, defaults to 30s
+	CircuitBreakerOpenAnnotation = "com.openfaas.circuitbreaker.open"
+)
+
+const (
+	// circuitBreakerBuckets is the number of buckets in the sliding window
+	circuitBreakerBuckets = 10
+
+	// circuitBreakerTrials is the number of trial requests which must
+	// succeed in turn while half-open for the circuit to close
+	circuitBreakerTrials = 3
+)
+
+// circuitState is exported as the value of GatewayFunctionCircuitBreakerState
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+// circuitBreakerConfig is read from a function's annotations
+type circuitBreakerConfig struct {
+	failureRate  float64
+	latency      time.Duration
+	window       time.Duration
+	minRequests  int
+	openDuration time.Duration
+}
+
+// circuitBucket counts the requests which completed in one part of the
+// window
+type circuitBucket struct {
+	start    time.Time
+	total    int
+	failures int
+}
+
+// circuitBreaker tracks the state of one function
+type circuitBreaker struct {
+	state    circuitState
+	openedAt time.Time
+
+	buckets [circuitBreakerBuckets]circuitBucket
+
+	// trial is true while a trial request is in-flight in the half-open
+	// state, and successes counts the trials which succeeded
+	trial     bool
+	successes int
+}
+
+// CircuitBreakers holds a circuit breaker per function
+type CircuitBreakers struct {
+	breakers map[string]*circuitBreaker
+	lock     sync.Mutex
+}
+
+// NewCircuitBreakers creates CircuitBreakers with every circuit closed
+func NewCircuitBreakers() *CircuitBreakers {
+	return &CircuitBreakers{
+		breakers: make(map[string]*circuitBreaker),
+	}
+}
+
+// allow decides whether a request can be sent to the function. When the
+// circuit is open it returns false and how long until a trial request is
+// allowed. trial is true when the request is a trial while half-open.
+func (c *CircuitBreakers) allow(key string, config circuitBreakerConfig, now time.Time) (allowed bool, trial bool, wait time.Duration, state circuitState) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	breaker, ok := c.breakers[key]
+	if !ok {
+		breaker = &circuitBreaker{}
+		c.breakers[key] = breaker
+	}
+
+	if breaker.state == circuitOpen {
+		reopen := breaker.openedAt.Add(config.openDuration)
+		if now.Before(reopen) {
+			return false, false, reopen.Sub(now), breaker.state
+		}
+
+		breaker.state = circuitHalfOpen
+		breaker.trial = false
+		breaker.successes = 0
+	}
+
+	if breaker.state == circuitHalfOpen {
+		if breaker.trial {
+			return false, false, 0, breaker.state
+		}
+		breaker.trial = true
+		return true, true, 0, breaker.state
+	}
+
+	return true, false, 0, breaker.state
+}
+
+// record adds the outcome of a request to the window and moves the circuit
+// between states
+func (c *CircuitBreakers) record(key string, config circuitBreakerConfig, now time.Time, trial bool, failed bool) circuitState {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	breaker, ok := c.breakers[key]
+	if !ok {
+		return circuitClosed
+	}
+
+	if trial {
+		breaker.trial = false
+		if failed {
+			breaker.open(now)
+			return breaker.state
+		}
+
+		breaker.successes++
+		if breaker.successes >= circuitBreakerTrials {
+			breaker.state = circuitClosed
+			breaker.buckets = [circuitBreakerBuckets]circuitBucket{}
+		}
+		return breaker.state
+	}
+
+	if breaker.state != circuitClosed {
+		return breaker.state
+	}
+
+	bucket := breaker.bucket(config.window, now)
+	bucket.total++
+	if failed {
+		bucket.failures++
+	}
+
+	total, failures := breaker.counts(config.window, now)
+	if total >= config.minRequests && float64(failures)*100 >= config.failureRate*float64(total) {
+		breaker.open(now)
+	}
+
+	return breaker.state
+}
+
+// release ends a trial without an outcome so that another can be sent
+func (c *CircuitBreakers) release(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if breaker, ok := c.breakers[key]; ok {
+		breaker.trial = false
+	}
+}
+
+func (b *circuitBreaker) open(now time.Time) {
+	b.state = circuitOpen
+	b.openedAt = now
+	b.successes = 0
+}
+
+// bucket returns the bucket for now, resetting it when it was last used
+// for an earlier part of the window
+func (b *circuitBreaker) bucket(window time.Duration, now time.Time) *circuitBucket {
+	width := window / circuitBreakerBuckets
+	if width <= 0 {
+		width = 1
+	}
+	start := now.Truncate(width)
+
+	bucket := &b.buckets[(start.UnixNano()/int64(width))%circuitBreakerBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = circuitBucket{start: start}
+	}
+	return bucket
+}
+
+// counts sums the buckets which are inside the window
+func (b *circuitBreaker) counts(window time.Duration, now time.Time) (total int, failures int) {
+	for _, bucket := range b.buckets {
+		if now.Sub(bucket.start) < window {
+			total += bucket.total
+			failures += bucket.failures
+		}
+	}
+	return total, failures
+}
+
+// MakeCircuitBreakerHandler applies a circuit breaker to functions with the
+// CircuitBreakerAnnotation. Requests which receive a 5xx status, or take
+// longer than the latency annotation, are failures. When the failure rate
+// over the window reaches the threshold the circuit opens and requests
+// receive a 503 with a Retry-After header without calling the function.
+// Once the open duration has passed, trial requests are sent one at a time
+// and the circuit closes after enough of them succeed.
+func MakeCircuitBreakerHandler(next http.HandlerFunc, breakers *CircuitBreakers, functionQuery scaling.FunctionQuery, defaultNamespace string, metricsOptions *metrics.MetricOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isUpgradeRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		functionName, namespace := middleware.GetNamespace(defaultNamespace, middleware.GetServiceName(r.URL.String()))
+
+		annotations, err := functionQuery.GetAnnotations(functionName, namespace)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		config, ok := parseCircuitBreaker(annotations)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		label := functionLabel(functionName, namespace)
+		gauge := metricsOptions.GatewayFunctionCircuitBreakerState.WithLabelValues(label)
+
+		allowed, trial, wait, state := breakers.allow(label, config, time.Now())
+		gauge.Set(float64(state))
+
+		if !allowed {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			http.Error(w, fmt.Sprintf("Circuit breaker open for function %s", functionName), http.StatusServiceUnavailable)
+			return
+		}
+
+		// A panic in next, such as http.ErrAbortHandler when the client
+		// goes away mid-response, must not leave the trial in progress
+		completed := false
+		defer func() {
+			if !completed && trial {
+				breakers.release(label)
+			}
+		}()
+
+		start := time.Now()
+		ww := fhttputil.NewHttpWriteInterceptor(w)
+		next.ServeHTTP(ww, r)
+		duration := time.Since(start)
+		completed = true
+
+		// A request cancelled by the caller says nothing about the function
+		if r.Context().Err() != nil {
+			if trial {
+				breakers.release(label)
+			}
+			return
+		}
+
+		failed := ww.Status() >= http.StatusInternalServerError
+		if config.latency > 0 && duration > config.latency && !strings.HasPrefix(r.Header.Get("Accept"), "text/event-stream") {
+			failed = true
+		}
+
+		state = breakers.record(label, config, time.Now(), trial, failed)
+		gauge.Set(float64(state))
+	}
+}
+
+// parseCircuitBreaker reads the circuit breaker annotations, ok is false
+// when the function has no valid circuit breaker
+func parseCircuitBreaker(annotations map[string]string) (circuitBreakerConfig, bool) {
+	config := circuitBreakerConfig{
+		window:       time.Second * 30,
+		minRequests:  20,
+		openDuration: time.Second * 30,
+	}
+
+	value, exists := annotations[CircuitBreakerAnnotation]
+	if !exists {
+		return config, false
+	}
+
+	failureRate, err := strconv.ParseFloat(value, 64)
+	if err != nil || failureRate <= 0 || failureRate > 100 {
+		log.Printf("Invalid %s annotation: %q", CircuitBreakerAnnotation, value)
+		return config, false
+	}
+	config.failureRate = failureRate
+
+	durations := []struct {
+		annotation string
+		value      *time.Duration
+	}{
+		{annotation: CircuitBreakerLatencyAnnotation, value: &config.latency},
+		{annotation: CircuitBreakerWindowAnnotation, value: &config.window},
+		{annotation: CircuitBreakerOpenAnnotation, value: &config.openDuration},
+	}
+	for _, d := range durations {
+		if value, exists := annotations[d.annotation]; exists {
+			duration, err := parseTimeoutAnnotation(value)
+			if err != nil {
+				log.Printf("Invalid %s annotation: %q", d.annotation, value)
+				continue
+			}
+			*d.value = duration
+		}
+	}
+
+	if value, exists := annotations[CircuitBreakerRequestsAnnotation]; exists {
+		val, err := strconv.Atoi(value)
+		if err != nil || val < 1 {
+			log.Printf("Invalid %s annotation: %q", CircuitBreakerRequestsAnnotation, value)
+		} else {
+			config.minRequests = val
+		}
+	}
+
+	return config, true
+}
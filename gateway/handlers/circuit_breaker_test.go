@@ -0,0 +1,213 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/metrics"
+)
+
+func Test_parseCircuitBreaker(t *testing.T) {
+	config, ok := parseCircuitBreaker(map[string]string{
+		CircuitBreakerAnnotation:         "50",
+		CircuitBreakerLatencyAnnotation:  "2s",
+		CircuitBreakerRequestsAnnotation: "5",
+		CircuitBreakerOpenAnnotation:     "10",
+	})
+	if !ok {
+		t.Fatalf("want circuit breaker enabled")
+	}
+
+	want := circuitBreakerConfig{failureRate: 50, latency: time.Second * 2, window: time.Second * 30, minRequests: 5, openDuration: time.Second * 10}
+	if config != want {
+		t.Errorf("want: %+v, got: %+v", want, config)
+	}
+
+	for _, value := range []string{"0", "101", "half"} {
+		if _, ok := parseCircuitBreaker(map[string]string{CircuitBreakerAnnotation: value}); ok {
+			t.Errorf("want %q rejected", value)
+		}
+	}
+}
+
+func Test_CircuitBreakers_OpensAndCloses(t *testing.T) {
+	breakers := NewCircuitBreakers()
+	config := circuitBreakerConfig{failureRate: 50, window: time.Second * 10, minRequests: 4, openDuration: time.Second * 5}
+	now := time.Now()
+
+	for i, failed := range []bool{false, true, false} {
+		breakers.allow("fn", config, now)
+		if state := breakers.record("fn", config, now, false, failed); state != circuitClosed {
+			t.Fatalf("request %d want closed below the minimum requests, got: %d", i, state)
+		}
+	}
+
+	breakers.allow("fn", config, now)
+	if state := breakers.record("fn", config, now, false, true); state != circuitOpen {
+		t.Fatalf("want open at a 50%% failure rate, got: %d", state)
+	}
+
+	allowed, _, wait, _ := breakers.allow("fn", config, now.Add(time.Second))
+	if allowed || wait != time.Second*4 {
+		t.Fatalf("want request rejected for 4s while open, got: %t %s", allowed, wait)
+	}
+
+	now = now.Add(config.openDuration)
+	for i := 0; i < circuitBreakerTrials; i++ {
+		allowed, trial, _, state := breakers.allow("fn", config, now)
+		if !allowed || !trial || state != circuitHalfOpen {
+			t.Fatalf("trial %d want allowed while half-open, got: %t %t %d", i, allowed, trial, state)
+		}
+
+		if allowed, _, _, _ := breakers.allow("fn", config, now); allowed {
+			t.Fatalf("trial %d want a second request rejected during the trial", i)
+		}
+
+		breakers.record("fn", config, now, true, false)
+	}
+
+	if _, _, _, state := breakers.allow("fn", config, now); state != circuitClosed {
+		t.Errorf("want closed after the trials succeeded, got: %d", state)
+	}
+}
+
+func Test_CircuitBreakers_FailedTrialReopens(t *testing.T) {
+	breakers := NewCircuitBreakers()
+	config := circuitBreakerConfig{failureRate: 100, window: time.Second * 10, minRequests: 1, openDuration: time.Second}
+	now := time.Now()
+
+	breakers.allow("fn", config, now)
+	breakers.record("fn", config, now, false, true)
+
+	now = now.Add(time.Second)
+	_, trial, _, _ := breakers.allow("fn", config, now)
+	if state := breakers.record("fn", config, now, trial, true); state != circuitOpen {
+		t.Errorf("want open after a failed trial, got: %d", state)
+	}
+}
+
+func Test_CircuitBreakers_FailuresLeaveTheWindow(t *testing.T) {
+	breakers := NewCircuitBreakers()
+	config := circuitBreakerConfig{failureRate: 50, window: time.Second * 10, minRequests: 2, openDuration: time.Second}
+	now := time.Now()
+
+	breakers.allow("fn", config, now)
+	breakers.record("fn", config, now, false, true)
+
+	now = now.Add(config.window)
+	breakers.allow("fn", config, now)
+	if state := breakers.record("fn", config, now, false, false); state != circuitClosed {
+		t.Errorf("want closed once the earlier failure left the window, got: %d", state)
+	}
+}
+
+func Test_MakeCircuitBreakerHandler_FailsFast(t *testing.T) {
+	metricsOptions := metrics.BuildMetricsOptions()
+	query := fakeFunctionQuery{annotations: map[string]string{
+		CircuitBreakerAnnotation:         "50",
+		CircuitBreakerRequestsAnnotation: "2",
+		CircuitBreakerOpenAnnotation:     "30s",
+	}}
+
+	calls := 0
+	next := func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	handler := MakeCircuitBreakerHandler(next, NewCircuitBreakers(), query, "openfaas-fn", &metricsOptions)
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/function/payments", nil))
+
+		if i < 2 && rec.Code != http.StatusBadGateway {
+			t.Errorf("request %d want the function's status %d, got: %d", i, http.StatusBadGateway, rec.Code)
+		}
+		if i == 2 {
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Errorf("want: %d while open, got: %d", http.StatusServiceUnavailable, rec.Code)
+			}
+			if got := rec.Header().Get("Retry-After"); got != "30" {
+				t.Errorf("Retry-After want: 30, got: %q", got)
+			}
+		}
+	}
+
+	if calls != 2 {
+		t.Errorf("function calls want: %d, got: %d", 2, calls)
+	}
+
+	state := gaugeValue(metricsOptions.GatewayFunctionCircuitBreakerState.WithLabelValues("payments.openfaas-fn"))
+	if state != float64(circuitOpen) {
+		t.Errorf("state gauge want: %d, got: %f", circuitOpen, state)
+	}
+}
+
+func Test_MakeCircuitBreakerHandler_PanicReleasesTrial(t *testing.T) {
+	metricsOptions := metrics.BuildMetricsOptions()
+	query := fakeFunctionQuery{annotations: map[string]string{
+		CircuitBreakerAnnotation:         "50",
+		CircuitBreakerRequestsAnnotation: "1",
+		CircuitBreakerOpenAnnotation:     "10ms",
+	}}
+
+	panics := false
+	calls := 0
+	next := func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if panics {
+			panic(http.ErrAbortHandler)
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	handler := MakeCircuitBreakerHandler(next, NewCircuitBreakers(), query, "openfaas-fn", &metricsOptions)
+	serve := func() {
+		defer func() { recover() }()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/function/payments", nil))
+	}
+
+	serve()
+	time.Sleep(time.Millisecond * 20)
+
+	// The trial panics, as when the client goes away mid-response
+	panics = true
+	serve()
+
+	panics = false
+	serve()
+	if calls != 3 {
+		t.Errorf("want another trial after the panic, function calls: %d", calls)
+	}
+}
+
+func Test_MakeCircuitBreakerHandler_SlowRequestsFail(t *testing.T) {
+	metricsOptions := metrics.BuildMetricsOptions()
+	query := fakeFunctionQuery{annotations: map[string]string{
+		CircuitBreakerAnnotation:         "100",
+		CircuitBreakerRequestsAnnotation: "1",
+		CircuitBreakerLatencyAnnotation:  "10ms",
+	}}
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond * 20)
+		w.WriteHeader(http.StatusOK)
+	}
+
+	handler := MakeCircuitBreakerHandler(next, NewCircuitBreakers(), query, "openfaas-fn", &metricsOptions)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/function/reports", nil))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/function/reports", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("want: %d after a slow request, got: %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
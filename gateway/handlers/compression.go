@@ -0,0 +1,308 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressionOptions control which responses are compressed
+type CompressionOptions struct {
+	// MinSize is the smallest response body in bytes which is compressed
+	MinSize int
+
+	// ContentTypes which can be compressed, entries ending in / match all
+	// subtypes i.e. "text/"
+	ContentTypes []string
+}
+
+// compressEncoder is implemented by the gzip, brotli and zstd writers
+type compressEncoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// compressEncodings are the supported encodings in order of preference when
+// a client accepts more than one with the same weight
+var compressEncodings = []string{"zstd", "br", "gzip"}
+
+var compressPools = map[string]*sync.Pool{
+	"gzip": {New: func() any {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	}},
+	"br": {New: func() any {
+		return brotli.NewWriterLevel(nil, 5)
+	}},
+	"zstd": {New: func() any {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return w
+	}},
+}
+
+// MakeCompressionHandler compresses responses with gzip, brotli or zstd
+// according to the Accept-Encoding header of the request. Responses which
+// are smaller than MinSize, have a Content-Type outside of ContentTypes or
+// already have a Content-Encoding are passed through unchanged. Server-sent
+// events and responses which are flushed before MinSize bytes are written
+// are streamed without being buffered. Accept-Encoding is added to the Vary
+// header of responses from clients which accept an encoding, once the
+// headers of the function have been written.
+func MakeCompressionHandler(next http.HandlerFunc, options CompressionOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if len(encoding) == 0 ||
+			r.Method == http.MethodHead ||
+			len(r.Header.Get("Range")) > 0 ||
+			isUpgradeRequest(r) ||
+			strings.HasPrefix(r.Header.Get("Accept"), "text/event-stream") {
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{
+			ResponseWriter: w,
+			encoding:       encoding,
+			options:        &options,
+		}
+		defer cw.finish()
+
+		next.ServeHTTP(cw, r)
+	}
+}
+
+// compressWriter holds back the status and up to MinSize bytes of the
+// body until it can decide whether to compress the response
+type compressWriter struct {
+	http.ResponseWriter
+
+	encoding string
+	options  *CompressionOptions
+
+	status  int
+	decided bool
+	encoder compressEncoder
+	buf     []byte
+}
+
+func (c *compressWriter) WriteHeader(code int) {
+	if c.status != 0 || c.decided {
+		return
+	}
+
+	if code >= 100 && code < 200 {
+		c.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	c.status = code
+
+	header := c.Header()
+	switch {
+	case code == http.StatusNoContent || code == http.StatusNotModified:
+		c.passthrough()
+	case len(header.Get("Content-Encoding")) > 0:
+		c.passthrough()
+	case len(header.Get("Content-Type")) > 0 && !c.compressible(header.Get("Content-Type")):
+		c.passthrough()
+	case len(header.Get("Content-Length")) > 0:
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		if err != nil || length < c.options.MinSize || len(header.Get("Content-Type")) == 0 {
+			c.passthrough()
+		} else {
+			c.compress()
+		}
+	}
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+
+	if c.decided {
+		if c.encoder != nil {
+			return c.encoder.Write(p)
+		}
+		return c.ResponseWriter.Write(p)
+	}
+
+	c.buf = append(c.buf, p...)
+	if len(c.buf) >= c.options.MinSize {
+		header := c.Header()
+		if len(header.Get("Content-Type")) == 0 {
+			header.Set("Content-Type", http.DetectContentType(c.buf))
+		}
+
+		if c.compressible(header.Get("Content-Type")) {
+			c.compress()
+		} else {
+			c.passthrough()
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush sends any buffered data to the client, a response which is
+// flushed before a decision has been made is not compressed so that
+// streaming responses are never held back.
+func (c *compressWriter) Flush() {
+	if !c.decided && c.status != 0 {
+		c.passthrough()
+	}
+
+	if c.encoder != nil {
+		c.encoder.Flush()
+	}
+
+	http.NewResponseController(c.ResponseWriter).Flush()
+}
+
+// Hijack allows the connection to be taken over when the response has not
+// been started.
+func (c *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(c.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the original http.ResponseWriter for http.ResponseController
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// finish writes any buffered response which was below MinSize and closes
+// the encoder
+func (c *compressWriter) finish() {
+	if !c.decided && c.status != 0 {
+		c.passthrough()
+	}
+
+	if c.encoder != nil {
+		c.encoder.Close()
+		c.encoder.Reset(nil)
+		compressPools[c.encoding].Put(c.encoder)
+		c.encoder = nil
+	}
+}
+
+func (c *compressWriter) passthrough() {
+	c.decided = true
+	addVary(c.Header(), "Accept-Encoding")
+	c.ResponseWriter.WriteHeader(c.status)
+
+	if len(c.buf) > 0 {
+		c.ResponseWriter.Write(c.buf)
+		c.buf = nil
+	}
+}
+
+func (c *compressWriter) compress() {
+	c.decided = true
+
+	header := c.Header()
+	addVary(header, "Accept-Encoding")
+	header.Del("Content-Length")
+	header.Set("Content-Encoding", c.encoding)
+	if etag := header.Get("ETag"); len(etag) > 0 && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+
+	c.ResponseWriter.WriteHeader(c.status)
+
+	c.encoder = compressPools[c.encoding].Get().(compressEncoder)
+	c.encoder.Reset(c.ResponseWriter)
+
+	if len(c.buf) > 0 {
+		c.encoder.Write(c.buf)
+		c.buf = nil
+	}
+}
+
+func (c *compressWriter) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	if mediaType == "text/event-stream" {
+		return false
+	}
+
+	for _, allowed := range c.options.ContentTypes {
+		if strings.HasSuffix(allowed, "/") && strings.HasPrefix(mediaType, allowed) {
+			return true
+		}
+		if mediaType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// addVary adds name to the Vary header, keeping the values set by the
+// function
+func addVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, name) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", name)
+}
+
+// negotiateEncoding picks the supported encoding with the highest weight
+// in an Accept-Encoding header, or an empty string when none is acceptable
+func negotiateEncoding(acceptEncoding string) string {
+	if len(acceptEncoding) == 0 {
+		return ""
+	}
+
+	weights := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		weight := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if q, err := strconv.ParseFloat(value, 64); err == nil {
+				weight = q
+			}
+		}
+		weights[name] = weight
+	}
+
+	best := ""
+	bestWeight := 0.0
+	for _, encoding := range compressEncodings {
+		weight, ok := weights[encoding]
+		if !ok {
+			weight = weights["*"]
+		}
+
+		if weight > bestWeight {
+			best = encoding
+			bestWeight = weight
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,196 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+var testCompressionOptions = CompressionOptions{
+	MinSize:      64,
+	ContentTypes: []string{"text/", "application/json"},
+}
+
+func Test_negotiateEncoding(t *testing.T) {
+	scenarios := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{acceptEncoding: "", want: ""},
+		{acceptEncoding: "gzip", want: "gzip"},
+		{acceptEncoding: "gzip, deflate, br, zstd", want: "zstd"},
+		{acceptEncoding: "gzip;q=1.0, br;q=0.5", want: "gzip"},
+		{acceptEncoding: "zstd;q=0, br", want: "br"},
+		{acceptEncoding: "*", want: "zstd"},
+		{acceptEncoding: "identity", want: ""},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.acceptEncoding, func(t *testing.T) {
+			if got := negotiateEncoding(s.acceptEncoding); got != s.want {
+				t.Errorf("want: %q, got: %q", s.want, got)
+			}
+		})
+	}
+}
+
+func Test_MakeCompressionHandler_CompressesLargeJSON(t *testing.T) {
+	body := `{"items":"` + strings.Repeat("a", 512) + `"}`
+	next := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", "525")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(body))
+	}
+
+	decoders := map[string]func(io.Reader) io.Reader{
+		"gzip": func(r io.Reader) io.Reader { gr, _ := gzip.NewReader(r); return gr },
+		"br":   func(r io.Reader) io.Reader { return brotli.NewReader(r) },
+		"zstd": func(r io.Reader) io.Reader { zr, _ := zstd.NewReader(r); return zr },
+	}
+
+	for encoding, decode := range decoders {
+		t.Run(encoding, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/function/echo", nil)
+			req.Header.Set("Accept-Encoding", encoding)
+			rec := httptest.NewRecorder()
+
+			MakeCompressionHandler(next, testCompressionOptions).ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Content-Encoding"); got != encoding {
+				t.Fatalf("Content-Encoding want: %s, got: %q", encoding, got)
+			}
+			if got := rec.Header().Get("Content-Length"); got != "" {
+				t.Errorf("want Content-Length removed, got: %s", got)
+			}
+			if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary want: Accept-Encoding, got: %q", got)
+			}
+
+			decoded, err := io.ReadAll(decode(rec.Body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(decoded) != body {
+				t.Errorf("decoded body want: %d bytes, got: %d bytes", len(body), len(decoded))
+			}
+		})
+	}
+}
+
+func Test_MakeCompressionHandler_PassesThrough(t *testing.T) {
+	large := strings.Repeat("a", 512)
+
+	scenarios := []struct {
+		name        string
+		contentType string
+		encoding    string
+		body        string
+	}{
+		{name: "below minimum size", contentType: "text/plain", body: "hello"},
+		{name: "content type not allowed", contentType: "image/png", body: large},
+		{name: "already encoded", contentType: "text/plain", encoding: "gzip", body: large},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", s.contentType)
+				if len(s.encoding) > 0 {
+					w.Header().Set("Content-Encoding", s.encoding)
+				}
+				w.Write([]byte(s.body))
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/function/echo", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			rec := httptest.NewRecorder()
+
+			MakeCompressionHandler(next, testCompressionOptions).ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Content-Encoding"); got != s.encoding {
+				t.Errorf("Content-Encoding want: %q, got: %q", s.encoding, got)
+			}
+			if rec.Body.String() != s.body {
+				t.Errorf("body want: %d bytes, got: %d bytes", len(s.body), rec.Body.Len())
+			}
+		})
+	}
+}
+
+func Test_MakeCompressionHandler_MergesVary(t *testing.T) {
+	large := strings.Repeat("a", 512)
+
+	scenarios := []struct {
+		name        string
+		contentType string
+		vary        []string
+		wantVary    []string
+	}{
+		{name: "compressed", contentType: "text/plain", vary: []string{"Origin"}, wantVary: []string{"Origin", "Accept-Encoding"}},
+		{name: "passed through", contentType: "image/png", vary: []string{"Origin"}, wantVary: []string{"Origin", "Accept-Encoding"}},
+		{name: "already listed", contentType: "text/plain", vary: []string{"Origin, accept-encoding"}, wantVary: []string{"Origin, accept-encoding"}},
+		{name: "no Vary from the function", contentType: "text/plain", wantVary: []string{"Accept-Encoding"}},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			// Headers are copied from the upstream response, replacing any
+			// value set before next was called
+			next := func(w http.ResponseWriter, r *http.Request) {
+				upstream := http.Header{"Content-Type": {s.contentType}}
+				if len(s.vary) > 0 {
+					upstream["Vary"] = s.vary
+				}
+				copyHeaders(w.Header(), &upstream)
+				w.Write([]byte(large))
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/function/echo", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			rec := httptest.NewRecorder()
+
+			MakeCompressionHandler(next, testCompressionOptions).ServeHTTP(rec, req)
+
+			if got := rec.Header().Values("Vary"); strings.Join(got, "|") != strings.Join(s.wantVary, "|") {
+				t.Errorf("Vary want: %q, got: %q", s.wantVary, got)
+			}
+		})
+	}
+}
+
+func Test_MakeCompressionHandler_DoesNotBufferEventStream(t *testing.T) {
+	flushed := make(chan string, 1)
+	next := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("data: 1\n\n"))
+		w.(http.Flusher).Flush()
+
+		flushed <- w.Header().Get("Content-Encoding")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/function/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+
+	MakeCompressionHandler(next, testCompressionOptions).ServeHTTP(rec, req)
+
+	if got := <-flushed; got != "" {
+		t.Errorf("want event stream not to be encoded, got: %s", got)
+	}
+	if !rec.Flushed || rec.Body.String() != "data: 1\n\n" {
+		t.Errorf("want event flushed unchanged, got: %q flushed: %t", rec.Body.String(), rec.Flushed)
+	}
+}
@@ -0,0 +1,233 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/openfaas/faas/gateway/metrics"
+	"github.com/openfaas/faas/gateway/pkg/middleware"
+	"github.com/openfaas/faas/gateway/scaling"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// ConcurrencyMaxAnnotation is the maximum number of in-flight requests
+	// the gateway will send to a function
+	ConcurrencyMaxAnnotation = "com.openfaas.concurrency.max"
+
+	// ConcurrencyQueueAnnotation is the number of requests which can wait
+	// for a slot once the maximum is reached
+	ConcurrencyQueueAnnotation = "com.openfaas.concurrency.queue"
+
+	// ConcurrencyQueueTimeoutAnnotation is how long a request can wait in
+	// the queue, as a Go duration or number of seconds
+	ConcurrencyQueueTimeoutAnnotation = "com.openfaas.concurrency.queue.timeout"
+)
+
+const (
+	defaultConcurrencyQueue        = 100
+	defaultConcurrencyQueueTimeout = time.Second * 30
+)
+
+var (
+	errConcurrencyQueueFull    = errors.New("queue is full")
+	errConcurrencyQueueTimeout = errors.New("timed out waiting in queue")
+)
+
+// concurrencyLimit counts in-flight requests for one function and holds
+// a FIFO queue of requests waiting for a slot.
+type concurrencyLimit struct {
+	lock     sync.Mutex
+	inflight int
+	max      int
+	waiters  *list.List
+}
+
+// ConcurrencyLimiter limits in-flight requests per function
+type ConcurrencyLimiter struct {
+	limits map[string]*concurrencyLimit
+	lock   sync.Mutex
+}
+
+// NewConcurrencyLimiter creates a ConcurrencyLimiter with no limits
+func NewConcurrencyLimiter() *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		limits: make(map[string]*concurrencyLimit),
+	}
+}
+
+func (c *ConcurrencyLimiter) get(key string) *concurrencyLimit {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	limit, ok := c.limits[key]
+	if !ok {
+		limit = &concurrencyLimit{waiters: list.New()}
+		c.limits[key] = limit
+	}
+	return limit
+}
+
+// Acquire takes a slot for key, waiting in a queue of up to queueSize
+// requests for at most timeout when all max slots are in use. The queue
+// depth is tracked in depth. Release must be called once for each
+// successful Acquire.
+func (c *ConcurrencyLimiter) Acquire(ctx context.Context, key string, max, queueSize int, timeout time.Duration, depth prometheus.Gauge) error {
+	limit := c.get(key)
+
+	limit.lock.Lock()
+	limit.max = max
+
+	if limit.inflight < max && limit.waiters.Len() == 0 {
+		limit.inflight++
+		limit.lock.Unlock()
+		return nil
+	}
+
+	if limit.waiters.Len() >= queueSize {
+		limit.lock.Unlock()
+		return errConcurrencyQueueFull
+	}
+
+	ready := make(chan struct{})
+	elem := limit.waiters.PushBack(ready)
+	depth.Inc()
+	limit.lock.Unlock()
+
+	defer depth.Dec()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-ready:
+		return nil
+	case <-timer.C:
+		err = errConcurrencyQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	limit.lock.Lock()
+	defer limit.lock.Unlock()
+
+	// A slot may have been handed over at the same time as giving up
+	select {
+	case <-ready:
+		return nil
+	default:
+	}
+
+	limit.waiters.Remove(elem)
+	return err
+}
+
+// Release gives back a slot for key, handing it to the next request in
+// the queue if there is one.
+func (c *ConcurrencyLimiter) Release(key string) {
+	limit := c.get(key)
+
+	limit.lock.Lock()
+	defer limit.lock.Unlock()
+
+	limit.inflight--
+	for limit.inflight < limit.max && limit.waiters.Len() > 0 {
+		front := limit.waiters.Front()
+		limit.waiters.Remove(front)
+		close(front.Value.(chan struct{}))
+		limit.inflight++
+	}
+}
+
+// MakeConcurrencyLimitHandler limits the number of in-flight requests to
+// functions which have the ConcurrencyMaxAnnotation. Requests over the
+// limit wait in a bounded FIFO queue, and receive a 429 when the queue is
+// full or the wait times out.
+func MakeConcurrencyLimitHandler(next http.HandlerFunc, limiter *ConcurrencyLimiter, functionQuery scaling.FunctionQuery, defaultNamespace string, metricsOptions *metrics.MetricOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName, namespace := middleware.GetNamespace(defaultNamespace, middleware.GetServiceName(r.URL.String()))
+
+		annotations, err := functionQuery.GetAnnotations(functionName, namespace)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		max, queueSize, timeout, ok := parseConcurrencyLimit(annotations)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		label := functionLabel(functionName, namespace)
+		key := fmt.Sprintf("%s.%s", functionName, namespace)
+
+		start := time.Now()
+		err = limiter.Acquire(r.Context(), key, max, queueSize, timeout,
+			metricsOptions.GatewayFunctionQueueDepth.WithLabelValues(label))
+
+		metricsOptions.GatewayFunctionQueueWaitHistogram.
+			WithLabelValues(label).
+			Observe(time.Since(start).Seconds())
+
+		if err != nil {
+			log.Printf("Concurrency limit for %s: %s", key, err.Error())
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, fmt.Sprintf("Concurrency limit exceeded for function %s: %s", functionName, err.Error()), http.StatusTooManyRequests)
+			return
+		}
+		defer limiter.Release(key)
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// parseConcurrencyLimit reads the concurrency annotations, ok is false when
+// the function has no valid limit.
+func parseConcurrencyLimit(annotations map[string]string) (max, queueSize int, timeout time.Duration, ok bool) {
+	maxValue, exists := annotations[ConcurrencyMaxAnnotation]
+	if !exists {
+		return 0, 0, 0, false
+	}
+
+	max, err := strconv.Atoi(maxValue)
+	if err != nil || max < 1 {
+		log.Printf("Invalid %s annotation: %q", ConcurrencyMaxAnnotation, maxValue)
+		return 0, 0, 0, false
+	}
+
+	queueSize = defaultConcurrencyQueue
+	if queueValue, exists := annotations[ConcurrencyQueueAnnotation]; exists {
+		val, err := strconv.Atoi(queueValue)
+		if err != nil || val < 0 {
+			log.Printf("Invalid %s annotation: %q", ConcurrencyQueueAnnotation, queueValue)
+		} else {
+			queueSize = val
+		}
+	}
+
+	timeout = defaultConcurrencyQueueTimeout
+	if timeoutValue, exists := annotations[ConcurrencyQueueTimeoutAnnotation]; exists {
+		val, err := parseTimeoutAnnotation(timeoutValue)
+		if err != nil {
+			log.Printf("Invalid %s annotation: %q", ConcurrencyQueueTimeoutAnnotation, timeoutValue)
+		} else {
+			timeout = val
+		}
+	}
+
+	return max, queueSize, timeout, true
+}
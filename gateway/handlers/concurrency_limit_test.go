@@ -0,0 +1,131 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func Test_ConcurrencyLimiter_ReleasesWaitersInOrder(t *testing.T) {
+	limiter := NewConcurrencyLimiter()
+	depth := prometheus.NewGauge(prometheus.GaugeOpts{Name: "depth"})
+
+	if err := limiter.Acquire(context.Background(), "fn", 1, 2, time.Second, depth); err != nil {
+		t.Fatal(err)
+	}
+
+	order := make(chan int, 2)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := limiter.Acquire(context.Background(), "fn", 1, 2, time.Second, depth); err != nil {
+				t.Errorf("waiter %d: %s", i, err)
+				return
+			}
+			order <- i
+			limiter.Release("fn")
+		}(i)
+
+		// Wait for the waiter to be queued before adding the next
+		waitForGauge(t, depth, float64(i+1))
+	}
+
+	if err := limiter.Acquire(context.Background(), "fn", 1, 2, time.Second, depth); err != errConcurrencyQueueFull {
+		t.Errorf("want: %s, got: %v", errConcurrencyQueueFull, err)
+	}
+
+	limiter.Release("fn")
+	wg.Wait()
+	close(order)
+
+	want := 0
+	for got := range order {
+		if got != want {
+			t.Errorf("release order want: %d, got: %d", want, got)
+		}
+		want++
+	}
+}
+
+func Test_ConcurrencyLimiter_TimesOutInQueue(t *testing.T) {
+	limiter := NewConcurrencyLimiter()
+	depth := prometheus.NewGauge(prometheus.GaugeOpts{Name: "depth"})
+
+	limiter.Acquire(context.Background(), "fn", 1, 1, time.Second, depth)
+
+	err := limiter.Acquire(context.Background(), "fn", 1, 1, time.Millisecond*10, depth)
+	if err != errConcurrencyQueueTimeout {
+		t.Errorf("want: %s, got: %v", errConcurrencyQueueTimeout, err)
+	}
+
+	if got := gaugeValue(depth); got != 0 {
+		t.Errorf("queue depth want: %f, got: %f", 0.0, got)
+	}
+
+	// The timed-out waiter must not have been given the slot
+	limiter.Release("fn")
+	if err := limiter.Acquire(context.Background(), "fn", 1, 0, time.Millisecond, depth); err != nil {
+		t.Errorf("slot should be free after release, got: %s", err)
+	}
+}
+
+func Test_MakeConcurrencyLimitHandler_Returns429WhenQueueFull(t *testing.T) {
+	metricsOptions := metrics.BuildMetricsOptions()
+	query := fakeFunctionQuery{annotations: map[string]string{
+		ConcurrencyMaxAnnotation:   "1",
+		ConcurrencyQueueAnnotation: "0",
+	}}
+
+	inflight := make(chan struct{})
+	done := make(chan struct{})
+	next := func(w http.ResponseWriter, r *http.Request) {
+		close(inflight)
+		<-done
+	}
+
+	handler := MakeConcurrencyLimitHandler(next, NewConcurrencyLimiter(), query, "openfaas-fn", &metricsOptions)
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/function/echo", nil))
+	<-inflight
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/function/echo", nil))
+	close(done)
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status want: %d, got: %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Errorf("want Retry-After header")
+	}
+}
+
+func waitForGauge(t *testing.T, g prometheus.Gauge, want float64) {
+	deadline := time.Now().Add(time.Second)
+	for gaugeValue(g) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("gauge want: %f, got: %f", want, gaugeValue(g))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func gaugeValue(g prometheus.Gauge) float64 {
+	m := &dto.Metric{}
+	g.Write(m)
+	return m.GetGauge().GetValue()
+}
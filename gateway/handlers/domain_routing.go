@@ -0,0 +1,254 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/faas/gateway/pkg/middleware"
+)
+
+// DomainAnnotation lists the host names which route directly to a function,
+// separated by commas i.e. "api.example.com,www.example.com"
+const DomainAnnotation = "com.openfaas.domain"
+
+var (
+	errInvalidDomain  = errors.New("invalid domain")
+	errDomainConflict = errors.New("domain already claimed")
+)
+
+// DomainRouter maps the Host header of a request to a function, from
+// static routes, function annotations and an optional domain suffix under
+// which {function}.{namespace}.{suffix} routes to the function.
+type DomainRouter struct {
+	defaultNamespace string
+	suffix           string
+	static           map[string]string
+
+	routes    map[string]string
+	conflicts map[string]bool
+	lock      sync.RWMutex
+}
+
+// NewDomainRouter creates a DomainRouter, static maps host names to a
+// function given as function or function.namespace
+func NewDomainRouter(suffix string, static map[string]string, defaultNamespace string) *DomainRouter {
+	d := &DomainRouter{
+		defaultNamespace: defaultNamespace,
+		suffix:           strings.ToLower(strings.Trim(suffix, ".")),
+		static:           map[string]string{},
+		routes:           map[string]string{},
+		conflicts:        map[string]bool{},
+	}
+
+	for host, function := range static {
+		name, namespace := middleware.GetNamespace(defaultNamespace, function)
+		d.static[normaliseHost(host)] = name + "." + namespace
+	}
+
+	for host, target := range d.static {
+		d.routes[host] = target
+	}
+
+	return d
+}
+
+// Update rebuilds the routes from the DomainAnnotation of functions. A host
+// which is claimed by more than one function stays with its current owner,
+// or the first function in name order, and the conflict is logged. The
+// service watcher only passes a complete list of functions, so that the
+// routes of a namespace which failed to list are not dropped.
+func (d *DomainRouter) Update(functions []types.FunctionStatus) {
+	sorted := make([]types.FunctionStatus, len(functions))
+	copy(sorted, functions)
+	sort.Slice(sorted, func(i, j int) bool {
+		return functionTarget(sorted[i], d.defaultNamespace) < functionTarget(sorted[j], d.defaultNamespace)
+	})
+
+	d.lock.RLock()
+	previous := d.routes
+	d.lock.RUnlock()
+
+	routes := map[string]string{}
+	for host, target := range d.static {
+		routes[host] = target
+	}
+
+	var errs []error
+	claimed := map[string]bool{}
+
+	// Current owners claim first so that a new function can't take a
+	// domain which is already in use
+	for _, currentOwners := range []bool{true, false} {
+		for _, function := range sorted {
+			if function.Annotations == nil {
+				continue
+			}
+
+			target := functionTarget(function, d.defaultNamespace)
+			for _, host := range parseDomains((*function.Annotations)[DomainAnnotation]) {
+				if (previous[host] == target) != currentOwners || claimed[target+"/"+host] {
+					continue
+				}
+				claimed[target+"/"+host] = true
+
+				if err := d.validateClaim(routes, host, target); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				routes[host] = target
+			}
+		}
+	}
+
+	d.lock.Lock()
+	d.routes = routes
+	conflicts := map[string]bool{}
+	for _, err := range errs {
+		if !d.conflicts[err.Error()] {
+			log.Printf("Domain routing: %s", err)
+		}
+		conflicts[err.Error()] = true
+	}
+	d.conflicts = conflicts
+	d.lock.Unlock()
+}
+
+// Claim checks whether the function can be given the domains in its
+// annotations, without changing the routes.
+func (d *DomainRouter) Claim(functionName, namespace string, annotations map[string]string) error {
+	if len(namespace) == 0 {
+		namespace = d.defaultNamespace
+	}
+	target := functionName + "." + namespace
+
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	for _, host := range parseDomains(annotations[DomainAnnotation]) {
+		if err := d.validateClaim(d.routes, host, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DomainRouter) validateClaim(routes map[string]string, host, target string) error {
+	if !isValidHost(host) {
+		return fmt.Errorf("%w: %q claimed by %s is not a valid host name", errInvalidDomain, host, target)
+	}
+
+	if len(d.suffix) > 0 && (host == d.suffix || strings.HasSuffix(host, "."+d.suffix)) {
+		return fmt.Errorf("%w: %s claimed by %s is under the function domain suffix %s", errDomainConflict, host, target, d.suffix)
+	}
+
+	if existing, ok := routes[host]; ok && existing != target {
+		return fmt.Errorf("%w: %s claimed by %s is already routed to %s", errDomainConflict, host, target, existing)
+	}
+
+	return nil
+}
+
+// Match returns the function, as function.namespace, which serves host
+func (d *DomainRouter) Match(host string) (string, bool) {
+	host = normaliseHost(host)
+
+	d.lock.RLock()
+	target, ok := d.routes[host]
+	d.lock.RUnlock()
+	if ok {
+		return target, true
+	}
+
+	if len(d.suffix) == 0 || !strings.HasSuffix(host, "."+d.suffix) {
+		return "", false
+	}
+
+	parts := strings.Split(strings.TrimSuffix(host, "."+d.suffix), ".")
+	switch len(parts) {
+	case 1:
+		return parts[0] + "." + d.defaultNamespace, true
+	case 2:
+		return parts[0] + "." + parts[1], true
+	}
+
+	return "", false
+}
+
+// MakeDomainRoutingHandler sends requests whose Host matches a route in the
+// DomainRouter to functionProxy, with the path prefixed by
+// /function/{name}.{namespace} so the path, query, scaling and metrics are
+// the same as for the function's usual route. All other requests go to next.
+func MakeDomainRoutingHandler(next http.Handler, router *DomainRouter, functionProxy http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target, ok := router.Match(r.Host)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		prefix := "/function/" + target
+		r.URL.Path = prefix + r.URL.Path
+		if len(r.URL.RawPath) > 0 {
+			r.URL.RawPath = prefix + r.URL.RawPath
+		}
+
+		functionProxy(w, r)
+	}
+}
+
+func functionTarget(function types.FunctionStatus, defaultNamespace string) string {
+	namespace := function.Namespace
+	if len(namespace) == 0 {
+		namespace = defaultNamespace
+	}
+	return function.Name + "." + namespace
+}
+
+func parseDomains(value string) []string {
+	var hosts []string
+	for _, host := range strings.Split(value, ",") {
+		if host = normaliseHost(host); len(host) > 0 {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// normaliseHost lower-cases host and removes any port and trailing dot
+func normaliseHost(host string) string {
+	host = strings.TrimSpace(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+func isValidHost(host string) bool {
+	if len(host) == 0 || len(host) > 253 {
+		return false
+	}
+
+	for _, label := range strings.Split(host, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
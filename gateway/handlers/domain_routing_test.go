@@ -0,0 +1,141 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openfaas/faas-provider/types"
+)
+
+func Test_DomainRouter_Match(t *testing.T) {
+	router := NewDomainRouter("fn.example.com", map[string]string{"api.example.com": "checkout"}, "openfaas-fn")
+	router.Update([]types.FunctionStatus{
+		{Name: "store", Namespace: "staging", Annotations: &map[string]string{DomainAnnotation: "Shop.example.com, www.shop.example.com"}},
+	})
+
+	scenarios := []struct {
+		host   string
+		want   string
+		wantOk bool
+	}{
+		{host: "api.example.com", want: "checkout.openfaas-fn", wantOk: true},
+		{host: "api.example.com:8080", want: "checkout.openfaas-fn", wantOk: true},
+		{host: "shop.example.com", want: "store.staging", wantOk: true},
+		{host: "www.shop.example.com.", want: "store.staging", wantOk: true},
+		{host: "echo.fn.example.com", want: "echo.openfaas-fn", wantOk: true},
+		{host: "echo.dev.fn.example.com", want: "echo.dev", wantOk: true},
+		{host: "a.b.c.fn.example.com", wantOk: false},
+		{host: "gateway.example.com", wantOk: false},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.host, func(t *testing.T) {
+			got, ok := router.Match(s.host)
+			if ok != s.wantOk || got != s.want {
+				t.Errorf("want: %q %t, got: %q %t", s.want, s.wantOk, got, ok)
+			}
+		})
+	}
+}
+
+func Test_DomainRouter_UpdateKeepsCurrentOwner(t *testing.T) {
+	router := NewDomainRouter("", nil, "openfaas-fn")
+
+	router.Update([]types.FunctionStatus{
+		{Name: "b", Annotations: &map[string]string{DomainAnnotation: "api.example.com"}},
+	})
+	router.Update([]types.FunctionStatus{
+		{Name: "a", Annotations: &map[string]string{DomainAnnotation: "api.example.com"}},
+		{Name: "b", Annotations: &map[string]string{DomainAnnotation: "api.example.com"}},
+	})
+
+	if got, _ := router.Match("api.example.com"); got != "b.openfaas-fn" {
+		t.Errorf("want: %s, got: %s", "b.openfaas-fn", got)
+	}
+}
+
+func Test_DomainRouter_Claim(t *testing.T) {
+	router := NewDomainRouter("fn.example.com", map[string]string{"api.example.com": "checkout"}, "openfaas-fn")
+
+	scenarios := []struct {
+		name     string
+		function string
+		domains  string
+		wantErr  error
+	}{
+		{name: "unclaimed domain", function: "store", domains: "shop.example.com"},
+		{name: "own domain", function: "checkout", domains: "api.example.com"},
+		{name: "domain routed to another function", function: "store", domains: "api.example.com", wantErr: errDomainConflict},
+		{name: "domain under suffix", function: "store", domains: "echo.fn.example.com", wantErr: errDomainConflict},
+		{name: "invalid host name", function: "store", domains: "shop_example.com", wantErr: errInvalidDomain},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			err := router.Claim(s.function, "", map[string]string{DomainAnnotation: s.domains})
+			if s.wantErr == nil && err != nil {
+				t.Errorf("want no error, got: %s", err)
+			}
+			if s.wantErr != nil && !errors.Is(err, s.wantErr) {
+				t.Errorf("want: %s, got: %v", s.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_MakeDomainRoutingHandler_RewritesPath(t *testing.T) {
+	router := NewDomainRouter("", map[string]string{"api.example.com": "checkout"}, "openfaas-fn")
+
+	var gotURL string
+	functionProxy := func(w http.ResponseWriter, r *http.Request) {
+		gotURL = r.URL.RequestURI()
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURL = "next"
+	})
+
+	handler := MakeDomainRoutingHandler(next, router, functionProxy)
+
+	req := httptest.NewRequest(http.MethodGet, "http://api.example.com/orders/1?page=2", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if want := "/function/checkout.openfaas-fn/orders/1?page=2"; gotURL != want {
+		t.Errorf("want: %s, got: %s", want, gotURL)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://gateway.example.com/system/functions", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if gotURL != "next" {
+		t.Errorf("want request for another host passed to next, got: %s", gotURL)
+	}
+}
+
+func Test_MakeAnnotationClaimHandler_RejectsDomainConflict(t *testing.T) {
+	router := NewDomainRouter("", map[string]string{"api.example.com": "checkout"}, "openfaas-fn")
+
+	called := false
+	handler := MakeAnnotationClaimHandler(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}, router)
+
+	body := `{"service":"store","annotations":{"com.openfaas.domain":"api.example.com"}}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/system/functions", strings.NewReader(body)))
+
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status want: %d, got: %d", http.StatusConflict, rec.Code)
+	}
+	if called {
+		t.Errorf("want deployment not passed to the provider")
+	}
+	if !strings.Contains(rec.Body.String(), "already routed to checkout.openfaas-fn") {
+		t.Errorf("want a clear error, got: %s", rec.Body.String())
+	}
+}
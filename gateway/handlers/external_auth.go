@@ -0,0 +1,264 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// authCacheHeaders are the request headers which carry credentials, and
+// which form part of the key for a cached auth decision. Requests without
+// any of them are always validated, as their credentials may be elsewhere.
+var authCacheHeaders = []string{"Authorization", "Cookie"}
+
+const (
+	// maxAuthDecisions is the most decisions cached, at which expired
+	// entries are swept out and then the oldest entry is evicted.
+	maxAuthDecisions = 10000
+
+	// maxAuthBodyBytes is the largest request body passed to the external
+	// auth proxy, larger requests are refused.
+	maxAuthBodyBytes = 1024 * 1024
+)
+
+// errAuthBodyTooLarge is returned when a body is over maxAuthBodyBytes
+var errAuthBodyTooLarge = fmt.Errorf("request body is larger than %d bytes", maxAuthBodyBytes)
+
+// AuthDecision is a cached response from the external auth proxy
+type AuthDecision struct {
+	Allowed    bool
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Expires    time.Time
+}
+
+// AuthDecisionCache holds recent decisions from the external auth proxy
+// so that repeated requests with the same credentials are not validated
+// on every call.
+type AuthDecisionCache struct {
+	Cache  map[string]AuthDecision
+	Expiry time.Duration
+	Sync   sync.RWMutex
+}
+
+// NewAuthDecisionCache creates a cache for auth decisions, an expiry of
+// zero disables caching.
+func NewAuthDecisionCache(expiry time.Duration) *AuthDecisionCache {
+	return &AuthDecisionCache{
+		Cache:  make(map[string]AuthDecision),
+		Expiry: expiry,
+	}
+}
+
+// Get returns a decision for key if one is present and has not expired
+func (c *AuthDecisionCache) Get(key string) (AuthDecision, bool) {
+	c.Sync.RLock()
+	defer c.Sync.RUnlock()
+
+	decision, ok := c.Cache[key]
+	if !ok || time.Now().After(decision.Expires) {
+		return AuthDecision{}, false
+	}
+
+	return decision, true
+}
+
+// Set stores a decision for key
+func (c *AuthDecisionCache) Set(key string, decision AuthDecision) {
+	c.Sync.Lock()
+	defer c.Sync.Unlock()
+
+	now := time.Now()
+	if _, exists := c.Cache[key]; !exists && len(c.Cache) >= maxAuthDecisions {
+		for k, v := range c.Cache {
+			if now.After(v.Expires) {
+				delete(c.Cache, k)
+			}
+		}
+
+		if len(c.Cache) >= maxAuthDecisions {
+			oldest := ""
+			var expires time.Time
+			for k, v := range c.Cache {
+				if len(oldest) == 0 || v.Expires.Before(expires) {
+					oldest, expires = k, v.Expires
+				}
+			}
+			delete(c.Cache, oldest)
+		}
+	}
+
+	decision.Expires = now.Add(c.Expiry)
+	c.Cache[key] = decision
+}
+
+// MakeExternalAuthHandler validates each request against an external auth
+// proxy before calling next. The original method, path and headers are
+// forwarded to upstreamURL, along with the body when passBody is set.
+// A 2xx response allows the request, anything else is returned to the
+// caller as the denial. Decisions are cached when decisions is non-nil,
+// the body is not being passed to the auth proxy and the request carries
+// one of the authCacheHeaders. Only allowed requests and 401 or 403
+// denials are cached, so an error from the auth proxy is not repeated.
+func MakeExternalAuthHandler(next http.HandlerFunc, upstreamTimeout time.Duration, upstreamURL string, passBody bool, decisions *AuthDecisionCache) http.HandlerFunc {
+	cacheEnabled := decisions != nil && decisions.Expiry > 0 && !passBody
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		useCache := cacheEnabled && hasAuthCacheHeader(r)
+
+		key := ""
+		if useCache {
+			key = authDecisionKey(r)
+			if decision, ok := decisions.Get(key); ok {
+				if decision.Allowed {
+					next.ServeHTTP(w, r)
+					return
+				}
+				writeAuthDecision(w, decision)
+				return
+			}
+		}
+
+		decision, err := requestAuthDecision(r, upstreamTimeout, upstreamURL, passBody)
+		if err == errAuthBodyTooLarge {
+			http.Error(w, fmt.Sprintf("ExternalAuthHandler: %s", err.Error()), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			log.Printf("ExternalAuthHandler: %s", err.Error())
+			http.Error(w, fmt.Sprintf("ExternalAuthHandler: %s", err.Error()), http.StatusBadGateway)
+			return
+		}
+
+		if useCache && cacheableAuthDecision(decision) {
+			decisions.Set(key, decision)
+		}
+
+		if decision.Allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		writeAuthDecision(w, decision)
+	}
+}
+
+func requestAuthDecision(r *http.Request, upstreamTimeout time.Duration, upstreamURL string, passBody bool) (AuthDecision, error) {
+	var body io.Reader
+	if passBody && r.Body != nil {
+		bodyBytes, err := io.ReadAll(io.LimitReader(r.Body, maxAuthBodyBytes+1))
+		if err != nil {
+			return AuthDecision{}, fmt.Errorf("unable to read request body: %s", err)
+		}
+		r.Body.Close()
+
+		if len(bodyBytes) > maxAuthBodyBytes {
+			return AuthDecision{}, errAuthBodyTooLarge
+		}
+
+		// Restore the io.ReadCloser so that next can read the body
+		r.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+		body = bytes.NewReader(bodyBytes)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), upstreamTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, r.Method, upstreamURL, body)
+	if err != nil {
+		return AuthDecision{}, err
+	}
+
+	copyHeaders(req.Header, &r.Header)
+	deleteHeaders(&req.Header, &hopHeaders)
+	req.Header.Set("X-Forwarded-Method", r.Method)
+	req.Header.Set("X-Forwarded-Uri", r.URL.RequestURI())
+	if len(r.Host) > 0 {
+		req.Header.Set("X-Forwarded-Host", r.Host)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return AuthDecision{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusOK && res.StatusCode < http.StatusMultipleChoices {
+		return AuthDecision{Allowed: true, StatusCode: res.StatusCode}, nil
+	}
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return AuthDecision{}, fmt.Errorf("unable to read auth response: %s", err)
+	}
+
+	return AuthDecision{
+		Allowed:    false,
+		StatusCode: res.StatusCode,
+		Header:     res.Header.Clone(),
+		Body:       resBody,
+	}, nil
+}
+
+func writeAuthDecision(w http.ResponseWriter, decision AuthDecision) {
+	header := w.Header()
+	copyHeaders(header, &decision.Header)
+	deleteHeaders(&header, &hopHeaders)
+	header.Del("Content-Length")
+
+	w.WriteHeader(decision.StatusCode)
+	w.Write(decision.Body)
+}
+
+// cacheableAuthDecision is true for decisions about the credentials rather
+// than errors from the auth proxy
+func cacheableAuthDecision(decision AuthDecision) bool {
+	return decision.Allowed ||
+		decision.StatusCode == http.StatusUnauthorized ||
+		decision.StatusCode == http.StatusForbidden
+}
+
+// hasAuthCacheHeader is true when the request carries one of the
+// authCacheHeaders
+func hasAuthCacheHeader(r *http.Request) bool {
+	for _, name := range authCacheHeaders {
+		if len(r.Header.Values(name)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// authDecisionKey hashes the parts of a request which the auth proxy is
+// expected to base its decision on.
+func authDecisionKey(r *http.Request) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method)
+	io.WriteString(h, "\n")
+	io.WriteString(h, r.URL.Path)
+	io.WriteString(h, "?")
+	io.WriteString(h, r.URL.RawQuery)
+	for _, name := range authCacheHeaders {
+		for _, v := range r.Header.Values(name) {
+			io.WriteString(h, "\n")
+			io.WriteString(h, name)
+			io.WriteString(h, ":")
+			io.WriteString(h, v)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
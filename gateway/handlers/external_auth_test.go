@@ -0,0 +1,268 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_MakeExternalAuthHandler_AllowsOn200(t *testing.T) {
+	var gotMethod, gotURI, gotAuth string
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		gotURI = r.Header.Get("X-Forwarded-Uri")
+		gotAuth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer authServer.Close()
+
+	nextVisited := false
+	next := func(w http.ResponseWriter, r *http.Request) {
+		nextVisited = true
+		w.WriteHeader(http.StatusAccepted)
+	}
+
+	handler := MakeExternalAuthHandler(next, time.Second, authServer.URL, false, nil)
+
+	req := httptest.NewRequest(http.MethodDelete, "/system/functions?namespace=fn", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if !nextVisited {
+		t.Fatalf("expected next handler to be called")
+	}
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("status want: %d, got: %d", http.StatusAccepted, rec.Code)
+	}
+	if gotMethod != http.MethodDelete {
+		t.Errorf("method want: %s, got: %s", http.MethodDelete, gotMethod)
+	}
+	if gotURI != "/system/functions?namespace=fn" {
+		t.Errorf("X-Forwarded-Uri want: %s, got: %s", "/system/functions?namespace=fn", gotURI)
+	}
+	if gotAuth != "Bearer token" {
+		t.Errorf("Authorization want: %s, got: %s", "Bearer token", gotAuth)
+	}
+}
+
+func Test_MakeExternalAuthHandler_DeniesWithUpstreamResponse(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", "Basic realm=\"openfaas\"")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("unauthorized"))
+	}))
+	defer authServer.Close()
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("next handler should not be called")
+	}
+
+	handler := MakeExternalAuthHandler(next, time.Second, authServer.URL, false, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/system/functions", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status want: %d, got: %d", http.StatusUnauthorized, rec.Code)
+	}
+	if rec.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("want WWW-Authenticate header from auth proxy")
+	}
+	if rec.Body.String() != "unauthorized" {
+		t.Errorf("body want: %s, got: %s", "unauthorized", rec.Body.String())
+	}
+}
+
+func Test_MakeExternalAuthHandler_PassBody(t *testing.T) {
+	var authBody string
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		authBody = string(b)
+	}))
+	defer authServer.Close()
+
+	var nextBody string
+	next := func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		nextBody = string(b)
+	}
+
+	handler := MakeExternalAuthHandler(next, time.Second, authServer.URL, true, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/system/functions", strings.NewReader("{}"))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if authBody != "{}" {
+		t.Errorf("auth proxy body want: %s, got: %q", "{}", authBody)
+	}
+	if nextBody != "{}" {
+		t.Errorf("next body want: %s, got: %q", "{}", nextBody)
+	}
+}
+
+func Test_MakeExternalAuthHandler_CachesDecisionPerCredential(t *testing.T) {
+	var calls int32
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.Header.Get("Authorization") != "Bearer valid" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer authServer.Close()
+
+	next := func(w http.ResponseWriter, r *http.Request) {}
+	handler := MakeExternalAuthHandler(next, time.Second, authServer.URL, false, NewAuthDecisionCache(time.Minute))
+
+	for _, token := range []string{"Bearer valid", "Bearer valid", "Bearer invalid", "Bearer invalid"} {
+		req := httptest.NewRequest(http.MethodGet, "/system/functions", nil)
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		want := http.StatusOK
+		if token != "Bearer valid" {
+			want = http.StatusForbidden
+		}
+		if rec.Code != want {
+			t.Errorf("%s status want: %d, got: %d", token, want, rec.Code)
+		}
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("auth proxy calls want: %d, got: %d", 2, got)
+	}
+}
+
+func Test_MakeExternalAuthHandler_DoesNotCacheErrors(t *testing.T) {
+	var calls int32
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer authServer.Close()
+
+	next := func(w http.ResponseWriter, r *http.Request) {}
+	handler := MakeExternalAuthHandler(next, time.Second, authServer.URL, false, NewAuthDecisionCache(time.Minute))
+
+	for _, want := range []int{http.StatusServiceUnavailable, http.StatusOK} {
+		req := httptest.NewRequest(http.MethodGet, "/system/functions", nil)
+		req.Header.Set("Authorization", "Bearer valid")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != want {
+			t.Errorf("status want: %d, got: %d", want, rec.Code)
+		}
+	}
+}
+
+func Test_AuthDecisionCache_CapsEntries(t *testing.T) {
+	cache := NewAuthDecisionCache(time.Minute)
+	for i := 0; i < maxAuthDecisions+10; i++ {
+		cache.Set(fmt.Sprintf("key-%d", i), AuthDecision{Allowed: true})
+	}
+
+	if len(cache.Cache) != maxAuthDecisions {
+		t.Errorf("decisions want: %d, got: %d", maxAuthDecisions, len(cache.Cache))
+	}
+	if _, ok := cache.Get(fmt.Sprintf("key-%d", maxAuthDecisions+9)); !ok {
+		t.Errorf("want the newest decision kept")
+	}
+}
+
+func Test_MakeExternalAuthHandler_UnreachableIsBadGateway(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	authServer.Close()
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("next handler should not be called")
+	}
+
+	handler := MakeExternalAuthHandler(next, time.Second, authServer.URL, false, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/system/functions", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status want: %d, got: %d", http.StatusBadGateway, rec.Code)
+	}
+}
+
+func Test_MakeExternalAuthHandler_DoesNotCacheWithoutCredentialHeaders(t *testing.T) {
+	var calls int32
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.Header.Get("X-Api-Key") != "valid" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer authServer.Close()
+
+	next := func(w http.ResponseWriter, r *http.Request) {}
+	handler := MakeExternalAuthHandler(next, time.Second, authServer.URL, false, NewAuthDecisionCache(time.Minute))
+
+	for _, key := range []string{"valid", ""} {
+		req := httptest.NewRequest(http.MethodGet, "/system/functions", nil)
+		if len(key) > 0 {
+			req.Header.Set("X-Api-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		want := http.StatusOK
+		if len(key) == 0 {
+			want = http.StatusForbidden
+		}
+		if rec.Code != want {
+			t.Errorf("X-Api-Key %q status want: %d, got: %d", key, want, rec.Code)
+		}
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("auth proxy calls want: %d, got: %d", 2, got)
+	}
+}
+
+func Test_authDecisionKey_IncludesQuery(t *testing.T) {
+	a := httptest.NewRequest(http.MethodGet, "/system/functions?token=a", nil)
+	b := httptest.NewRequest(http.MethodGet, "/system/functions?token=b", nil)
+
+	if authDecisionKey(a) == authDecisionKey(b) {
+		t.Errorf("want different keys for different queries")
+	}
+}
+
+func Test_MakeExternalAuthHandler_PassBodyTooLarge(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("auth proxy should not be called")
+	}))
+	defer authServer.Close()
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("next handler should not be called")
+	}
+	handler := MakeExternalAuthHandler(next, time.Second, authServer.URL, true, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/system/functions", strings.NewReader(strings.Repeat("x", maxAuthBodyBytes+1)))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status want: %d, got: %d", http.StatusRequestEntityTooLarge, rec.Code)
+	}
+}
@@ -103,6 +103,8 @@ func forwardRequest(w http.ResponseWriter,
 		defer r.Body.Close()
 	}
 
+	timeout = upstreamTimeout(r, timeout)
+
 	upstreamReq := buildUpstreamRequest(r, baseURL, requestURL)
 
 	if serviceAuthInjector != nil {
@@ -0,0 +1,84 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/openfaas/faas/gateway/pkg/middleware"
+	"github.com/openfaas/faas/gateway/scaling"
+)
+
+// TimeoutAnnotation sets the upstream timeout for a function, either as a
+// Go duration i.e. "10m" or as a number of seconds.
+const TimeoutAnnotation = "com.openfaas.timeout"
+
+type upstreamTimeoutKey struct{}
+
+// MakeFunctionTimeoutHandler looks up the timeout annotation for the function
+// being invoked and uses it as the upstream timeout in place of the gateway's
+// upstream_timeout. The value is capped at maxTimeout.
+func MakeFunctionTimeoutHandler(next http.HandlerFunc, functionQuery scaling.FunctionQuery, defaultNamespace string, maxTimeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName, namespace := middleware.GetNamespace(defaultNamespace, middleware.GetServiceName(r.URL.String()))
+
+		annotations, err := functionQuery.GetAnnotations(functionName, namespace)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		value, ok := annotations[TimeoutAnnotation]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		timeout, err := parseTimeoutAnnotation(value)
+		if err != nil {
+			log.Printf("Function %s.%s has an invalid %s annotation: %q", functionName, namespace, TimeoutAnnotation, value)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if maxTimeout > 0 && timeout > maxTimeout {
+			timeout = maxTimeout
+		}
+
+		ctx := context.WithValue(r.Context(), upstreamTimeoutKey{}, timeout)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// upstreamTimeout gives the timeout set by MakeFunctionTimeoutHandler for
+// the request, or fallback when none was set.
+func upstreamTimeout(r *http.Request, fallback time.Duration) time.Duration {
+	if timeout, ok := r.Context().Value(upstreamTimeoutKey{}).(time.Duration); ok {
+		return timeout
+	}
+	return fallback
+}
+
+func parseTimeoutAnnotation(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("timeout must be greater than zero")
+	}
+
+	return timeout, nil
+}
@@ -0,0 +1,88 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/scaling"
+)
+
+// fakeFunctionQuery returns fixed annotations for any function
+type fakeFunctionQuery struct {
+	annotations map[string]string
+	err         error
+}
+
+func (f fakeFunctionQuery) Get(name string, namespace string) (scaling.ServiceQueryResponse, error) {
+	return scaling.ServiceQueryResponse{Annotations: &f.annotations}, f.err
+}
+
+func (f fakeFunctionQuery) GetAnnotations(name string, namespace string) (map[string]string, error) {
+	return f.annotations, f.err
+}
+
+func Test_MakeFunctionTimeoutHandler(t *testing.T) {
+	fallback := time.Minute
+	max := time.Minute * 10
+
+	scenarios := []struct {
+		name        string
+		annotations map[string]string
+		err         error
+		want        time.Duration
+	}{
+		{name: "no annotation uses fallback", annotations: map[string]string{}, want: fallback},
+		{name: "duration annotation", annotations: map[string]string{TimeoutAnnotation: "5s"}, want: time.Second * 5},
+		{name: "seconds annotation", annotations: map[string]string{TimeoutAnnotation: "300"}, want: time.Minute * 5},
+		{name: "capped at max", annotations: map[string]string{TimeoutAnnotation: "1h"}, want: max},
+		{name: "invalid annotation uses fallback", annotations: map[string]string{TimeoutAnnotation: "soon"}, want: fallback},
+		{name: "zero annotation uses fallback", annotations: map[string]string{TimeoutAnnotation: "0"}, want: fallback},
+		{name: "query error uses fallback", annotations: map[string]string{TimeoutAnnotation: "5s"}, err: fmt.Errorf("not found"), want: fallback},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			var got time.Duration
+			next := func(w http.ResponseWriter, r *http.Request) {
+				got = upstreamTimeout(r, fallback)
+			}
+
+			query := fakeFunctionQuery{annotations: s.annotations, err: s.err}
+			handler := MakeFunctionTimeoutHandler(next, query, "openfaas-fn", max)
+
+			req := httptest.NewRequest(http.MethodGet, "/function/batch", nil)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != s.want {
+				t.Errorf("timeout want: %s, got: %s", s.want, got)
+			}
+		})
+	}
+}
+
+func Test_forwardRequest_UsesFunctionTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond * 200)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	query := fakeFunctionQuery{annotations: map[string]string{TimeoutAnnotation: "50ms"}}
+	handler := MakeFunctionTimeoutHandler(func(w http.ResponseWriter, r *http.Request) {
+		statusCode, _ := forwardRequest(w, r, http.DefaultClient, upstream.URL, "/", time.Minute, false, nil, nil)
+		if statusCode != http.StatusBadGateway {
+			t.Errorf("status want: %d, got: %d", http.StatusBadGateway, statusCode)
+		}
+	}, query, "openfaas-fn", time.Minute)
+
+	req := httptest.NewRequest(http.MethodGet, "/function/api", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+}
@@ -0,0 +1,236 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openfaas/faas/gateway/metrics"
+)
+
+// requestPriority decides which requests are shed first when the gateway
+// is overloaded
+type requestPriority int
+
+const (
+	// priorityFunction is for function invocations and any other traffic,
+	// which are shed as soon as the limit is reached
+	priorityFunction requestPriority = iota
+
+	// prioritySystem is for the /system/ API, which can exceed the limit
+	// by systemHeadroom so that the gateway can still be managed
+	prioritySystem
+
+	// priorityCritical is for health checks, which are never shed
+	priorityCritical
+)
+
+func (p requestPriority) String() string {
+	switch p {
+	case prioritySystem:
+		return "system"
+	case priorityCritical:
+		return "critical"
+	}
+	return "function"
+}
+
+const (
+	// systemHeadroom is the fraction of the limit which /system/ requests
+	// can use above it
+	systemHeadroom = 0.25
+
+	// limiterWindowSamples is the number of latency samples which are
+	// averaged before the limit is updated
+	limiterWindowSamples = 50
+
+	// limiterTolerance is how much the recent latency can rise above the
+	// long-term latency before the limit is reduced
+	limiterTolerance = 1.5
+
+	// limiterSmoothing is how quickly the limit moves towards a new estimate
+	limiterSmoothing = 0.2
+
+	// limiterLongRTTWeight is the weight of each window in the long-term
+	// latency average
+	limiterLongRTTWeight = 0.05
+)
+
+// AdaptiveLimiter limits the number of requests in-flight in the gateway.
+// The limit is adjusted with a gradient of the long-term latency over the
+// recent latency: it grows while latency is stable and shrinks when latency
+// rises, which happens before the gateway runs out of memory or file
+// descriptors.
+type AdaptiveLimiter struct {
+	limit    float64
+	min      float64
+	max      float64
+	inflight int
+
+	// longRTT is the long-term average latency in seconds
+	longRTT float64
+
+	// the current window of samples
+	samples     int
+	sum         float64
+	maxInflight int
+
+	metricsOptions *metrics.MetricOptions
+	lock           sync.Mutex
+}
+
+// NewAdaptiveLimiter creates an AdaptiveLimiter which starts at initial and
+// stays between min and max
+func NewAdaptiveLimiter(initial, min, max int, metricsOptions *metrics.MetricOptions) *AdaptiveLimiter {
+	l := &AdaptiveLimiter{
+		limit:          float64(initial),
+		min:            float64(min),
+		max:            float64(max),
+		metricsOptions: metricsOptions,
+	}
+
+	metricsOptions.GatewayConcurrencyLimit.Set(float64(initial))
+	return l
+}
+
+// Limit returns the current limit
+func (l *AdaptiveLimiter) Limit() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return int(l.limit)
+}
+
+// acquire admits a request when there is room for its priority
+func (l *AdaptiveLimiter) acquire(priority requestPriority) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	limit := l.limit
+	switch priority {
+	case prioritySystem:
+		limit += l.limit * systemHeadroom
+	case priorityCritical:
+		limit = math.Inf(1)
+	}
+
+	if float64(l.inflight) >= limit {
+		return false
+	}
+
+	l.inflight++
+	if l.inflight > l.maxInflight {
+		l.maxInflight = l.inflight
+	}
+
+	l.metricsOptions.GatewayInflightRequests.Set(float64(l.inflight))
+	return true
+}
+
+// release ends a request, adding its latency to the window when sample is
+// true
+func (l *AdaptiveLimiter) release(latency time.Duration, sample bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.inflight--
+	l.metricsOptions.GatewayInflightRequests.Set(float64(l.inflight))
+
+	if !sample {
+		return
+	}
+
+	l.samples++
+	l.sum += latency.Seconds()
+	if l.samples >= limiterWindowSamples {
+		l.update()
+	}
+}
+
+// update moves the limit towards a new estimate based on the latency of
+// the window
+func (l *AdaptiveLimiter) update() {
+	shortRTT := l.sum / float64(l.samples)
+	maxInflight := l.maxInflight
+
+	l.samples = 0
+	l.sum = 0
+	l.maxInflight = l.inflight
+
+	if shortRTT <= 0 {
+		return
+	}
+
+	if l.longRTT == 0 {
+		l.longRTT = shortRTT
+	} else {
+		l.longRTT = l.longRTT*(1-limiterLongRTTWeight) + shortRTT*limiterLongRTTWeight
+	}
+
+	// Recover quickly when latency falls after a period of overload
+	if l.longRTT/shortRTT > 2 {
+		l.longRTT *= 0.9
+	}
+
+	gradient := math.Max(0.5, math.Min(1, limiterTolerance*l.longRTT/shortRTT))
+	estimate := l.limit*gradient + math.Sqrt(l.limit)
+
+	// Only grow the limit when it is being used
+	if float64(maxInflight) < l.limit/2 {
+		estimate = math.Min(estimate, l.limit)
+	}
+
+	l.limit = l.limit*(1-limiterSmoothing) + estimate*limiterSmoothing
+	l.limit = math.Max(l.min, math.Min(l.max, l.limit))
+
+	l.metricsOptions.GatewayConcurrencyLimit.Set(math.Floor(l.limit))
+}
+
+// MakeLoadSheddingHandler rejects requests with a 503 once the number of
+// requests in-flight reaches the AdaptiveLimiter's limit. Health checks are
+// never rejected and the /system/ API is rejected only after function
+// traffic. The latency of function invocations, other than WebSockets and
+// event streams, adjusts the limit.
+func MakeLoadSheddingHandler(next http.HandlerFunc, limiter *AdaptiveLimiter, metricsOptions *metrics.MetricOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isUpgradeRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		priority := classifyRequest(r)
+		if !limiter.acquire(priority) {
+			metricsOptions.GatewayRequestsShed.WithLabelValues(priority.String()).Inc()
+
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "The gateway is overloaded, try again later", http.StatusServiceUnavailable)
+			return
+		}
+
+		sample := priority == priorityFunction && !strings.HasPrefix(r.Header.Get("Accept"), "text/event-stream")
+
+		start := time.Now()
+		defer func() {
+			limiter.release(time.Since(start), sample)
+		}()
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+func classifyRequest(r *http.Request) requestPriority {
+	switch {
+	case r.URL.Path == "/healthz":
+		return priorityCritical
+	case strings.HasPrefix(r.URL.Path, "/system/"):
+		return prioritySystem
+	}
+	return priorityFunction
+}
@@ -0,0 +1,137 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/metrics"
+)
+
+func Test_AdaptiveLimiter_SheddingOrder(t *testing.T) {
+	metricsOptions := metrics.BuildMetricsOptions()
+	limiter := NewAdaptiveLimiter(4, 1, 10, &metricsOptions)
+
+	for i := 0; i < 4; i++ {
+		if !limiter.acquire(priorityFunction) {
+			t.Fatalf("request %d want admitted below the limit", i)
+		}
+	}
+
+	if limiter.acquire(priorityFunction) {
+		t.Errorf("want function request shed at the limit")
+	}
+	if !limiter.acquire(prioritySystem) {
+		t.Errorf("want system request admitted within the headroom")
+	}
+	if limiter.acquire(prioritySystem) {
+		t.Errorf("want system request shed above the headroom")
+	}
+	if !limiter.acquire(priorityCritical) {
+		t.Errorf("want critical request never shed")
+	}
+}
+
+func Test_AdaptiveLimiter_FollowsLatency(t *testing.T) {
+	metricsOptions := metrics.BuildMetricsOptions()
+	limiter := NewAdaptiveLimiter(100, 10, 1000, &metricsOptions)
+
+	// sendWindow completes a window of requests with 80 in-flight
+	sendWindow := func(latency time.Duration) {
+		for i := 0; i < 80; i++ {
+			limiter.acquire(priorityFunction)
+		}
+		for i := 0; i < limiterWindowSamples; i++ {
+			limiter.release(latency, true)
+		}
+		for i := limiterWindowSamples; i < 80; i++ {
+			limiter.release(latency, false)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		sendWindow(time.Millisecond * 100)
+	}
+	grown := limiter.Limit()
+	if grown <= 100 {
+		t.Fatalf("want limit to grow while latency is stable, got: %d", grown)
+	}
+
+	for i := 0; i < 10; i++ {
+		sendWindow(time.Second)
+	}
+	if shrunk := limiter.Limit(); shrunk >= grown {
+		t.Errorf("want limit to shrink when latency rises, got: %d from %d", shrunk, grown)
+	}
+
+	if got := gaugeValue(metricsOptions.GatewayConcurrencyLimit); got != float64(limiter.Limit()) {
+		t.Errorf("limit gauge want: %d, got: %f", limiter.Limit(), got)
+	}
+}
+
+func Test_AdaptiveLimiter_DoesNotGrowWhenIdle(t *testing.T) {
+	metricsOptions := metrics.BuildMetricsOptions()
+	limiter := NewAdaptiveLimiter(100, 10, 1000, &metricsOptions)
+
+	for i := 0; i < limiterWindowSamples*10; i++ {
+		limiter.acquire(priorityFunction)
+		limiter.release(time.Millisecond*100, true)
+	}
+
+	if got := limiter.Limit(); got != 100 {
+		t.Errorf("want limit unchanged with one request in-flight, got: %d", got)
+	}
+}
+
+func Test_MakeLoadSheddingHandler(t *testing.T) {
+	metricsOptions := metrics.BuildMetricsOptions()
+	limiter := NewAdaptiveLimiter(1, 1, 1, &metricsOptions)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	next := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/function/slow" {
+			close(started)
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	handler := MakeLoadSheddingHandler(next, limiter, &metricsOptions)
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/function/slow", nil))
+		close(done)
+	}()
+	<-started
+
+	scenarios := []struct {
+		path       string
+		wantStatus int
+	}{
+		{path: "/function/echo", wantStatus: http.StatusServiceUnavailable},
+		{path: "/system/functions", wantStatus: http.StatusOK},
+		{path: "/healthz", wantStatus: http.StatusOK},
+	}
+
+	for _, s := range scenarios {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, s.path, nil))
+		if rec.Code != s.wantStatus {
+			t.Errorf("%s want: %d, got: %d", s.path, s.wantStatus, rec.Code)
+		}
+	}
+
+	close(release)
+	<-done
+
+	if shed := counterValue(metricsOptions.GatewayRequestsShed.WithLabelValues("function")); shed != 1 {
+		t.Errorf("shed want: %d, got: %f", 1, shed)
+	}
+}
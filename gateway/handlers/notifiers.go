@@ -3,12 +3,14 @@ package handlers
 import (
 	"fmt"
 	"log"
+	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/openfaas/faas/gateway/metrics"
 	"github.com/openfaas/faas/gateway/pkg/middleware"
+	"github.com/openfaas/faas/gateway/scaling"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -17,6 +19,12 @@ type HTTPNotifier interface {
 	Notify(method string, URL string, originalURL string, statusCode int, event string, duration time.Duration)
 }
 
+// WebSocketNotifier notify about a WebSocket connection being
+// "opened" or "closed", the statusCode is that of the handshake
+type WebSocketNotifier interface {
+	NotifyWebSocket(originalURL string, statusCode int, event string, duration time.Duration)
+}
+
 func urlToLabel(path string) string {
 	if len(path) > 0 {
 		path = strings.TrimRight(path, "/")
@@ -36,12 +44,7 @@ type PrometheusFunctionNotifier struct {
 
 // Notify records metrics in Prometheus
 func (p PrometheusFunctionNotifier) Notify(method string, URL string, originalURL string, statusCode int, event string, duration time.Duration) {
-	serviceName := middleware.GetServiceName(originalURL)
-	if len(p.FunctionNamespace) > 0 {
-		if !strings.Contains(serviceName, ".") {
-			serviceName = fmt.Sprintf("%s.%s", serviceName, p.FunctionNamespace)
-		}
-	}
+	serviceName := p.serviceName(originalURL)
 
 	code := strconv.Itoa(statusCode)
 	labels := prometheus.Labels{"function_name": serviceName, "code": code}
@@ -61,6 +64,91 @@ func (p PrometheusFunctionNotifier) Notify(method string, URL string, originalUR
 
 }
 
+// NotifyWebSocket records the count of open WebSocket connections in Prometheus
+func (p PrometheusFunctionNotifier) NotifyWebSocket(originalURL string, statusCode int, event string, duration time.Duration) {
+	serviceName := p.serviceName(originalURL)
+
+	switch event {
+	case "opened":
+		p.Metrics.GatewayWebSocketConnections.
+			WithLabelValues(serviceName, strconv.Itoa(statusCode)).
+			Inc()
+		if statusCode == http.StatusSwitchingProtocols {
+			p.Metrics.GatewayWebSocketConnectionsOpen.WithLabelValues(serviceName).Inc()
+		}
+	case "closed":
+		p.Metrics.GatewayWebSocketConnectionsOpen.WithLabelValues(serviceName).Dec()
+	}
+}
+
+// serviceName gives the function name with its namespace for use as a label
+func (p PrometheusFunctionNotifier) serviceName(originalURL string) string {
+	serviceName := middleware.GetServiceName(originalURL)
+	if len(p.FunctionNamespace) > 0 {
+		if !strings.Contains(serviceName, ".") {
+			serviceName = fmt.Sprintf("%s.%s", serviceName, p.FunctionNamespace)
+		}
+	}
+	return serviceName
+}
+
+// functionLabel gives the function_name label used in metrics for a
+// function which has already been split from its namespace
+func functionLabel(functionName, namespace string) string {
+	if len(namespace) > 0 {
+		return fmt.Sprintf("%s.%s", functionName, namespace)
+	}
+	return functionName
+}
+
+// InvocationNotifier passes the invocations of functions and their
+// WebSocket connections to an InvocationRecorder such as the Idler or the
+// Autoscaler. A WebSocket connection is in-flight until it is closed and is
+// recorded without a duration. An asynchronous invocation is recorded when
+// it is queued.
+type InvocationNotifier struct {
+	Recorder scaling.InvocationRecorder
+	//FunctionNamespace default namespace of the function
+	FunctionNamespace string
+}
+
+// Notify records the start and end of an invocation
+func (n InvocationNotifier) Notify(method string, URL string, originalURL string, statusCode int, event string, duration time.Duration) {
+	if rest, ok := strings.CutPrefix(originalURL, "/async-function/"); ok {
+		originalURL = "/function/" + rest
+	}
+
+	functionName, namespace := middleware.GetNamespace(n.FunctionNamespace, middleware.GetServiceName(originalURL))
+	if len(functionName) == 0 {
+		return
+	}
+
+	switch event {
+	case "started":
+		n.Recorder.Started(functionName, namespace)
+	case "completed":
+		n.Recorder.Completed(functionName, namespace, duration)
+	}
+}
+
+// NotifyWebSocket records an open WebSocket connection as an invocation
+// until it is closed
+func (n InvocationNotifier) NotifyWebSocket(originalURL string, statusCode int, event string, duration time.Duration) {
+	functionName, namespace := middleware.GetNamespace(n.FunctionNamespace, middleware.GetServiceName(originalURL))
+	if len(functionName) == 0 {
+		return
+	}
+
+	switch event {
+	case "opened":
+		if statusCode == http.StatusSwitchingProtocols {
+			n.Recorder.Started(functionName, namespace)
+		}
+	case "closed":
+		n.Recorder.Completed(functionName, namespace, 0)
+	}
+}
+
 // LoggingNotifier notifies a log about a request
 type LoggingNotifier struct {
 }
@@ -71,3 +159,15 @@ func (LoggingNotifier) Notify(method string, URL string, originalURL string, sta
 		log.Printf("Forwarded [%s] to %s - [%d] - %.4fs", method, originalURL, statusCode, duration.Seconds())
 	}
 }
+
+// NotifyWebSocket logs the outcome of a WebSocket connection
+func (LoggingNotifier) NotifyWebSocket(originalURL string, statusCode int, event string, duration time.Duration) {
+	switch event {
+	case "opened":
+		if statusCode != http.StatusSwitchingProtocols {
+			log.Printf("WebSocket upgrade to %s refused - [%d]", originalURL, statusCode)
+		}
+	case "closed":
+		log.Printf("WebSocket to %s closed - %.4fs", originalURL, duration.Seconds())
+	}
+}
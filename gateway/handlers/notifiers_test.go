@@ -1,6 +1,9 @@
 package handlers
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func Test_urlToLabel_normalizeTrailing(t *testing.T) {
 	have := "/system/functions/"
@@ -21,3 +24,28 @@ func Test_urlToLabel_retainRoot(t *testing.T) {
 		t.Errorf("want %s, got %s", want, got)
 	}
 }
+
+// countingRecorder counts the invocations started for each function
+type countingRecorder struct {
+	started map[string]int
+}
+
+func (c *countingRecorder) Started(functionName, namespace string) {
+	c.started[functionName+"."+namespace]++
+}
+
+func (c *countingRecorder) Completed(functionName, namespace string, duration time.Duration) {
+}
+
+func Test_InvocationNotifier_RecordsAsyncInvocations(t *testing.T) {
+	recorder := &countingRecorder{started: map[string]int{}}
+	notifier := InvocationNotifier{Recorder: recorder, FunctionNamespace: "openfaas-fn"}
+
+	notifier.Notify("POST", "", "/function/resize", 0, "started", 0)
+	notifier.Notify("POST", "", "/async-function/resize.staging/thumbnails", 0, "started", 0)
+	notifier.Notify("GET", "", "/system/functions", 0, "started", 0)
+
+	if recorder.started["resize.openfaas-fn"] != 1 || recorder.started["resize.staging"] != 1 || len(recorder.started) != 2 {
+		t.Errorf("want one invocation of each function, got: %v", recorder.started)
+	}
+}
@@ -0,0 +1,212 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openfaas/faas/gateway/metrics"
+	"github.com/openfaas/faas/gateway/pkg/middleware"
+	"github.com/openfaas/faas/gateway/scaling"
+)
+
+const (
+	// RateLimitRPSAnnotation is the sustained number of requests per second
+	// allowed for a function
+	RateLimitRPSAnnotation = "com.openfaas.ratelimit.rps"
+
+	// RateLimitBurstAnnotation is the number of requests which can be made
+	// at once before the rps limit applies, defaults to the rps rounded up
+	RateLimitBurstAnnotation = "com.openfaas.ratelimit.burst"
+
+	// RateLimitKeyAnnotation applies the limit per client rather than to the
+	// function as a whole. Valid values are "ip", "user" for the basic-auth
+	// user or "header:<name>" i.e. "header:X-Api-Key"
+	RateLimitKeyAnnotation = "com.openfaas.ratelimit.key"
+)
+
+// maxRateLimitBuckets is the most buckets held, at which idle buckets are
+// swept out and then the least recently used bucket is evicted.
+const maxRateLimitBuckets = 10000
+
+// tokenBucket refills at rps up to burst tokens, each request takes one
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	rps    float64
+	burst  float64
+}
+
+// RateLimiter holds a token bucket per function, or per function and client
+type RateLimiter struct {
+	buckets map[string]*tokenBucket
+	lock    sync.Mutex
+}
+
+// NewRateLimiter creates a RateLimiter with no buckets
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Allow takes a token from the bucket for key, when the bucket is empty
+// it returns false and how long until the next token is available.
+func (l *RateLimiter) Allow(key string, rps float64, burst int) (bool, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	capacity := float64(burst)
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxRateLimitBuckets {
+			l.sweep(now)
+		}
+		if len(l.buckets) >= maxRateLimitBuckets {
+			l.evict()
+		}
+
+		bucket = &tokenBucket{tokens: capacity, last: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.last).Seconds()*rps)
+	bucket.last = now
+	bucket.rps = rps
+	bucket.burst = capacity
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - bucket.tokens) / rps * float64(time.Second))
+	return false, wait
+}
+
+// sweep removes buckets which would have refilled by now, so they are
+// indistinguishable from new buckets.
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.rps >= bucket.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// evict removes the least recently used bucket, so that clients which
+// rotate their key can't grow the buckets past maxRateLimitBuckets
+func (l *RateLimiter) evict() {
+	oldest := ""
+	var last time.Time
+	for key, bucket := range l.buckets {
+		if len(oldest) == 0 || bucket.last.Before(last) {
+			oldest, last = key, bucket.last
+		}
+	}
+	delete(l.buckets, oldest)
+}
+
+// MakeRateLimitHandler applies a token-bucket rate limit to functions which
+// have the rate limit annotations. Requests over the limit receive a 429
+// with a Retry-After header and are counted in GatewayFunctionRateLimited.
+func MakeRateLimitHandler(next http.HandlerFunc, limiter *RateLimiter, functionQuery scaling.FunctionQuery, defaultNamespace string, metricsOptions *metrics.MetricOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName, namespace := middleware.GetNamespace(defaultNamespace, middleware.GetServiceName(r.URL.String()))
+
+		annotations, err := functionQuery.GetAnnotations(functionName, namespace)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rps, burst, ok := parseRateLimit(annotations)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := fmt.Sprintf("%s.%s", functionName, namespace)
+		if clientKey := annotations[RateLimitKeyAnnotation]; len(clientKey) > 0 {
+			key = key + "/" + rateLimitClientKey(r, clientKey)
+		}
+
+		allowed, wait := limiter.Allow(key, rps, burst)
+		if allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		metricsOptions.GatewayFunctionRateLimited.
+			WithLabelValues(functionLabel(functionName, namespace)).
+			Inc()
+
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		http.Error(w, fmt.Sprintf("Rate limit exceeded for function %s", functionName), http.StatusTooManyRequests)
+	}
+}
+
+// parseRateLimit reads the rps and burst annotations, ok is false when the
+// function has no valid rate limit.
+func parseRateLimit(annotations map[string]string) (rps float64, burst int, ok bool) {
+	rpsValue, exists := annotations[RateLimitRPSAnnotation]
+	if !exists {
+		return 0, 0, false
+	}
+
+	rps, err := strconv.ParseFloat(rpsValue, 64)
+	if err != nil || rps <= 0 || math.IsInf(rps, 0) {
+		log.Printf("Invalid %s annotation: %q", RateLimitRPSAnnotation, rpsValue)
+		return 0, 0, false
+	}
+
+	burst = int(math.Ceil(rps))
+	if burstValue, exists := annotations[RateLimitBurstAnnotation]; exists {
+		val, err := strconv.Atoi(burstValue)
+		if err != nil || val < 1 {
+			log.Printf("Invalid %s annotation: %q", RateLimitBurstAnnotation, burstValue)
+		} else {
+			burst = val
+		}
+	}
+
+	return rps, burst, true
+}
+
+// rateLimitClientKey identifies the caller of a request with the strategy
+// given in the RateLimitKeyAnnotation.
+func rateLimitClientKey(r *http.Request, strategy string) string {
+	switch {
+	case strategy == "ip":
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	case strategy == "user":
+		user, _, _ := r.BasicAuth()
+		return user
+	case strings.HasPrefix(strategy, "header:"):
+		return r.Header.Get(strings.TrimPrefix(strategy, "header:"))
+	}
+
+	return ""
+}
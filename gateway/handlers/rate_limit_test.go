@@ -0,0 +1,144 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func Test_RateLimiter_AllowsBurstThenRefills(t *testing.T) {
+	limiter := NewRateLimiter()
+
+	for i := 0; i < 2; i++ {
+		if allowed, _ := limiter.Allow("fn", 10, 2); !allowed {
+			t.Fatalf("request %d should be allowed within burst", i)
+		}
+	}
+
+	allowed, wait := limiter.Allow("fn", 10, 2)
+	if allowed {
+		t.Fatalf("request over burst should be rejected")
+	}
+	if wait <= 0 || wait > time.Millisecond*100 {
+		t.Errorf("wait want: (0, 100ms], got: %s", wait)
+	}
+
+	time.Sleep(wait)
+
+	if allowed, _ := limiter.Allow("fn", 10, 2); !allowed {
+		t.Errorf("request after refill should be allowed")
+	}
+}
+
+func Test_RateLimiter_CapsBuckets(t *testing.T) {
+	limiter := NewRateLimiter()
+
+	// No bucket refills within the test, so none can be swept
+	for i := 0; i < maxRateLimitBuckets+10; i++ {
+		limiter.Allow(fmt.Sprintf("fn.openfaas-fn/client-%d", i), 0.001, 1)
+	}
+
+	if len(limiter.buckets) != maxRateLimitBuckets {
+		t.Errorf("buckets want: %d, got: %d", maxRateLimitBuckets, len(limiter.buckets))
+	}
+	if _, ok := limiter.buckets[fmt.Sprintf("fn.openfaas-fn/client-%d", maxRateLimitBuckets+9)]; !ok {
+		t.Errorf("want the newest bucket kept")
+	}
+}
+
+func Test_MakeRateLimitHandler_Returns429WithRetryAfter(t *testing.T) {
+	metricsOptions := metrics.BuildMetricsOptions()
+	query := fakeFunctionQuery{annotations: map[string]string{
+		RateLimitRPSAnnotation:   "0.5",
+		RateLimitBurstAnnotation: "1",
+	}}
+
+	nextCalls := 0
+	next := func(w http.ResponseWriter, r *http.Request) {
+		nextCalls++
+	}
+
+	handler := MakeRateLimitHandler(next, NewRateLimiter(), query, "openfaas-fn", &metricsOptions)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/function/echo", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("first request status want: %d, got: %d", http.StatusOK, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/function/echo", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request status want: %d, got: %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After want: %s, got: %s", "2", got)
+	}
+	if nextCalls != 1 {
+		t.Errorf("next calls want: %d, got: %d", 1, nextCalls)
+	}
+
+	got := counterValue(metricsOptions.GatewayFunctionRateLimited.WithLabelValues("echo.openfaas-fn"))
+	if got != 1 {
+		t.Errorf("rate limited counter want: %f, got: %f", 1.0, got)
+	}
+}
+
+func Test_MakeRateLimitHandler_KeyedByHeader(t *testing.T) {
+	metricsOptions := metrics.BuildMetricsOptions()
+	query := fakeFunctionQuery{annotations: map[string]string{
+		RateLimitRPSAnnotation:   "1",
+		RateLimitBurstAnnotation: "1",
+		RateLimitKeyAnnotation:   "header:X-Api-Key",
+	}}
+
+	next := func(w http.ResponseWriter, r *http.Request) {}
+	handler := MakeRateLimitHandler(next, NewRateLimiter(), query, "openfaas-fn", &metricsOptions)
+
+	for _, key := range []string{"a", "b"} {
+		req := httptest.NewRequest(http.MethodGet, "/function/echo", nil)
+		req.Header.Set("X-Api-Key", key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("client %s status want: %d, got: %d", key, http.StatusOK, rec.Code)
+		}
+	}
+}
+
+func Test_MakeRateLimitHandler_NoAnnotationPassesThrough(t *testing.T) {
+	metricsOptions := metrics.BuildMetricsOptions()
+	query := fakeFunctionQuery{annotations: map[string]string{}}
+
+	nextCalls := 0
+	next := func(w http.ResponseWriter, r *http.Request) {
+		nextCalls++
+	}
+	handler := MakeRateLimitHandler(next, NewRateLimiter(), query, "openfaas-fn", &metricsOptions)
+
+	for i := 0; i < 5; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/function/echo", nil))
+	}
+
+	if nextCalls != 5 {
+		t.Errorf("next calls want: %d, got: %d", 5, nextCalls)
+	}
+}
+
+func counterValue(c prometheus.Counter) float64 {
+	m := &dto.Metric{}
+	c.Write(m)
+	return m.GetCounter().GetValue()
+}
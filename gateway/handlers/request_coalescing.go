@@ -0,0 +1,150 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/openfaas/faas/gateway/metrics"
+	"github.com/openfaas/faas/gateway/pkg/middleware"
+	"github.com/openfaas/faas/gateway/scaling"
+)
+
+const (
+	// CoalesceAnnotation enables request coalescing for a function when set
+	// to "true", concurrent identical GET and HEAD requests share a single
+	// invocation of the function.
+	CoalesceAnnotation = "com.openfaas.coalesce"
+
+	// CoalesceHeadersAnnotation lists request headers, separated by commas,
+	// which must also match for requests to be coalesced i.e. "Accept"
+	CoalesceHeadersAnnotation = "com.openfaas.coalesce.headers"
+)
+
+// maxCoalesceBodyBytes is the largest response body which is shared with
+// coalesced requests, larger responses are invoked again by each request
+const maxCoalesceBodyBytes = 1024 * 1024
+
+// coalescedResponse is the response of the request which invoked the
+// function, nil when it cannot be shared
+type coalescedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// coalescedCall is an invocation in progress, response is set before done
+// is closed
+type coalescedCall struct {
+	done     chan struct{}
+	response *coalescedResponse
+}
+
+// MakeRequestCoalescingHandler shares one invocation of a function between
+// concurrent GET and HEAD requests with the same path, query string and
+// credentials, for functions with the CoalesceAnnotation. The first request
+// is proxied as normal and the others receive a copy of its response.
+// Streamed, oversized and cookie-setting responses are not shared, and the
+// waiting requests invoke the function themselves, as they do when the first
+// request panics. A waiting request returns as soon as its client goes away.
+func MakeRequestCoalescingHandler(next http.HandlerFunc, functionQuery scaling.FunctionQuery, defaultNamespace string, metricsOptions *metrics.MetricOptions) http.HandlerFunc {
+	calls := map[string]*coalescedCall{}
+	var lock sync.Mutex
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || isUpgradeRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		functionName, namespace := middleware.GetNamespace(defaultNamespace, middleware.GetServiceName(r.URL.String()))
+
+		annotations, err := functionQuery.GetAnnotations(functionName, namespace)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		value, ok := annotations[CoalesceAnnotation]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Printf("Function %s.%s has an invalid %s annotation: %q", functionName, namespace, CoalesceAnnotation, value)
+		}
+		if !enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Responses are only shared between callers with the same credentials
+		keyHeaders := append(parseCacheHeaders(annotations[CoalesceHeadersAnnotation]), "Authorization", "Cookie")
+		key := r.Method + " " + cacheBaseKey(r, functionName+"."+namespace, keyHeaders)
+
+		lock.Lock()
+		call, joined := calls[key]
+		if !joined {
+			call = &coalescedCall{done: make(chan struct{})}
+			calls[key] = call
+		}
+		lock.Unlock()
+
+		if !joined {
+			// Deferred so that waiting requests are released when next panics
+			defer func() {
+				lock.Lock()
+				delete(calls, key)
+				lock.Unlock()
+				close(call.done)
+			}()
+
+			recorder := &cacheRecorder{ResponseWriter: w, limit: maxCoalesceBodyBytes}
+			next.ServeHTTP(recorder, r)
+
+			if recorder.status == 0 || recorder.overflow || recorder.flushed ||
+				r.Context().Err() != nil ||
+				len(recorder.header.Values("Set-Cookie")) > 0 {
+				return
+			}
+
+			call.response = &coalescedResponse{
+				status: recorder.status,
+				header: recorder.header,
+				body:   recorder.body,
+			}
+			return
+		}
+
+		select {
+		case <-call.done:
+		case <-r.Context().Done():
+			return
+		}
+
+		response := call.response
+		if response == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		metricsOptions.GatewayFunctionCoalescedTotal.WithLabelValues(functionLabel(functionName, namespace)).Inc()
+
+		header := w.Header()
+		for name, values := range response.header {
+			header[name] = append([]string(nil), values...)
+		}
+		w.WriteHeader(response.status)
+		if r.Method != http.MethodHead {
+			w.Write(response.body)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/metrics"
+)
+
+// invokeConcurrently sends the first request and, once the function has
+// been invoked, the remaining requests, then releases the function
+func invokeConcurrently(handler http.HandlerFunc, invoked <-chan struct{}, release chan<- struct{}, requests []*http.Request) []*httptest.ResponseRecorder {
+	recorders := make([]*httptest.ResponseRecorder, len(requests))
+	wg := sync.WaitGroup{}
+
+	for i, req := range requests {
+		recorders[i] = httptest.NewRecorder()
+
+		wg.Add(1)
+		go func(rec *httptest.ResponseRecorder, req *http.Request) {
+			defer wg.Done()
+			handler.ServeHTTP(rec, req)
+		}(recorders[i], req)
+
+		if i == 0 {
+			<-invoked
+		}
+	}
+
+	// Give the remaining requests time to join the in-flight invocation
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	return recorders
+}
+
+func Test_MakeRequestCoalescingHandler(t *testing.T) {
+	scenarios := []struct {
+		name          string
+		annotations   map[string]string
+		setCookie     bool
+		authorization []string
+		wantCalls     int32
+		wantCoalesced float64
+	}{
+		{
+			name:          "identical requests share one invocation",
+			annotations:   map[string]string{CoalesceAnnotation: "true"},
+			authorization: []string{"", "", "", ""},
+			wantCalls:     1,
+			wantCoalesced: 3,
+		},
+		{
+			name:          "function without the annotation",
+			annotations:   map[string]string{},
+			authorization: []string{"", "", ""},
+			wantCalls:     3,
+		},
+		{
+			name:          "requests with different credentials",
+			annotations:   map[string]string{CoalesceAnnotation: "true"},
+			authorization: []string{"Bearer a", "Bearer b", "Bearer a"},
+			wantCalls:     2,
+			wantCoalesced: 1,
+		},
+		{
+			name:          "response with a cookie",
+			annotations:   map[string]string{CoalesceAnnotation: "true"},
+			setCookie:     true,
+			authorization: []string{"", "", ""},
+			wantCalls:     3,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			metricsOptions := metrics.BuildMetricsOptions()
+
+			var calls int32
+			invoked := make(chan struct{}, len(s.authorization))
+			release := make(chan struct{})
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				invoked <- struct{}{}
+				<-release
+
+				if s.setCookie {
+					w.Header().Set("Set-Cookie", "session=1")
+				}
+				w.Header().Set("Content-Type", "text/plain")
+				w.WriteHeader(http.StatusAccepted)
+				w.Write([]byte("report"))
+			}
+
+			handler := MakeRequestCoalescingHandler(next, fakeFunctionQuery{annotations: s.annotations}, "openfaas-fn", &metricsOptions)
+
+			var requests []*http.Request
+			for _, authorization := range s.authorization {
+				req := httptest.NewRequest(http.MethodGet, "/function/reports/daily?format=csv", nil)
+				if len(authorization) > 0 {
+					req.Header.Set("Authorization", authorization)
+				}
+				requests = append(requests, req)
+			}
+
+			for i, rec := range invokeConcurrently(handler, invoked, release, requests) {
+				if rec.Code != http.StatusAccepted || rec.Body.String() != "report" {
+					t.Errorf("request %d want: %d %q, got: %d %q", i, http.StatusAccepted, "report", rec.Code, rec.Body.String())
+				}
+				if got := rec.Header().Get("Content-Type"); got != "text/plain" {
+					t.Errorf("request %d Content-Type want: text/plain, got: %q", i, got)
+				}
+			}
+
+			if got := atomic.LoadInt32(&calls); got != s.wantCalls {
+				t.Errorf("function calls want: %d, got: %d", s.wantCalls, got)
+			}
+
+			coalesced := counterValue(metricsOptions.GatewayFunctionCoalescedTotal.WithLabelValues("reports.openfaas-fn"))
+			if coalesced != s.wantCoalesced {
+				t.Errorf("coalesced want: %f, got: %f", s.wantCoalesced, coalesced)
+			}
+		})
+	}
+}
+
+func Test_MakeRequestCoalescingHandler_WaitingRequests(t *testing.T) {
+	metricsOptions := metrics.BuildMetricsOptions()
+
+	var calls int32
+	invoked := make(chan struct{}, 1)
+	release := make(chan struct{})
+	next := func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			invoked <- struct{}{}
+			<-release
+			panic(http.ErrAbortHandler)
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+
+	handler := MakeRequestCoalescingHandler(next, fakeFunctionQuery{annotations: map[string]string{CoalesceAnnotation: "true"}}, "openfaas-fn", &metricsOptions)
+
+	go func() {
+		defer func() { recover() }()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/function/reports", nil))
+	}()
+	<-invoked
+
+	// A waiting request whose client goes away returns without the leader
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/function/reports", nil).WithContext(ctx))
+		close(cancelled)
+	}()
+
+	waiting := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(waiting, httptest.NewRequest(http.MethodGet, "/function/reports", nil))
+		close(done)
+	}()
+
+	time.Sleep(time.Millisecond * 50)
+	cancel()
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("want the cancelled request to return while the function runs")
+	}
+
+	// The leader panics, so the remaining request invokes the function
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("want the waiting request released after the panic")
+	}
+
+	if waiting.Code != http.StatusOK || atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("want the waiting request to invoke the function, got: %d with %d calls", waiting.Code, atomic.LoadInt32(&calls))
+	}
+}
+
+func Test_MakeRequestCoalescingHandler_IgnoresPOST(t *testing.T) {
+	metricsOptions := metrics.BuildMetricsOptions()
+	query := fakeFunctionQuery{annotations: map[string]string{CoalesceAnnotation: "true"}}
+
+	var calls int32
+	invoked := make(chan struct{}, 2)
+	release := make(chan struct{})
+	next := func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		invoked <- struct{}{}
+		<-release
+	}
+
+	handler := MakeRequestCoalescingHandler(next, query, "openfaas-fn", &metricsOptions)
+	invokeConcurrently(handler, invoked, release, []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/function/reports", nil),
+		httptest.NewRequest(http.MethodPost, "/function/reports", nil),
+	})
+
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("function calls want: %d, got: %d", 2, got)
+	}
+}
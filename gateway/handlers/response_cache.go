@@ -0,0 +1,511 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"container/list"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openfaas/faas/gateway/metrics"
+	"github.com/openfaas/faas/gateway/pkg/middleware"
+	"github.com/openfaas/faas/gateway/scaling"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// CacheAnnotation enables the response cache for GET requests to a
+	// function. The value is how long to keep responses which have no
+	// max-age in their Cache-Control header i.e. "30s", or "true" to only
+	// cache responses with a max-age.
+	CacheAnnotation = "com.openfaas.cache"
+
+	// CacheHeadersAnnotation lists request headers, separated by commas,
+	// which are part of the cache key i.e. "Accept-Language,Authorization"
+	CacheHeadersAnnotation = "com.openfaas.cache.headers"
+)
+
+// maxCacheEntryFraction limits a single response to a fraction of the
+// cache's capacity
+const maxCacheEntryFraction = 16
+
+// cachedResponse is a response stored in the ResponseCache
+type cachedResponse struct {
+	key      string
+	baseKey  string
+	function string
+	path     string
+
+	status  int
+	header  http.Header
+	body    []byte
+	stored  time.Time
+	expires time.Time
+	size    int64
+}
+
+// ResponseCache is an in-memory LRU cache of function responses limited
+// by the total size of the stored responses.
+type ResponseCache struct {
+	maxBytes int64
+	size     int64
+	lru      *list.List
+	entries  map[string]*list.Element
+
+	// varies holds the Vary header names for each base key and how many
+	// responses have been stored for the key
+	varies map[string]*cacheVary
+
+	sizeGauge prometheus.Gauge
+	lock      sync.Mutex
+}
+
+type cacheVary struct {
+	names []string
+	count int
+}
+
+// NewResponseCache creates a ResponseCache which holds up to maxBytes of
+// responses, the current size is tracked in sizeGauge
+func NewResponseCache(maxBytes int64, sizeGauge prometheus.Gauge) *ResponseCache {
+	return &ResponseCache{
+		maxBytes:  maxBytes,
+		lru:       list.New(),
+		entries:   make(map[string]*list.Element),
+		varies:    make(map[string]*cacheVary),
+		sizeGauge: sizeGauge,
+	}
+}
+
+// maxEntryBytes is the largest response body which will be stored
+func (c *ResponseCache) maxEntryBytes() int64 {
+	return c.maxBytes / maxCacheEntryFraction
+}
+
+// Get finds a fresh response for the request, matching on the headers
+// listed in the Vary header of the stored response
+func (c *ResponseCache) Get(r *http.Request, baseKey string) (*cachedResponse, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	vary, ok := c.varies[baseKey]
+	if !ok {
+		return nil, false
+	}
+
+	element, ok := c.entries[varyKey(r, baseKey, vary.names)]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*cachedResponse)
+	if time.Now().After(entry.expires) {
+		c.remove(element)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(element)
+	return entry, true
+}
+
+// Set stores a response, evicting the least recently used responses to
+// stay within the size limit
+func (c *ResponseCache) Set(r *http.Request, entry *cachedResponse) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	names := varyNames(entry.header)
+
+	// Responses stored before the function changed its Vary header can no
+	// longer be found
+	if vary, ok := c.varies[entry.baseKey]; ok && strings.Join(vary.names, ",") != strings.Join(names, ",") {
+		c.purge(func(e *cachedResponse) bool { return e.baseKey == entry.baseKey })
+	}
+
+	entry.key = varyKey(r, entry.baseKey, names)
+	if element, ok := c.entries[entry.key]; ok {
+		c.remove(element)
+	}
+
+	vary, ok := c.varies[entry.baseKey]
+	if !ok {
+		vary = &cacheVary{names: names}
+		c.varies[entry.baseKey] = vary
+	}
+
+	vary.count++
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.size += entry.size
+
+	for c.size > c.maxBytes && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+
+	c.sizeGauge.Set(float64(c.size))
+}
+
+// Purge removes the responses of a function, given as function.namespace,
+// whose path starts with pathPrefix. An empty function purges all functions.
+func (c *ResponseCache) Purge(function, pathPrefix string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	purged := c.purge(func(e *cachedResponse) bool {
+		return (len(function) == 0 || e.function == function) && strings.HasPrefix(e.path, pathPrefix)
+	})
+
+	c.sizeGauge.Set(float64(c.size))
+	return purged
+}
+
+func (c *ResponseCache) purge(match func(*cachedResponse) bool) int {
+	purged := 0
+	for element := c.lru.Front(); element != nil; {
+		next := element.Next()
+		if match(element.Value.(*cachedResponse)) {
+			c.remove(element)
+			purged++
+		}
+		element = next
+	}
+	return purged
+}
+
+func (c *ResponseCache) remove(element *list.Element) {
+	entry := element.Value.(*cachedResponse)
+
+	c.lru.Remove(element)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+
+	if vary, ok := c.varies[entry.baseKey]; ok {
+		vary.count--
+		if vary.count <= 0 {
+			delete(c.varies, entry.baseKey)
+		}
+	}
+}
+
+// MakeResponseCacheHandler serves GET requests for functions with the
+// CacheAnnotation from the ResponseCache. Responses are stored when they
+// have a 200 status and their Cache-Control header allows it, and are
+// revalidated with If-None-Match against their ETag.
+func MakeResponseCacheHandler(next http.HandlerFunc, cache *ResponseCache, functionQuery scaling.FunctionQuery, defaultNamespace string, metricsOptions *metrics.MetricOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || isUpgradeRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		functionName, namespace := middleware.GetNamespace(defaultNamespace, middleware.GetServiceName(r.URL.String()))
+
+		annotations, err := functionQuery.GetAnnotations(functionName, namespace)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		value, ok := annotations[CacheAnnotation]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		defaultTTL, err := parseCacheAnnotation(value)
+		if err != nil {
+			log.Printf("Function %s.%s has an invalid %s annotation: %q", functionName, namespace, CacheAnnotation, value)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		keyHeaders := parseCacheHeaders(annotations[CacheHeadersAnnotation])
+
+		requestCacheControl := parseCacheControl(r.Header.Get("Cache-Control"))
+		if _, noStore := requestCacheControl["no-store"]; noStore {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Responses to requests with credentials are only shared between
+		// callers with the same credentials
+		for _, name := range []string{"Authorization", "Cookie"} {
+			if len(r.Header.Values(name)) > 0 && !containsHeader(keyHeaders, name) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		label := functionLabel(functionName, namespace)
+		function := functionName + "." + namespace
+		baseKey := cacheBaseKey(r, function, keyHeaders)
+
+		if _, noCache := requestCacheControl["no-cache"]; !noCache {
+			if entry, ok := cache.Get(r, baseKey); ok {
+				metricsOptions.GatewayFunctionCacheTotal.WithLabelValues(label, "hit").Inc()
+				serveCachedResponse(w, r, entry)
+				return
+			}
+		}
+
+		metricsOptions.GatewayFunctionCacheTotal.WithLabelValues(label, "miss").Inc()
+
+		w.Header().Set("X-Cache", "MISS")
+		recorder := &cacheRecorder{ResponseWriter: w, limit: cache.maxEntryBytes()}
+		next.ServeHTTP(recorder, r)
+
+		ttl, ok := recorder.cacheTTL(defaultTTL)
+		if !ok {
+			return
+		}
+
+		now := time.Now()
+		header := recorder.header
+		header.Del("X-Cache")
+
+		entry := &cachedResponse{
+			baseKey:  baseKey,
+			function: function,
+			path:     middleware.FunctionPrefixTrimmingURLPathTransformer{}.Transform(r),
+			status:   recorder.status,
+			header:   header,
+			body:     recorder.body,
+			stored:   now,
+			expires:  now.Add(ttl),
+		}
+		entry.size = int64(len(entry.body) + len(baseKey) + len(entry.path))
+		for name, values := range header {
+			for _, value := range values {
+				entry.size += int64(len(name) + len(value))
+			}
+		}
+
+		cache.Set(r, entry)
+	}
+}
+
+// MakeCachePurgeHandler removes responses from the ResponseCache. The
+// function query parameter, given as function or function.namespace,
+// limits the purge to one function and the path parameter to paths with
+// the given prefix. With no parameters the whole cache is purged.
+func MakeCachePurgeHandler(cache *ResponseCache, defaultNamespace string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		function := ""
+		if name := q.Get("function"); len(name) > 0 {
+			functionName, namespace := middleware.GetNamespace(defaultNamespace, name)
+			if ns := q.Get("namespace"); len(ns) > 0 {
+				namespace = ns
+			}
+			function = functionName + "." + namespace
+		}
+
+		purged := cache.Purge(function, q.Get("path"))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]int{"purged": purged})
+	}
+}
+
+func serveCachedResponse(w http.ResponseWriter, r *http.Request, entry *cachedResponse) {
+	header := w.Header()
+	for name, values := range entry.header {
+		header[name] = append([]string(nil), values...)
+	}
+	header.Set("X-Cache", "HIT")
+	header.Set("Age", strconv.Itoa(int(time.Since(entry.stored).Seconds())))
+
+	if etag := entry.header.Get("ETag"); len(etag) > 0 && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		header.Del("Content-Length")
+		header.Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(entry.status)
+	w.Write(entry.body)
+}
+
+// cacheRecorder keeps a copy of a response body up to limit bytes and of
+// the headers as they were when the status was written, before any outer
+// handler such as compression changes them
+type cacheRecorder struct {
+	http.ResponseWriter
+
+	limit    int64
+	status   int
+	header   http.Header
+	body     []byte
+	overflow bool
+	flushed  bool
+}
+
+func (c *cacheRecorder) WriteHeader(code int) {
+	if c.status == 0 && code >= 200 {
+		c.status = code
+		c.header = c.Header().Clone()
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *cacheRecorder) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+
+	if !c.overflow {
+		if int64(len(c.body)+len(p)) > c.limit {
+			c.overflow = true
+			c.body = nil
+		} else {
+			c.body = append(c.body, p...)
+		}
+	}
+
+	return c.ResponseWriter.Write(p)
+}
+
+func (c *cacheRecorder) Flush() {
+	c.flushed = true
+	http.NewResponseController(c.ResponseWriter).Flush()
+}
+
+// Unwrap returns the original http.ResponseWriter for http.ResponseController
+func (c *cacheRecorder) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// cacheTTL decides whether the recorded response can be stored and for
+// how long, from its Cache-Control header or defaultTTL
+func (c *cacheRecorder) cacheTTL(defaultTTL time.Duration) (time.Duration, bool) {
+	if c.status != http.StatusOK || c.overflow || c.flushed {
+		return 0, false
+	}
+
+	header := c.header
+	if len(header.Values("Set-Cookie")) > 0 || strings.TrimSpace(header.Get("Vary")) == "*" {
+		return 0, false
+	}
+
+	cacheControl := parseCacheControl(header.Get("Cache-Control"))
+	for _, directive := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cacheControl[directive]; ok {
+			return 0, false
+		}
+	}
+
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if value, ok := cacheControl[directive]; ok {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+
+	return defaultTTL, defaultTTL > 0
+}
+
+func cacheBaseKey(r *http.Request, function string, keyHeaders []string) string {
+	var key strings.Builder
+	key.WriteString(function)
+	key.WriteString(" ")
+	key.WriteString(middleware.FunctionPrefixTrimmingURLPathTransformer{}.Transform(r))
+	key.WriteString("?")
+	key.WriteString(r.URL.RawQuery)
+
+	for _, name := range keyHeaders {
+		key.WriteString("\n")
+		key.WriteString(name)
+		key.WriteString(": ")
+		key.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+
+	return key.String()
+}
+
+func varyKey(r *http.Request, baseKey string, names []string) string {
+	key := baseKey
+	for _, name := range names {
+		key += "\nvary " + name + ": " + strings.Join(r.Header.Values(name), ",")
+	}
+	return key
+}
+
+func varyNames(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); len(name) > 0 {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+// parseCacheAnnotation reads the default TTL from the CacheAnnotation
+func parseCacheAnnotation(value string) (time.Duration, error) {
+	if value == "true" {
+		return 0, nil
+	}
+	return parseTimeoutAnnotation(value)
+}
+
+func parseCacheHeaders(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+	return names
+}
+
+func containsHeader(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCacheControl reads the directives of a Cache-Control header, the
+// value of directives without an argument is empty
+func parseCacheControl(value string) map[string]string {
+	directives := map[string]string{}
+	for _, part := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if len(name) > 0 {
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return directives
+}
+
+// etagMatches implements the weak comparison of If-None-Match
+func etagMatches(ifNoneMatch, etag string) bool {
+	if len(ifNoneMatch) == 0 {
+		return false
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,264 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/metrics"
+	"github.com/openfaas/faas/gateway/pkg/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newTestResponseCache(maxBytes int64) *ResponseCache {
+	return NewResponseCache(maxBytes, prometheus.NewGauge(prometheus.GaugeOpts{Name: "cache_bytes"}))
+}
+
+func Test_MakeResponseCacheHandler_ServesHitsAndRevalidates(t *testing.T) {
+	metricsOptions := metrics.BuildMetricsOptions()
+	query := fakeFunctionQuery{annotations: map[string]string{CacheAnnotation: "30s"}}
+
+	calls := 0
+	next := func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("ETag", `"v1"`)
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "call %d", calls)
+	}
+
+	handler := MakeResponseCacheHandler(next, newTestResponseCache(1024*1024), query, "openfaas-fn", &metricsOptions)
+
+	for i, want := range []string{"MISS", "HIT"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/function/users/1?full=1", nil))
+
+		if got := rec.Header().Get("X-Cache"); got != want {
+			t.Errorf("request %d X-Cache want: %s, got: %s", i, want, got)
+		}
+		if rec.Body.String() != "call 1" {
+			t.Errorf("request %d body want: %q, got: %q", i, "call 1", rec.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/function/users/1?full=1", nil)
+	req.Header.Set("If-None-Match", `W/"v1"`)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("revalidation want: %d with no body, got: %d %q", http.StatusNotModified, rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/function/users/1?full=0", nil))
+	if rec.Body.String() != "call 2" {
+		t.Errorf("different query want a miss, got: %q", rec.Body.String())
+	}
+
+	hits := counterValue(metricsOptions.GatewayFunctionCacheTotal.WithLabelValues("users.openfaas-fn", "hit"))
+	if hits != 2 {
+		t.Errorf("hits want: %f, got: %f", 2.0, hits)
+	}
+}
+
+func Test_MakeResponseCacheHandler_HonoursCacheControl(t *testing.T) {
+	scenarios := []struct {
+		name          string
+		annotation    string
+		cacheControl  string
+		requestHeader http.Header
+		wantCached    bool
+	}{
+		{name: "default ttl", annotation: "30s", wantCached: true},
+		{name: "max-age without default", annotation: "true", cacheControl: "public, max-age=60", wantCached: true},
+		{name: "no max-age without default", annotation: "true", wantCached: false},
+		{name: "no-store response", annotation: "30s", cacheControl: "no-store", wantCached: false},
+		{name: "private response", annotation: "30s", cacheControl: "private, max-age=60", wantCached: false},
+		{name: "authorized request", annotation: "30s", requestHeader: http.Header{"Authorization": {"Bearer x"}}, wantCached: false},
+		{name: "request with a cookie", annotation: "30s", requestHeader: http.Header{"Cookie": {"session=x"}}, wantCached: false},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			metricsOptions := metrics.BuildMetricsOptions()
+			query := fakeFunctionQuery{annotations: map[string]string{CacheAnnotation: s.annotation}}
+
+			calls := 0
+			next := func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if len(s.cacheControl) > 0 {
+					w.Header().Set("Cache-Control", s.cacheControl)
+				}
+				w.Write([]byte("ok"))
+			}
+
+			handler := MakeResponseCacheHandler(next, newTestResponseCache(1024*1024), query, "openfaas-fn", &metricsOptions)
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/function/users", nil)
+				for name, values := range s.requestHeader {
+					req.Header[name] = values
+				}
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			}
+
+			wantCalls := 2
+			if s.wantCached {
+				wantCalls = 1
+			}
+			if calls != wantCalls {
+				t.Errorf("function calls want: %d, got: %d", wantCalls, calls)
+			}
+		})
+	}
+}
+
+func Test_MakeResponseCacheHandler_Vary(t *testing.T) {
+	metricsOptions := metrics.BuildMetricsOptions()
+	query := fakeFunctionQuery{annotations: map[string]string{CacheAnnotation: "30s"}}
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte(r.Header.Get("Accept-Language")))
+	}
+	handler := MakeResponseCacheHandler(next, newTestResponseCache(1024*1024), query, "openfaas-fn", &metricsOptions)
+
+	for _, language := range []string{"en", "fr", "en", "fr"} {
+		req := httptest.NewRequest(http.MethodGet, "/function/greet", nil)
+		req.Header.Set("Accept-Language", language)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Body.String() != language {
+			t.Errorf("body want: %s, got: %s", language, rec.Body.String())
+		}
+	}
+}
+
+func Test_MakeResponseCacheHandler_StoresUncompressedResponse(t *testing.T) {
+	metricsOptions := metrics.BuildMetricsOptions()
+	query := fakeFunctionQuery{annotations: map[string]string{CacheAnnotation: "30s"}}
+
+	body := strings.Repeat("a", 512)
+	next := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(body))
+	}
+
+	handler := MakeCompressionHandler(
+		MakeResponseCacheHandler(next, newTestResponseCache(1024*1024), query, "openfaas-fn", &metricsOptions),
+		testCompressionOptions)
+
+	for _, encoding := range []string{"gzip", "", "gzip"} {
+		req := httptest.NewRequest(http.MethodGet, "/function/text", nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("Content-Encoding"); got != encoding {
+			t.Fatalf("Content-Encoding want: %q, got: %q", encoding, got)
+		}
+
+		var reader io.Reader = rec.Body
+		if encoding == "gzip" {
+			reader, _ = gzip.NewReader(rec.Body)
+		}
+		decoded, _ := io.ReadAll(reader)
+		if string(decoded) != body {
+			t.Errorf("body want: %d bytes, got: %d bytes", len(body), len(decoded))
+		}
+	}
+}
+
+func Test_ResponseCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newTestResponseCache(100 * maxCacheEntryFraction)
+
+	set := func(i int) {
+		path := fmt.Sprintf("/%d", i)
+		req := httptest.NewRequest(http.MethodGet, "/function/fn"+path, nil)
+		cache.Set(req, &cachedResponse{baseKey: path, function: "fn.openfaas-fn", path: path, header: http.Header{}, size: 100, expires: time.Now().Add(time.Minute)})
+	}
+	get := func(i int) bool {
+		path := fmt.Sprintf("/%d", i)
+		_, ok := cache.Get(httptest.NewRequest(http.MethodGet, "/function/fn"+path, nil), path)
+		return ok
+	}
+
+	for i := 0; i < maxCacheEntryFraction; i++ {
+		set(i)
+	}
+	get(0)
+	set(maxCacheEntryFraction)
+
+	if get(1) {
+		t.Errorf("want entry 1 evicted as the least recently used")
+	}
+	for _, i := range []int{0, 2, maxCacheEntryFraction} {
+		if !get(i) {
+			t.Errorf("want entry %d cached", i)
+		}
+	}
+}
+
+func Test_MakeCachePurgeHandler(t *testing.T) {
+	cache := newTestResponseCache(1024 * 1024)
+	for _, entry := range []*cachedResponse{
+		{baseKey: "1", function: "users.openfaas-fn", path: "/users/1"},
+		{baseKey: "2", function: "users.openfaas-fn", path: "/groups/1"},
+		{baseKey: "3", function: "orders.openfaas-fn", path: "/users/1"},
+	} {
+		entry.header = http.Header{}
+		cache.Set(httptest.NewRequest(http.MethodGet, "/", nil), entry)
+	}
+
+	handler := MakeCachePurgeHandler(cache, "openfaas-fn")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/system/cache?function=users&path=/users", nil))
+	if want := `{"purged":1}`; rec.Body.String() != want+"\n" {
+		t.Errorf("want: %s, got: %s", want, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/system/cache", nil))
+	if want := `{"purged":2}`; rec.Body.String() != want+"\n" {
+		t.Errorf("want: %s, got: %s", want, rec.Body.String())
+	}
+}
+
+func Test_MakeResponseCacheHandler_InsideTrafficSplit(t *testing.T) {
+	metricsOptions := metrics.BuildMetricsOptions()
+	query := fakeFunctionQuery{annotations: map[string]string{CacheAnnotation: "30s", TrafficSplitAnnotation: "users-v2=0"}}
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(middleware.GetServiceName(r.URL.String())))
+	}
+
+	cache := MakeResponseCacheHandler(next, newTestResponseCache(1024*1024), query, "openfaas-fn", &metricsOptions)
+	handler := MakeTrafficSplitHandler(cache, query, "openfaas-fn")
+
+	for _, version := range []string{"users-v2", "", "users-v2"} {
+		req := httptest.NewRequest(http.MethodGet, "/function/users", nil)
+		if len(version) > 0 {
+			req.Header.Set(VersionHeader, version)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		want := "users"
+		if len(version) > 0 {
+			want = version
+		}
+		if rec.Body.String() != want {
+			t.Errorf("version %q body want: %s, got: %s", version, want, rec.Body.String())
+		}
+	}
+}
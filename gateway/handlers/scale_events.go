@@ -0,0 +1,36 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/openfaas/faas/gateway/pkg/middleware"
+	"github.com/openfaas/faas/gateway/scaling"
+)
+
+// MakeScaleEventsHandler lists the scale events recorded by the gateway,
+// oldest first. The function query parameter, given as function or
+// function.namespace, limits the list to one function and the namespace
+// parameter to one namespace.
+func MakeScaleEventsHandler(events *scaling.ScaleEventLog, defaultNamespace string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		functionName, namespace := "", q.Get("namespace")
+		if name := q.Get("function"); len(name) > 0 {
+			functionName, namespace = middleware.GetNamespace(defaultNamespace, name)
+			if ns := q.Get("namespace"); len(ns) > 0 {
+				namespace = ns
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(events.Events(functionName, namespace))
+	}
+}
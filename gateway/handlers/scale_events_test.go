@@ -0,0 +1,63 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openfaas/faas/gateway/scaling"
+)
+
+func Test_MakeScaleEventsHandler(t *testing.T) {
+	events := scaling.NewScaleEventLog(10)
+	events.Record(scaling.ScaleEvent{Name: "api", Namespace: "openfaas-fn", Trigger: scaling.TriggerAlert, From: 1, To: 2})
+	events.Record(scaling.ScaleEvent{Name: "api", Namespace: "staging", Trigger: scaling.TriggerAPI, From: 2, To: 0})
+	events.Record(scaling.ScaleEvent{Name: "reports", Namespace: "openfaas-fn", Trigger: scaling.TriggerSchedule, From: 0, To: 10})
+
+	scenarios := []struct {
+		query string
+		want  int
+	}{
+		{query: "", want: 3},
+		{query: "?function=api", want: 1},
+		{query: "?function=api.staging", want: 1},
+		{query: "?function=api&namespace=staging", want: 1},
+		{query: "?namespace=openfaas-fn", want: 2},
+	}
+
+	handler := MakeScaleEventsHandler(events, "openfaas-fn")
+	for _, s := range scenarios {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/system/scale-events"+s.query, nil))
+
+		got := []scaling.ScaleEvent{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%q: %s", s.query, err)
+		}
+		if len(got) != s.want {
+			t.Errorf("%q want: %d events, got: %+v", s.query, s.want, got)
+		}
+	}
+}
+
+func Test_FunctionScaler_RecordsScaleFromZero(t *testing.T) {
+	events := scaling.NewScaleEventLog(10)
+	config := newTestScalingConfig(&fakeServiceQuery{readyAfter: 1}, scaling.ColdStartReject)
+	config.Events = events
+	scaler := scaling.NewFunctionScaler(config, scaling.NewFunctionCache(config.CacheExpiry))
+
+	if res := scaler.Scale("resize", "openfaas-fn"); !res.Available {
+		t.Fatalf("want the function available, got: %+v", res)
+	}
+
+	recorded := events.Events("resize", "openfaas-fn")
+	if len(recorded) != 1 || recorded[0].Trigger != scaling.TriggerScaleFromZero || recorded[0].From != 0 || recorded[0].To != 1 || len(recorded[0].Error) > 0 {
+		t.Errorf("want one successful scale from zero to 1, got: %+v", recorded)
+	}
+}
@@ -6,26 +6,66 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/openfaas/faas/gateway/pkg/middleware"
 	"github.com/openfaas/faas/gateway/scaling"
 )
 
+const (
+	// ColdStartPolicyAnnotation overrides the gateway's cold start policy
+	// for a function: "reject", "queue" or "wait"
+	ColdStartPolicyAnnotation = "com.openfaas.coldstart.policy"
+
+	// ColdStartMaxWaitAnnotation is how long a request can wait for the
+	// function to be ready with the "wait" policy i.e. "5m"
+	ColdStartMaxWaitAnnotation = "com.openfaas.coldstart.max_wait"
+)
+
+// coldStartRetryAfter is the Retry-After in seconds sent when a function
+// was not ready in time
+const coldStartRetryAfter = 5
+
 // MakeScalingHandler creates handler which can scale a function from
 // zero to N replica(s). After scaling the next http.HandlerFunc will
 // be called. If the function is not ready after the configured
-// amount of attempts / queries then next will not be invoked and a status
-// will be returned to the client.
-func MakeScalingHandler(next http.HandlerFunc, scaler scaling.FunctionScaler, config scaling.ScalingConfig, defaultNamespace string) http.HandlerFunc {
+// amount of attempts / queries then next will not be invoked and the
+// request is handled by the cold start policy: "reject" responds with a
+// 503, "queue" passes the request to queued for an asynchronous invocation
+// and "wait" keeps polling for up to the maximum wait before rejecting.
+// When room is set, requests for a function known not to be ready are held
+// in it, so that one request scales the function for all of them.
+func MakeScalingHandler(next http.HandlerFunc, scaler scaling.FunctionScaler, config scaling.ScalingConfig, defaultNamespace string, functionQuery scaling.FunctionQuery, queued http.HandlerFunc, room *WaitingRoom) http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		functionName, namespace := middleware.GetNamespace(defaultNamespace, middleware.GetServiceName(r.URL.String()))
 
-		res := scaler.Scale(functionName, namespace)
+		var res scaling.FunctionScaleResult
+		if room != nil && scaler.NotReady(functionName, namespace) {
+			var held bool
+			res, held = room.Hold(w, r, functionName, namespace, func(ctx context.Context) scaling.FunctionScaleResult {
+				// Scaled for every request in the room, so the wait only
+				// ends early once all of them have gone away
+				return scaleFromZero(ctx, scaler, config, functionQuery, functionName, namespace)
+			})
+			if !held {
+				return
+			}
+		} else {
+			res = scaleFromZero(r.Context(), scaler, config, functionQuery, functionName, namespace)
+		}
+
+		// The client has gone away, so there is no one to respond to
+		if r.Context().Err() != nil {
+			return
+		}
 
 		if !res.Found {
 			errStr := fmt.Sprintf("error finding function %s.%s: %s", functionName, namespace, res.Error.Error())
@@ -50,7 +90,78 @@ func MakeScalingHandler(next http.HandlerFunc, scaler scaling.FunctionScaler, co
 			return
 		}
 
-		log.Printf("[Scale] function=%s.%s 0=>N timed-out after %.4fs\n",
-			functionName, namespace, res.Duration.Seconds())
+		policy, _ := coldStartPolicy(config, functionQuery, functionName, namespace)
+
+		log.Printf("[Scale] function=%s.%s 0=>N timed-out after %.4fs, policy: %s\n",
+			functionName, namespace, res.Duration.Seconds(), policy)
+
+		if policy == scaling.ColdStartQueue {
+			if queued != nil {
+				queued.ServeHTTP(w, mux.SetURLVars(r, map[string]string{"name": functionName + "." + namespace}))
+				return
+			}
+			log.Printf("[Scale] function=%s.%s can't be queued without NATS, rejecting the request\n", functionName, namespace)
+		}
+
+		w.Header().Set("Retry-After", strconv.Itoa(coldStartRetryAfter))
+		http.Error(w, fmt.Sprintf("Function %s.%s is not ready yet", functionName, namespace), http.StatusServiceUnavailable)
+	}
+}
+
+// scaleFromZero scales the function, and with the wait policy keeps polling
+// for a ready replica up to the maximum wait. Polling stops once ctx is done.
+func scaleFromZero(ctx context.Context, scaler scaling.FunctionScaler, config scaling.ScalingConfig, functionQuery scaling.FunctionQuery, functionName, namespace string) scaling.FunctionScaleResult {
+	res := scaler.ScaleWithPolls(ctx, functionName, namespace, config.MaxPollCount)
+	if res.Error != nil || res.Available || !res.Found || config.FunctionPollInterval <= 0 {
+		return res
+	}
+
+	policy, maxWait := coldStartPolicy(config, functionQuery, functionName, namespace)
+	if policy != scaling.ColdStartWait {
+		return res
 	}
+
+	waited := res.Duration
+	if remaining := maxWait - waited; remaining > 0 && ctx.Err() == nil {
+		res = scaler.ScaleWithPolls(ctx, functionName, namespace, uint(remaining/config.FunctionPollInterval))
+		res.Duration += waited
+	}
+	return res
+}
+
+// coldStartPolicy reads the policy and maximum wait for a function from its
+// annotations, falling back to the gateway's configuration
+func coldStartPolicy(config scaling.ScalingConfig, functionQuery scaling.FunctionQuery, functionName, namespace string) (scaling.ColdStartPolicy, time.Duration) {
+	policy := config.ColdStartPolicy
+	if len(policy) == 0 {
+		policy = scaling.ColdStartReject
+	}
+	maxWait := config.ColdStartMaxWait
+
+	if functionQuery == nil {
+		return policy, maxWait
+	}
+
+	annotations, err := functionQuery.GetAnnotations(functionName, namespace)
+	if err != nil {
+		return policy, maxWait
+	}
+
+	if value, ok := annotations[ColdStartPolicyAnnotation]; ok {
+		if parsed, err := scaling.ParseColdStartPolicy(value); err != nil {
+			log.Printf("Function %s.%s has an invalid %s annotation: %s", functionName, namespace, ColdStartPolicyAnnotation, err)
+		} else {
+			policy = parsed
+		}
+	}
+
+	if value, ok := annotations[ColdStartMaxWaitAnnotation]; ok {
+		if parsed, err := parseTimeoutAnnotation(value); err != nil {
+			log.Printf("Function %s.%s has an invalid %s annotation: %q", functionName, namespace, ColdStartMaxWaitAnnotation, value)
+		} else {
+			maxWait = parsed
+		}
+	}
+
+	return policy, maxWait
 }
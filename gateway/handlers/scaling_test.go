@@ -0,0 +1,248 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas/gateway/scaling"
+)
+
+// fakeServiceQuery reports a ready replica after readyAfter queries once
+// the function has been scaled up
+type fakeServiceQuery struct {
+	readyAfter int
+
+	replicas uint64
+	queries  int
+	lock     sync.Mutex
+}
+
+func (f *fakeServiceQuery) GetReplicas(service, namespace string) (scaling.ServiceQueryResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	res := scaling.ServiceQueryResponse{Replicas: f.replicas}
+	if f.replicas > 0 {
+		f.queries++
+		if f.queries > f.readyAfter {
+			res.AvailableReplicas = f.replicas
+		}
+	}
+	return res, nil
+}
+
+func (f *fakeServiceQuery) SetReplicas(service, namespace string, count uint64) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.replicas = count
+	return nil
+}
+
+func newTestScalingConfig(query scaling.ServiceQuery, policy scaling.ColdStartPolicy) scaling.ScalingConfig {
+	return scaling.ScalingConfig{
+		MaxPollCount:         3,
+		SetScaleRetries:      2,
+		FunctionPollInterval: time.Millisecond,
+		CacheExpiry:          time.Millisecond,
+		ServiceQuery:         query,
+		ColdStartPolicy:      policy,
+		ColdStartMaxWait:     time.Second,
+	}
+}
+
+func Test_MakeScalingHandler_ColdStartPolicies(t *testing.T) {
+	scenarios := []struct {
+		name        string
+		policy      scaling.ColdStartPolicy
+		annotations map[string]string
+		readyAfter  int
+		wantStatus  int
+		wantInvoked bool
+		wantQueued  bool
+	}{
+		{name: "ready within the poll count", policy: scaling.ColdStartReject, readyAfter: 1, wantStatus: http.StatusOK, wantInvoked: true},
+		{name: "reject when not ready", policy: scaling.ColdStartReject, readyAfter: 100, wantStatus: http.StatusServiceUnavailable},
+		{name: "queue when not ready", policy: scaling.ColdStartQueue, readyAfter: 100, wantStatus: http.StatusAccepted, wantQueued: true},
+		{name: "wait until ready", policy: scaling.ColdStartWait, readyAfter: 20, wantStatus: http.StatusOK, wantInvoked: true},
+		{
+			name:        "wait beyond the annotation's limit",
+			policy:      scaling.ColdStartWait,
+			annotations: map[string]string{ColdStartMaxWaitAnnotation: "1ms"},
+			readyAfter:  1000,
+			wantStatus:  http.StatusServiceUnavailable,
+		},
+		{
+			name:        "annotation overrides the policy",
+			policy:      scaling.ColdStartReject,
+			annotations: map[string]string{ColdStartPolicyAnnotation: "queue"},
+			readyAfter:  100,
+			wantStatus:  http.StatusAccepted,
+			wantQueued:  true,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			query := &fakeServiceQuery{readyAfter: s.readyAfter}
+			config := newTestScalingConfig(query, s.policy)
+			scaler := scaling.NewFunctionScaler(config, scaling.NewFunctionCache(config.CacheExpiry))
+
+			invoked := false
+			next := func(w http.ResponseWriter, r *http.Request) {
+				invoked = true
+				w.WriteHeader(http.StatusOK)
+			}
+
+			queuedName := ""
+			queued := func(w http.ResponseWriter, r *http.Request) {
+				queuedName = mux.Vars(r)["name"]
+				w.WriteHeader(http.StatusAccepted)
+			}
+
+			handler := MakeScalingHandler(next, scaler, config, "openfaas-fn", fakeFunctionQuery{annotations: s.annotations}, queued, nil)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/function/resize", nil))
+
+			if rec.Code != s.wantStatus {
+				t.Errorf("status want: %d, got: %d", s.wantStatus, rec.Code)
+			}
+			if invoked != s.wantInvoked {
+				t.Errorf("invoked want: %t, got: %t", s.wantInvoked, invoked)
+			}
+			if s.wantQueued && queuedName != "resize.openfaas-fn" {
+				t.Errorf("queued function want: %s, got: %q", "resize.openfaas-fn", queuedName)
+			}
+			if s.wantStatus == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+				t.Errorf("want Retry-After header")
+			}
+		})
+	}
+}
+
+func Test_MakeScalingHandler_WaitEndsWhenClientGoes(t *testing.T) {
+	query := &fakeServiceQuery{readyAfter: 1000000}
+	config := newTestScalingConfig(query, scaling.ColdStartWait)
+	config.ColdStartMaxWait = time.Minute
+	scaler := scaling.NewFunctionScaler(config, scaling.NewFunctionCache(config.CacheExpiry))
+
+	handler := MakeScalingHandler(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("want next not invoked")
+	}, scaler, config, "openfaas-fn", fakeFunctionQuery{}, nil, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*50, cancel)
+
+	start := time.Now()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/function/resize", nil).WithContext(ctx))
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("want the handler to return once the request is cancelled, took: %s", elapsed)
+	}
+}
+
+func Test_MakeScalingHandler_QueueWithoutQueueRejects(t *testing.T) {
+	query := &fakeServiceQuery{readyAfter: 100}
+	config := newTestScalingConfig(query, scaling.ColdStartQueue)
+	scaler := scaling.NewFunctionScaler(config, scaling.NewFunctionCache(config.CacheExpiry))
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("want function not invoked")
+	}
+
+	handler := MakeScalingHandler(next, scaler, config, "openfaas-fn", fakeFunctionQuery{}, nil, nil)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/function/resize", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status want: %d, got: %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
+
+func Test_FunctionScaler_ProbesAfterCacheExpiry(t *testing.T) {
+	probe := &countingProbe{}
+	config := newTestScalingConfig(&fakeServiceQuery{}, scaling.ColdStartReject)
+	config.ReadinessProbe = probe
+	scaler := scaling.NewFunctionScaler(config, scaling.NewFunctionCache(time.Millisecond))
+
+	// Seen at zero replicas and scaled up, without waiting for a replica
+	if res := scaler.ScaleWithPolls(context.Background(), "resize", "openfaas-fn", 0); res.Available {
+		t.Fatalf("want the function not ready yet")
+	}
+
+	// The cache entry expires before the replica is seen
+	time.Sleep(time.Millisecond * 5)
+
+	if res := scaler.Scale("resize", "openfaas-fn"); !res.Available {
+		t.Fatalf("want the function available")
+	}
+	if probe.probes != 1 {
+		t.Errorf("want the function probed once after the cache expired, got: %d", probe.probes)
+	}
+
+	scaler.Scale("resize", "openfaas-fn")
+	if probe.probes != 1 {
+		t.Errorf("want no probe once the function is ready, got: %d", probe.probes)
+	}
+}
+
+// countingProbe passes after failing failures times
+type countingProbe struct {
+	failures int
+	probes   int
+}
+
+func (c *countingProbe) Ready(functionName, namespace string) bool {
+	c.probes++
+	return c.probes > c.failures
+}
+
+func Test_FunctionScaler_WaitsForReadinessProbe(t *testing.T) {
+	scenarios := []struct {
+		name          string
+		failures      int
+		wantAvailable bool
+	}{
+		{name: "probe passes within the poll count", failures: 1, wantAvailable: true},
+		{name: "probe does not pass in time", failures: 100, wantAvailable: false},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			probe := &countingProbe{failures: s.failures}
+			config := newTestScalingConfig(&fakeServiceQuery{}, scaling.ColdStartReject)
+			config.ReadinessProbe = probe
+			scaler := scaling.NewFunctionScaler(config, scaling.NewFunctionCache(time.Minute))
+
+			res := scaler.Scale("resize", "openfaas-fn")
+			if res.Available != s.wantAvailable {
+				t.Errorf("available want: %t, got: %t", s.wantAvailable, res.Available)
+			}
+			if probe.probes == 0 {
+				t.Errorf("want the function probed")
+			}
+
+			// Requests are only served from the cache once the probe passed
+			probes := probe.probes
+			scaler.Scale("resize", "openfaas-fn")
+			if s.wantAvailable && probe.probes != probes {
+				t.Errorf("want no probe once the function is ready, probes: %d", probe.probes-probes)
+			}
+			if !s.wantAvailable && probe.probes == probes {
+				t.Errorf("want the function probed again while not ready")
+			}
+		})
+	}
+}
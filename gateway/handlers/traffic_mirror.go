@@ -0,0 +1,207 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	fhttputil "github.com/openfaas/faas-provider/httputil"
+	"github.com/openfaas/faas/gateway/metrics"
+	"github.com/openfaas/faas/gateway/pkg/middleware"
+	"github.com/openfaas/faas/gateway/scaling"
+	"github.com/openfaas/faas/gateway/types"
+)
+
+const (
+	// TrafficMirrorAnnotation names a function in the same namespace which
+	// receives a copy of each invocation, its response is discarded
+	TrafficMirrorAnnotation = "com.openfaas.traffic.mirror"
+
+	// TrafficMirrorSampleAnnotation is the percentage of invocations to
+	// mirror, defaults to 100
+	TrafficMirrorSampleAnnotation = "com.openfaas.traffic.mirror.sample"
+
+	// MirroredFromHeader is set on mirrored requests to the name of the
+	// function which received the original request
+	MirroredFromHeader = "X-Mirrored-From"
+)
+
+const (
+	// maxMirrorBodyBytes is the largest request body which will be
+	// buffered to be mirrored, larger requests are not mirrored.
+	maxMirrorBodyBytes = 1024 * 1024
+
+	// maxMirrorInflight is the number of mirrored requests which can be
+	// in-flight at once, further requests are not mirrored.
+	maxMirrorInflight = 100
+)
+
+// mirrorResult is the outcome of a primary or mirrored request
+type mirrorResult struct {
+	statusCode int
+	duration   time.Duration
+}
+
+// MakeTrafficMirrorHandler sends a sampled copy of invocations of functions
+// with the TrafficMirrorAnnotation to the shadow function named in the
+// annotation. The shadow request carries the same body, headers and
+// X-Call-Id, runs asynchronously and its response is discarded. Differences
+// in status code and latency are recorded in metrics.
+func MakeTrafficMirrorHandler(next http.HandlerFunc,
+	proxy *types.HTTPClientReverseProxy,
+	baseURLResolver middleware.BaseURLResolver,
+	urlPathTransformer middleware.URLPathTransformer,
+	functionQuery scaling.FunctionQuery,
+	defaultNamespace string,
+	metricsOptions *metrics.MetricOptions) http.HandlerFunc {
+
+	inflight := make(chan struct{}, maxMirrorInflight)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName, namespace := middleware.GetNamespace(defaultNamespace, middleware.GetServiceName(r.URL.String()))
+
+		annotations, err := functionQuery.GetAnnotations(functionName, namespace)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		mirrorName, ok := annotations[TrafficMirrorAnnotation]
+		if !ok || len(mirrorName) == 0 || mirrorName == functionName {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !sampleMirror(annotations[TrafficMirrorSampleAnnotation]) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, ok := bufferRequestBody(r, maxMirrorBodyBytes)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		select {
+		case inflight <- struct{}{}:
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		mirrorReq := r.Clone(context.Background())
+		mirrorReq.Body = nil
+		mirrorReq.URL.Path = middleware.ReplaceServiceName(r.URL.Path, mirrorName)
+		mirrorReq.URL.RawPath = ""
+
+		timeout := upstreamTimeout(r, proxy.Timeout)
+		primary := make(chan mirrorResult, 1)
+
+		go func() {
+			defer func() { <-inflight }()
+
+			mirrored := sendMirror(proxy.Client, mirrorReq, body, functionName, timeout,
+				baseURLResolver.Resolve(mirrorReq), urlPathTransformer.Transform(mirrorReq))
+
+			// The channel is closed without a result when next panics
+			original, ok := <-primary
+			if !ok {
+				return
+			}
+
+			label := functionLabel(functionName, namespace)
+			statusMatch := strconv.FormatBool(original.statusCode == mirrored.statusCode)
+
+			metricsOptions.GatewayFunctionMirrorTotal.
+				WithLabelValues(label, mirrorName, strconv.Itoa(mirrored.statusCode), statusMatch).
+				Inc()
+			metricsOptions.GatewayFunctionMirrorLatencyDifference.
+				WithLabelValues(label, mirrorName).
+				Observe((mirrored.duration - original.duration).Seconds())
+		}()
+
+		defer close(primary)
+
+		ww := fhttputil.NewHttpWriteInterceptor(w)
+		start := time.Now()
+		next.ServeHTTP(ww, r)
+
+		primary <- mirrorResult{statusCode: ww.Status(), duration: time.Since(start)}
+	}
+}
+
+func sendMirror(client *http.Client, r *http.Request, body []byte, functionName string, timeout time.Duration, baseURL, requestURL string) mirrorResult {
+	upstreamReq := buildUpstreamRequest(r, baseURL, requestURL)
+	upstreamReq.Header.Set(MirroredFromHeader, functionName)
+	if body != nil {
+		upstreamReq.Body = io.NopCloser(bytes.NewReader(body))
+		upstreamReq.ContentLength = int64(len(body))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	res, err := client.Do(upstreamReq.WithContext(ctx))
+	if err != nil {
+		log.Printf("error with mirrored request to: %s, %s\n", requestURL, err.Error())
+		return mirrorResult{statusCode: http.StatusBadGateway, duration: time.Since(start)}
+	}
+	defer res.Body.Close()
+
+	io.Copy(io.Discard, res.Body)
+
+	return mirrorResult{statusCode: res.StatusCode, duration: time.Since(start)}
+}
+
+// bufferRequestBody reads the request body so it can be sent more than
+// once, ok is false when the body is larger than limit, in which case
+// r.Body is restored so that it can still be read in full.
+func bufferRequestBody(r *http.Request, limit int) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, int64(limit)+1))
+	if err != nil || len(body) > limit {
+		r.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(body), r.Body), Closer: r.Body}
+		return nil, false
+	}
+
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, true
+}
+
+// sampleMirror decides whether to mirror a request given the value of
+// the TrafficMirrorSampleAnnotation.
+func sampleMirror(value string) bool {
+	if len(value) == 0 {
+		return true
+	}
+
+	sample, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Invalid %s annotation: %q", TrafficMirrorSampleAnnotation, value)
+		return false
+	}
+
+	return rand.Float64()*100 < sample
+}
+
+// readCloser combines a Reader with the Closer of the original body
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,161 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/metrics"
+	"github.com/openfaas/faas/gateway/pkg/middleware"
+	"github.com/openfaas/faas/gateway/types"
+)
+
+func Test_MakeTrafficMirrorHandler_SendsCopyToShadow(t *testing.T) {
+	type mirrored struct {
+		path, body, callID, from string
+	}
+	received := make(chan mirrored, 1)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/function/echo-shadow") {
+			body, _ := io.ReadAll(r.Body)
+			received <- mirrored{
+				path:   r.URL.Path,
+				body:   string(body),
+				callID: r.Header.Get("X-Call-Id"),
+				from:   r.Header.Get(MirroredFromHeader),
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		io.Copy(w, r.Body)
+	}))
+	defer upstream.Close()
+
+	metricsOptions := metrics.BuildMetricsOptions()
+	query := fakeFunctionQuery{annotations: map[string]string{
+		TrafficMirrorAnnotation: "echo-shadow",
+	}}
+	resolver := middleware.SingleHostBaseURLResolver{BaseURL: upstream.URL}
+	transformer := middleware.TransparentURLPathTransformer{}
+	proxy := &types.HTTPClientReverseProxy{Client: upstream.Client(), Timeout: time.Second}
+
+	handler := MakeTrafficMirrorHandler(
+		MakeForwardingProxyHandler(proxy, nil, resolver, transformer, nil),
+		proxy, resolver, transformer, query, "openfaas-fn", &metricsOptions)
+
+	req := httptest.NewRequest(http.MethodPost, "/function/echo/path", strings.NewReader("hello"))
+	req.Header.Set("X-Call-Id", "call-1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+		t.Errorf("primary want: %d hello, got: %d %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	select {
+	case got := <-received:
+		want := mirrored{path: "/function/echo-shadow/path", body: "hello", callID: "call-1", from: "echo"}
+		if got != want {
+			t.Errorf("mirrored want: %+v, got: %+v", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for mirrored request")
+	}
+
+	counter := metricsOptions.GatewayFunctionMirrorTotal.WithLabelValues("echo.openfaas-fn", "echo-shadow", "500", "false")
+	deadline := time.Now().Add(time.Second)
+	for counterValue(counter) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("mirror counter want: %f, got: %f", 1.0, counterValue(counter))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_MakeTrafficMirrorHandler_ReleasesSlotWhenPrimaryPanics(t *testing.T) {
+	var lock sync.Mutex
+	mirrored := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		mirrored++
+		lock.Unlock()
+	}))
+	defer upstream.Close()
+
+	metricsOptions := metrics.BuildMetricsOptions()
+	query := fakeFunctionQuery{annotations: map[string]string{
+		TrafficMirrorAnnotation: "echo-shadow",
+	}}
+	resolver := middleware.SingleHostBaseURLResolver{BaseURL: upstream.URL}
+	transformer := middleware.TransparentURLPathTransformer{}
+	proxy := &types.HTTPClientReverseProxy{Client: upstream.Client(), Timeout: time.Second}
+
+	panics := true
+	handler := MakeTrafficMirrorHandler(func(w http.ResponseWriter, r *http.Request) {
+		if panics {
+			panic(http.ErrAbortHandler)
+		}
+	}, proxy, resolver, transformer, query, "openfaas-fn", &metricsOptions)
+
+	serve := func() {
+		defer func() { recover() }()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/function/echo", strings.NewReader("hello")))
+	}
+
+	for i := 0; i < maxMirrorInflight; i++ {
+		serve()
+	}
+
+	// Each slot is released once its mirrored request completes, so a
+	// request is mirrored again within the deadline
+	panics = false
+	deadline := time.Now().Add(time.Second)
+	for {
+		serve()
+
+		lock.Lock()
+		count := mirrored
+		lock.Unlock()
+		if count > maxMirrorInflight {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want a request mirrored after the primary panicked, got: %d mirrored", count)
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+func Test_bufferRequestBody_RestoresLargeBody(t *testing.T) {
+	payload := bytes.Repeat([]byte("a"), maxMirrorBodyBytes+10)
+	req := httptest.NewRequest(http.MethodPost, "/function/echo", bytes.NewReader(payload))
+
+	if _, ok := bufferRequestBody(req, maxMirrorBodyBytes); ok {
+		t.Errorf("want body over the limit not to be mirrored")
+	}
+
+	got, _ := io.ReadAll(req.Body)
+	if !bytes.Equal(got, payload) {
+		t.Errorf("body want: %d bytes, got: %d bytes", len(payload), len(got))
+	}
+}
+
+func Test_sampleMirror(t *testing.T) {
+	if !sampleMirror("") || !sampleMirror("100") {
+		t.Errorf("want requests mirrored by default and at 100")
+	}
+	if sampleMirror("0") || sampleMirror("all") {
+		t.Errorf("want requests not mirrored at 0 or with an invalid sample")
+	}
+}
@@ -0,0 +1,127 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/openfaas/faas/gateway/pkg/middleware"
+	"github.com/openfaas/faas/gateway/scaling"
+)
+
+const (
+	// TrafficSplitAnnotation sends a percentage of the invocations of a
+	// function to other functions in the same namespace, i.e.
+	// "checkout-v2=10" or "checkout-v2=10,checkout-v3=5"
+	TrafficSplitAnnotation = "com.openfaas.traffic.split"
+
+	// VersionHeader forces an invocation to be served by the named function,
+	// which must be the primary function or one of its split targets
+	VersionHeader = "X-Function-Version"
+)
+
+// trafficSplit is a function to send a percentage of traffic to
+type trafficSplit struct {
+	functionName string
+	weight       float64
+}
+
+// MakeTrafficSplitHandler routes a share of the invocations of a function
+// with the TrafficSplitAnnotation to the listed versions by rewriting the
+// request path, so that scaling, proxying and metrics all apply to the
+// version which actually serves the request.
+func MakeTrafficSplitHandler(next http.HandlerFunc, functionQuery scaling.FunctionQuery, defaultNamespace string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName, namespace := middleware.GetNamespace(defaultNamespace, middleware.GetServiceName(r.URL.String()))
+
+		annotations, err := functionQuery.GetAnnotations(functionName, namespace)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		value, ok := annotations[TrafficSplitAnnotation]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		splits, err := parseTrafficSplit(value)
+		if err != nil {
+			log.Printf("Function %s.%s has an invalid %s annotation: %s", functionName, namespace, TrafficSplitAnnotation, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		target := chooseTrafficSplit(functionName, splits, r.Header.Get(VersionHeader), rand.Float64()*100)
+		if target != functionName {
+			r.URL.Path = middleware.ReplaceServiceName(r.URL.Path, target)
+			r.URL.RawPath = ""
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// chooseTrafficSplit picks the function to serve a request, roll is a
+// number in the range [0, 100).
+func chooseTrafficSplit(functionName string, splits []trafficSplit, override string, roll float64) string {
+	if len(override) > 0 {
+		if override == functionName {
+			return functionName
+		}
+		for _, split := range splits {
+			if split.functionName == override {
+				return override
+			}
+		}
+	}
+
+	for _, split := range splits {
+		if roll < split.weight {
+			return split.functionName
+		}
+		roll -= split.weight
+	}
+
+	return functionName
+}
+
+func parseTrafficSplit(value string) ([]trafficSplit, error) {
+	var splits []trafficSplit
+	total := 0.0
+
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+
+		name, weightValue, found := strings.Cut(part, "=")
+		if !found || len(strings.TrimSpace(name)) == 0 {
+			return nil, fmt.Errorf("expected name=weight, got: %q", part)
+		}
+
+		weight, err := strconv.ParseFloat(strings.TrimSpace(weightValue), 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight for %s: %q", name, weightValue)
+		}
+
+		total += weight
+		splits = append(splits, trafficSplit{functionName: strings.TrimSpace(name), weight: weight})
+	}
+
+	if total > 100 {
+		return nil, fmt.Errorf("weights add up to %.2f, must be 100 or less", total)
+	}
+
+	return splits, nil
+}
@@ -0,0 +1,95 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_parseTrafficSplit(t *testing.T) {
+	splits, err := parseTrafficSplit("checkout-v2=10, checkout-v3=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(splits) != 2 {
+		t.Fatalf("splits want: %d, got: %d", 2, len(splits))
+	}
+	if splits[1].functionName != "checkout-v3" || splits[1].weight != 5 {
+		t.Errorf("split want: checkout-v3=5, got: %s=%f", splits[1].functionName, splits[1].weight)
+	}
+
+	for _, invalid := range []string{"checkout-v2", "checkout-v2=ten", "a=60,b=50", "=10"} {
+		if _, err := parseTrafficSplit(invalid); err == nil {
+			t.Errorf("want error for %q", invalid)
+		}
+	}
+}
+
+func Test_chooseTrafficSplit(t *testing.T) {
+	splits := []trafficSplit{{functionName: "checkout-v2", weight: 10}, {functionName: "checkout-v3", weight: 5}}
+
+	scenarios := []struct {
+		name     string
+		override string
+		roll     float64
+		want     string
+	}{
+		{name: "roll within first split", roll: 9.9, want: "checkout-v2"},
+		{name: "roll within second split", roll: 12, want: "checkout-v3"},
+		{name: "roll outside splits", roll: 15, want: "checkout"},
+		{name: "override to split target", override: "checkout-v3", roll: 99, want: "checkout-v3"},
+		{name: "override to primary", override: "checkout", roll: 0, want: "checkout"},
+		{name: "unknown override ignored", override: "billing", roll: 50, want: "checkout"},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			got := chooseTrafficSplit("checkout", splits, s.override, s.roll)
+			if got != s.want {
+				t.Errorf("want: %s, got: %s", s.want, got)
+			}
+		})
+	}
+}
+
+func Test_MakeTrafficSplitHandler_RewritesPathForOverride(t *testing.T) {
+	query := fakeFunctionQuery{annotations: map[string]string{TrafficSplitAnnotation: "checkout-v2=0"}}
+
+	var gotPath string
+	next := func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+	}
+
+	handler := MakeTrafficSplitHandler(next, query, "openfaas-fn")
+
+	req := httptest.NewRequest(http.MethodGet, "/function/checkout.openfaas-fn/cart", nil)
+	req.Header.Set(VersionHeader, "checkout-v2")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	want := "/function/checkout-v2.openfaas-fn/cart"
+	if gotPath != want {
+		t.Errorf("path want: %s, got: %s", want, gotPath)
+	}
+}
+
+func Test_MakeTrafficSplitHandler_FullWeightLabelsServedVersion(t *testing.T) {
+	query := fakeFunctionQuery{annotations: map[string]string{TrafficSplitAnnotation: "checkout-v2=100"}}
+
+	var gotLabel string
+	next := func(w http.ResponseWriter, r *http.Request) {
+		gotLabel = PrometheusFunctionNotifier{FunctionNamespace: "openfaas-fn"}.serviceName(r.URL.String())
+	}
+
+	handler := MakeTrafficSplitHandler(next, query, "openfaas-fn")
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/function/checkout", nil))
+
+	if gotLabel != "checkout-v2.openfaas-fn" {
+		t.Errorf("function_name label want: %s, got: %s", "checkout-v2.openfaas-fn", gotLabel)
+	}
+}
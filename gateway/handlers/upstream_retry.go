@@ -0,0 +1,197 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/openfaas/faas/gateway/metrics"
+	"github.com/openfaas/faas/gateway/pkg/middleware"
+	"github.com/openfaas/faas/gateway/types"
+)
+
+// IdempotencyKeyHeader marks a request as safe to retry whatever its method
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const (
+	// maxRetryBodyBytes is the largest request body which will be
+	// buffered so that it can be retried, larger requests are sent once.
+	maxRetryBodyBytes = 1024 * 1024
+
+	// retryBudgetBurst is the number of retries a function can make at
+	// once before its budget is limited to the ratio of requests
+	retryBudgetBurst = 10
+)
+
+// RetryOptions configure retries of failed calls to functions
+type RetryOptions struct {
+	// Attempts is the number of retries after the first call, 0 disables
+	// retries
+	Attempts int
+
+	// Backoff is the delay between retries
+	Backoff types.Backoff
+
+	// StatusCodes are the upstream responses which are retried in addition
+	// to refused and reset connections
+	StatusCodes []int
+
+	// BudgetRatio is the number of retries allowed for each request to a
+	// function i.e. 0.2 for one retry per five requests
+	BudgetRatio float64
+}
+
+// NewRetryReverseProxy returns a copy of proxy whose client retries calls to
+// functions which fail with a refused or reset connection, or one of the
+// StatusCodes. Only idempotent methods and requests with an Idempotency-Key
+// header are retried, and each function has a retry budget so that an
+// outage does not multiply the load on the provider.
+func NewRetryReverseProxy(proxy *types.HTTPClientReverseProxy, options RetryOptions, defaultNamespace string, metricsOptions *metrics.MetricOptions) *types.HTTPClientReverseProxy {
+	client := *proxy.Client
+
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	client.Transport = &retryTransport{
+		next:             next,
+		options:          options,
+		defaultNamespace: defaultNamespace,
+		metricsOptions:   metricsOptions,
+		budgets:          make(map[string]*types.RetryBudget),
+	}
+
+	return &types.HTTPClientReverseProxy{
+		BaseURL: proxy.BaseURL,
+		Client:  &client,
+		Timeout: proxy.Timeout,
+	}
+}
+
+// retryTransport is an http.RoundTripper which retries failed calls to
+// functions
+type retryTransport struct {
+	next             http.RoundTripper
+	options          RetryOptions
+	defaultNamespace string
+	metricsOptions   *metrics.MetricOptions
+
+	budgets map[string]*types.RetryBudget
+	lock    sync.Mutex
+}
+
+// RoundTrip implements the RoundTripper interface.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.options.Attempts <= 0 || !strings.HasPrefix(req.URL.Path, "/function/") || !isIdempotent(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	functionName, namespace := middleware.GetNamespace(t.defaultNamespace, middleware.GetServiceName(req.URL.Path))
+	label := functionLabel(functionName, namespace)
+	t.deposit(label)
+
+	base := req.Clone(req.Context())
+	body, ok := bufferRequestBody(base, maxRetryBodyBytes)
+	if !ok {
+		return t.next.RoundTrip(base)
+	}
+
+	for attempt := 0; ; attempt++ {
+		try := base.Clone(base.Context())
+		if body != nil {
+			try.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		res, err := t.next.RoundTrip(try)
+
+		reason := retryReason(res, err, t.options.StatusCodes)
+		if len(reason) == 0 || attempt >= t.options.Attempts || req.Context().Err() != nil {
+			return res, err
+		}
+
+		if !t.budget(label).Withdraw() {
+			t.metricsOptions.GatewayFunctionRetryBudgetExhausted.WithLabelValues(label).Inc()
+			return res, err
+		}
+
+		if res != nil {
+			io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+			res.Body.Close()
+		}
+
+		t.metricsOptions.GatewayFunctionRetriesTotal.WithLabelValues(label, reason).Inc()
+
+		timer := time.NewTimer(t.options.Backoff.Delay(attempt))
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// deposit records a request against the function's budget, budgets are
+// only created once a function needs a retry, and start full
+func (t *retryTransport) deposit(label string) {
+	t.lock.Lock()
+	budget, ok := t.budgets[label]
+	t.lock.Unlock()
+
+	if ok {
+		budget.Deposit()
+	}
+}
+
+func (t *retryTransport) budget(label string) *types.RetryBudget {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	budget, ok := t.budgets[label]
+	if !ok {
+		budget = types.NewRetryBudget(t.options.BudgetRatio, retryBudgetBurst)
+		t.budgets[label] = budget
+	}
+	return budget
+}
+
+// isIdempotent reports whether a request can be sent more than once
+func isIdempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return len(r.Header.Get(IdempotencyKeyHeader)) > 0
+}
+
+// retryReason returns why a call should be retried, or an empty string
+// when it should not be
+func retryReason(res *http.Response, err error, statusCodes []int) string {
+	if err != nil {
+		switch {
+		case errors.Is(err, syscall.ECONNREFUSED):
+			return "connection_refused"
+		case errors.Is(err, syscall.ECONNRESET):
+			return "connection_reset"
+		}
+		return ""
+	}
+
+	if slices.Contains(statusCodes, res.StatusCode) {
+		return strconv.Itoa(res.StatusCode)
+	}
+	return ""
+}
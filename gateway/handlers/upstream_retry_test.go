@@ -0,0 +1,161 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/metrics"
+	"github.com/openfaas/faas/gateway/types"
+)
+
+var testRetryOptions = RetryOptions{
+	Attempts:    3,
+	Backoff:     types.Backoff{Initial: time.Millisecond, Max: time.Millisecond * 5},
+	StatusCodes: []int{http.StatusBadGateway, http.StatusServiceUnavailable},
+	BudgetRatio: 0.2,
+}
+
+func newTestRetryClient(options RetryOptions, metricsOptions *metrics.MetricOptions) *http.Client {
+	proxy := &types.HTTPClientReverseProxy{Client: &http.Client{}, Timeout: time.Second}
+	return NewRetryReverseProxy(proxy, options, "openfaas-fn", metricsOptions).Client
+}
+
+func Test_NewRetryReverseProxy_RetriesIdempotentRequests(t *testing.T) {
+	scenarios := []struct {
+		name           string
+		method         string
+		idempotencyKey string
+		wantCalls      int32
+		wantStatus     int
+	}{
+		{name: "PUT is retried", method: http.MethodPut, wantCalls: 3, wantStatus: http.StatusOK},
+		{name: "POST is not retried", method: http.MethodPost, wantCalls: 1, wantStatus: http.StatusServiceUnavailable},
+		{name: "POST with an Idempotency-Key is retried", method: http.MethodPost, idempotencyKey: "order-1", wantCalls: 3, wantStatus: http.StatusOK},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			metricsOptions := metrics.BuildMetricsOptions()
+
+			var calls int32
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if string(body) != "payload" {
+					t.Errorf("call %d body want: %q, got: %q", atomic.LoadInt32(&calls), "payload", string(body))
+				}
+
+				if atomic.AddInt32(&calls, 1) < 3 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer upstream.Close()
+
+			req, _ := http.NewRequest(s.method, upstream.URL+"/function/orders", io.NopCloser(strings.NewReader("payload")))
+			if len(s.idempotencyKey) > 0 {
+				req.Header.Set(IdempotencyKeyHeader, s.idempotencyKey)
+			}
+
+			res, err := newTestRetryClient(testRetryOptions, &metricsOptions).Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			if res.StatusCode != s.wantStatus {
+				t.Errorf("status want: %d, got: %d", s.wantStatus, res.StatusCode)
+			}
+			if got := atomic.LoadInt32(&calls); got != s.wantCalls {
+				t.Errorf("calls want: %d, got: %d", s.wantCalls, got)
+			}
+
+			retries := counterValue(metricsOptions.GatewayFunctionRetriesTotal.WithLabelValues("orders.openfaas-fn", "503"))
+			if want := float64(s.wantCalls - 1); retries != want {
+				t.Errorf("retries want: %f, got: %f", want, retries)
+			}
+		})
+	}
+}
+
+func Test_NewRetryReverseProxy_RetriesRefusedConnection(t *testing.T) {
+	metricsOptions := metrics.BuildMetricsOptions()
+
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	url := upstream.URL
+	upstream.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, url+"/function/orders", nil)
+	if _, err := newTestRetryClient(testRetryOptions, &metricsOptions).Do(req); err == nil {
+		t.Fatalf("want error for a refused connection")
+	}
+
+	retries := counterValue(metricsOptions.GatewayFunctionRetriesTotal.WithLabelValues("orders.openfaas-fn", "connection_refused"))
+	if retries != float64(testRetryOptions.Attempts) {
+		t.Errorf("retries want: %d, got: %f", testRetryOptions.Attempts, retries)
+	}
+}
+
+func Test_NewRetryReverseProxy_StopsWhenBudgetIsSpent(t *testing.T) {
+	metricsOptions := metrics.BuildMetricsOptions()
+
+	var calls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer upstream.Close()
+
+	options := testRetryOptions
+	options.BudgetRatio = 0
+	client := newTestRetryClient(options, &metricsOptions)
+
+	for i := 0; i < 5; i++ {
+		res, err := client.Get(upstream.URL + "/function/orders")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	// 5 requests plus the burst of retries
+	if want := int32(5 + retryBudgetBurst); atomic.LoadInt32(&calls) != want {
+		t.Errorf("calls want: %d, got: %d", want, atomic.LoadInt32(&calls))
+	}
+
+	exhausted := counterValue(metricsOptions.GatewayFunctionRetryBudgetExhausted.WithLabelValues("orders.openfaas-fn"))
+	if exhausted == 0 {
+		t.Errorf("want requests counted as exhausting the retry budget")
+	}
+}
+
+func Test_NewRetryReverseProxy_IgnoresSystemCalls(t *testing.T) {
+	metricsOptions := metrics.BuildMetricsOptions()
+
+	var calls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+
+	res, err := newTestRetryClient(testRetryOptions, &metricsOptions).Get(upstream.URL + "/system/functions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("calls want: %d, got: %d", 1, got)
+	}
+}
@@ -0,0 +1,191 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/openfaas/faas/gateway/scaling"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WaitingRoomConfig limits the requests held for each function while it
+// scales from zero
+type WaitingRoomConfig struct {
+	// MaxRequests is the most requests held for a function
+	MaxRequests int
+
+	// MaxBytes is the most bytes of request bodies buffered for a function
+	MaxBytes int64
+
+	// MaxBodyBytes is the largest request body which is buffered, larger
+	// bodies are left unread until the request is released
+	MaxBodyBytes int64
+}
+
+// coldStart is the waiting room of one function, the first request scales
+// the function and the result is shared with the requests which joined
+type coldStart struct {
+	requests int
+	bytes    int64
+
+	done   chan struct{}
+	result scaling.FunctionScaleResult
+
+	// cancel ends the scale once every request has gone away
+	cancel context.CancelFunc
+}
+
+// WaitingRoom holds the requests for functions which are scaling from zero,
+// so that only one request per function polls the provider while the rest
+// wait to be released together once it is ready. Small request bodies are
+// buffered while the request waits and replayed when it is released.
+type WaitingRoom struct {
+	config      WaitingRoomConfig
+	waitSeconds *prometheus.HistogramVec
+
+	rooms map[string]*coldStart
+	lock  sync.Mutex
+}
+
+// NewWaitingRoom creates a WaitingRoom which records the time requests
+// waited in waitSeconds
+func NewWaitingRoom(config WaitingRoomConfig, waitSeconds *prometheus.HistogramVec) *WaitingRoom {
+	return &WaitingRoom{
+		config:      config,
+		waitSeconds: waitSeconds,
+		rooms:       make(map[string]*coldStart),
+	}
+}
+
+// Hold parks r until the function has scaled, the first request for a
+// function runs scale and its result is given to every request held with
+// it. The context given to scale is done once every held request has gone
+// away. When the waiting room is full, or the client goes away, a response
+// has been written and false is returned.
+func (room *WaitingRoom) Hold(w http.ResponseWriter, r *http.Request, functionName, namespace string, scale func(context.Context) scaling.FunctionScaleResult) (scaling.FunctionScaleResult, bool) {
+	start := time.Now()
+	key := functionName + "." + namespace
+
+	buffered, err := room.buffer(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Unable to read request body: %s", err), http.StatusBadRequest)
+		return scaling.FunctionScaleResult{}, false
+	}
+
+	room.lock.Lock()
+	cs, joined := room.rooms[key]
+	var ctx context.Context
+	if !joined {
+		cs = &coldStart{done: make(chan struct{})}
+		ctx, cs.cancel = context.WithCancel(context.Background())
+		room.rooms[key] = cs
+	}
+
+	if joined && (cs.requests >= room.config.MaxRequests || cs.bytes+buffered > room.config.MaxBytes) {
+		room.lock.Unlock()
+
+		log.Printf("[Scale] function=%s waiting room is full, requests: %d, bytes: %d", key, cs.requests, cs.bytes)
+		w.Header().Set("Retry-After", strconv.Itoa(coldStartRetryAfter))
+		http.Error(w, fmt.Sprintf("Function %s is not ready yet", key), http.StatusServiceUnavailable)
+		return scaling.FunctionScaleResult{}, false
+	}
+
+	cs.requests++
+	cs.bytes += buffered
+	room.lock.Unlock()
+
+	if !joined {
+		stop := context.AfterFunc(r.Context(), func() {
+			room.leave(key, cs, buffered)
+		})
+		result := scale(ctx)
+		stop()
+
+		room.lock.Lock()
+		if room.rooms[key] == cs {
+			delete(room.rooms, key)
+		}
+		cs.result = result
+		room.lock.Unlock()
+
+		cs.cancel()
+		close(cs.done)
+
+		room.observe(functionName, namespace, start, result)
+		return result, true
+	}
+
+	select {
+	case <-cs.done:
+		room.observe(functionName, namespace, start, cs.result)
+		return cs.result, true
+	case <-r.Context().Done():
+		room.leave(key, cs, buffered)
+
+		room.waitSeconds.WithLabelValues(functionLabel(functionName, namespace), "cancelled").Observe(time.Since(start).Seconds())
+		return scaling.FunctionScaleResult{}, false
+	}
+}
+
+// leave removes a request which has gone away from the waiting room, once
+// no requests are left the scale is cancelled and new requests start over
+func (room *WaitingRoom) leave(key string, cs *coldStart, buffered int64) {
+	room.lock.Lock()
+	defer room.lock.Unlock()
+
+	cs.requests--
+	cs.bytes -= buffered
+	if cs.requests > 0 {
+		return
+	}
+
+	cs.cancel()
+	if room.rooms[key] == cs {
+		delete(room.rooms, key)
+	}
+}
+
+// buffer reads a body of up to MaxBodyBytes into memory and gives its
+// size, a larger body is restored without being counted
+func (room *WaitingRoom) buffer(r *http.Request) (int64, error) {
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength > room.config.MaxBodyBytes {
+		return 0, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, room.config.MaxBodyBytes+1))
+	if err != nil {
+		return 0, err
+	}
+
+	if int64(len(body)) > room.config.MaxBodyBytes {
+		r.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(body), r.Body), Closer: r.Body}
+		return 0, nil
+	}
+
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return int64(len(body)), nil
+}
+
+func (room *WaitingRoom) observe(functionName, namespace string, start time.Time, res scaling.FunctionScaleResult) {
+	result := "ready"
+	if res.Error != nil {
+		result = "error"
+	} else if !res.Available {
+		result = "not_ready"
+	}
+
+	room.waitSeconds.WithLabelValues(functionLabel(functionName, namespace), result).Observe(time.Since(start).Seconds())
+}
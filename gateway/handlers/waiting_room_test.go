@@ -0,0 +1,216 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/scaling"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func newTestWaitingRoom(config WaitingRoomConfig) (*WaitingRoom, *prometheus.HistogramVec) {
+	waitSeconds := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "cold_start_seconds"}, []string{"function_name", "result"})
+	return NewWaitingRoom(config, waitSeconds), waitSeconds
+}
+
+func histogramCount(h *prometheus.HistogramVec, labels ...string) uint64 {
+	m := &dto.Metric{}
+	h.WithLabelValues(labels...).(prometheus.Histogram).Write(m)
+	return m.GetHistogram().GetSampleCount()
+}
+
+// waitForRequests blocks until the waiting room of key holds want requests
+func waitForRequests(t *testing.T, room *WaitingRoom, key string, want int) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		room.lock.Lock()
+		cs, ok := room.rooms[key]
+		held := ok && cs.requests == want
+		room.lock.Unlock()
+
+		if held {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want %d requests held for %s", want, key)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_WaitingRoom_ReleasesTogether(t *testing.T) {
+	room, waitSeconds := newTestWaitingRoom(WaitingRoomConfig{MaxRequests: 3, MaxBytes: 1024, MaxBodyBytes: 64})
+
+	release := make(chan struct{})
+	scales := 0
+	scale := func(ctx context.Context) scaling.FunctionScaleResult {
+		scales++
+		<-release
+		return scaling.FunctionScaleResult{Found: true, Available: true}
+	}
+
+	bodies := make([]string, 3)
+	var wg sync.WaitGroup
+	for i, body := range []string{"first", "second", "third"} {
+		wg.Add(1)
+		go func(i int, body string) {
+			defer wg.Done()
+
+			r := httptest.NewRequest(http.MethodPost, "/function/resize", strings.NewReader(body))
+			res, held := room.Hold(httptest.NewRecorder(), r, "resize", "openfaas-fn", scale)
+			if !held || !res.Available {
+				t.Errorf("want request %d released with the function available, got: %t %+v", i, held, res)
+				return
+			}
+
+			replayed, _ := io.ReadAll(r.Body)
+			bodies[i] = string(replayed)
+		}(i, body)
+
+		waitForRequests(t, room, "resize.openfaas-fn", i+1)
+	}
+
+	rec := httptest.NewRecorder()
+	if _, held := room.Hold(rec, httptest.NewRequest(http.MethodPost, "/function/resize", nil), "resize", "openfaas-fn", scale); held {
+		t.Errorf("want the fourth request rejected")
+	}
+	if rec.Code != http.StatusServiceUnavailable || len(rec.Header().Get("Retry-After")) == 0 {
+		t.Errorf("want a 503 with Retry-After, got: %d %v", rec.Code, rec.Header())
+	}
+
+	close(release)
+	wg.Wait()
+
+	if scales != 1 {
+		t.Errorf("want the function scaled once, got: %d", scales)
+	}
+	if strings.Join(bodies, ",") != "first,second,third" {
+		t.Errorf("want the bodies replayed, got: %v", bodies)
+	}
+	if got := histogramCount(waitSeconds, "resize.openfaas-fn", "ready"); got != 3 {
+		t.Errorf("want 3 cold start waits recorded, got: %d", got)
+	}
+}
+
+func Test_WaitingRoom_ByteBudget(t *testing.T) {
+	room, _ := newTestWaitingRoom(WaitingRoomConfig{MaxRequests: 10, MaxBytes: 10, MaxBodyBytes: 8})
+
+	release := make(chan struct{})
+	scale := func(ctx context.Context) scaling.FunctionScaleResult {
+		<-release
+		return scaling.FunctionScaleResult{Found: true, Available: true}
+	}
+
+	done := make(chan string)
+	go func() {
+		r := httptest.NewRequest(http.MethodPost, "/function/resize", strings.NewReader("12345678"))
+		room.Hold(httptest.NewRecorder(), r, "resize", "openfaas-fn", scale)
+		done <- ""
+	}()
+	waitForRequests(t, room, "resize.openfaas-fn", 1)
+
+	// A body too large to buffer is held without counting towards the budget
+	large := strings.Repeat("x", 100)
+	go func() {
+		r := httptest.NewRequest(http.MethodPost, "/function/resize", strings.NewReader(large))
+		room.Hold(httptest.NewRecorder(), r, "resize", "openfaas-fn", scale)
+		body, _ := io.ReadAll(r.Body)
+		done <- string(body)
+	}()
+	waitForRequests(t, room, "resize.openfaas-fn", 2)
+
+	rec := httptest.NewRecorder()
+	if _, held := room.Hold(rec, httptest.NewRequest(http.MethodPost, "/function/resize", strings.NewReader("12345")), "resize", "openfaas-fn", scale); held {
+		t.Errorf("want a request over the byte budget rejected")
+	}
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status want: %d, got: %d", http.StatusServiceUnavailable, rec.Code)
+	}
+
+	close(release)
+	<-done
+	if body := <-done; body != large {
+		t.Errorf("want the unbuffered body read in full, got %d bytes", len(body))
+	}
+}
+
+func Test_WaitingRoom_CancelsScaleWhenEmpty(t *testing.T) {
+	room, _ := newTestWaitingRoom(WaitingRoomConfig{MaxRequests: 10, MaxBytes: 1024, MaxBodyBytes: 64})
+
+	scale := func(ctx context.Context) scaling.FunctionScaleResult {
+		select {
+		case <-ctx.Done():
+			return scaling.FunctionScaleResult{Found: true, Error: ctx.Err()}
+		case <-time.After(time.Minute):
+			return scaling.FunctionScaleResult{Found: true, Available: true}
+		}
+	}
+
+	leader, cancelLeader := context.WithCancel(context.Background())
+	follower, cancelFollower := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		room.Hold(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/function/resize", nil).WithContext(leader), "resize", "openfaas-fn", scale)
+		close(done)
+	}()
+	waitForRequests(t, room, "resize.openfaas-fn", 1)
+
+	go room.Hold(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/function/resize", nil).WithContext(follower), "resize", "openfaas-fn", scale)
+	waitForRequests(t, room, "resize.openfaas-fn", 2)
+
+	// The scale continues for the follower after the leader goes away
+	cancelLeader()
+	waitForRequests(t, room, "resize.openfaas-fn", 1)
+
+	cancelFollower()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("want the scale cancelled once every request has gone away")
+	}
+}
+
+func Test_MakeScalingHandler_WaitingRoom(t *testing.T) {
+	query := &fakeServiceQuery{readyAfter: 100}
+	config := newTestScalingConfig(query, scaling.ColdStartReject)
+	config.MaxPollCount = 10
+	scaler := scaling.NewFunctionScaler(config, scaling.NewFunctionCache(time.Minute))
+
+	room, waitSeconds := newTestWaitingRoom(WaitingRoomConfig{MaxRequests: 10, MaxBytes: 1024, MaxBodyBytes: 64})
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}
+	handler := MakeScalingHandler(next, scaler, config, "openfaas-fn", fakeFunctionQuery{}, nil, room)
+
+	// Seen scaling from zero without a ready replica
+	if res := scaler.ScaleWithPolls(context.Background(), "resize", "openfaas-fn", 0); res.Available {
+		t.Fatalf("want the function not ready yet")
+	}
+	query.lock.Lock()
+	query.readyAfter = query.queries + 2
+	query.lock.Unlock()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/function/resize", strings.NewReader("hello")))
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+		t.Fatalf("want the held request replayed, got: %d %q", rec.Code, rec.Body.String())
+	}
+	if got := histogramCount(waitSeconds, "resize.openfaas-fn", "ready"); got != 1 {
+		t.Errorf("want one cold start wait recorded, got: %d", got)
+	}
+}
@@ -0,0 +1,294 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/openfaas/faas/gateway/pkg/middleware"
+)
+
+// WebSocketProxy proxies connections which request an upgrade, such as
+// WebSockets, to a function and pipes bytes in both directions once the
+// function has accepted the upgrade.
+type WebSocketProxy struct {
+	BaseURLResolver    middleware.BaseURLResolver
+	URLPathTransformer middleware.URLPathTransformer
+	Notifiers          []WebSocketNotifier
+
+	// DialTimeout is the maximum time to connect and complete the handshake
+	// with the function
+	DialTimeout time.Duration
+
+	// TLSClientConfig is used to connect to functions with an https or wss
+	// URL, nil verifies them against the system's roots
+	TLSClientConfig *tls.Config
+
+	// IdleTimeout closes a connection when no bytes have been sent in
+	// either direction for this long, 0 disables the timeout
+	IdleTimeout time.Duration
+
+	// MaxConnections limits the number of open connections across all
+	// functions, 0 means no limit
+	MaxConnections int64
+
+	open int64
+}
+
+// MakeWebSocketHandler proxies upgrade requests via proxy, all other
+// requests are passed to next.
+func MakeWebSocketHandler(next http.HandlerFunc, proxy *WebSocketProxy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isUpgradeRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		proxy.ServeHTTP(w, r)
+	}
+}
+
+// Open gives the count of open connections
+func (p *WebSocketProxy) Open() int64 {
+	return atomic.LoadInt64(&p.open)
+}
+
+func (p *WebSocketProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	originalURL := r.URL.String()
+
+	if r.Body != nil {
+		defer r.Body.Close()
+	}
+
+	if open := atomic.AddInt64(&p.open, 1); p.MaxConnections > 0 && open > p.MaxConnections {
+		atomic.AddInt64(&p.open, -1)
+
+		p.notify(originalURL, http.StatusServiceUnavailable, "opened", 0)
+		http.Error(w, "Too many open WebSocket connections", http.StatusServiceUnavailable)
+		return
+	}
+	defer atomic.AddInt64(&p.open, -1)
+
+	start := time.Now()
+	statusCode, err := p.proxy(w, r, originalURL)
+	if err != nil {
+		log.Printf("error with upgrade request to: %s, %s\n", originalURL, err.Error())
+	}
+
+	if statusCode == http.StatusSwitchingProtocols {
+		p.notify(originalURL, statusCode, "closed", time.Since(start))
+	}
+}
+
+func (p *WebSocketProxy) proxy(w http.ResponseWriter, r *http.Request, originalURL string) (int, error) {
+	baseURL := p.BaseURLResolver.Resolve(r)
+	requestURL := p.URLPathTransformer.Transform(r)
+
+	upstreamReq := buildUpstreamRequest(r, baseURL, requestURL)
+	upstreamReq.Body = nil
+	upstreamReq.ContentLength = 0
+	upstreamReq.Header.Set("Connection", "Upgrade")
+	upstreamReq.Header.Set("Upgrade", r.Header.Get("Upgrade"))
+
+	if _, exists := os.LookupEnv("write_request_uri"); exists {
+		log.Printf("forwardUpgrade: %s %s\n", upstreamReq.Host, upstreamReq.URL.String())
+	}
+
+	upstreamConn, err := p.dial(upstreamReq.URL)
+	if err != nil {
+		p.notify(originalURL, http.StatusBadGateway, "opened", 0)
+		w.WriteHeader(http.StatusBadGateway)
+		return http.StatusBadGateway, err
+	}
+	defer upstreamConn.Close()
+
+	if p.DialTimeout > 0 {
+		upstreamConn.SetDeadline(time.Now().Add(p.DialTimeout))
+	}
+
+	if err := upstreamReq.Write(upstreamConn); err != nil {
+		p.notify(originalURL, http.StatusBadGateway, "opened", 0)
+		w.WriteHeader(http.StatusBadGateway)
+		return http.StatusBadGateway, err
+	}
+
+	upstreamReader := bufio.NewReader(upstreamConn)
+	res, err := http.ReadResponse(upstreamReader, upstreamReq)
+	if err != nil {
+		p.notify(originalURL, http.StatusBadGateway, "opened", 0)
+		w.WriteHeader(http.StatusBadGateway)
+		return http.StatusBadGateway, err
+	}
+
+	p.notify(originalURL, res.StatusCode, "opened", 0)
+
+	// The function refused the upgrade, so return its response as-is
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		defer res.Body.Close()
+
+		copyHeaders(w.Header(), &res.Header)
+		w.WriteHeader(res.StatusCode)
+		io.Copy(w, res.Body)
+
+		return res.StatusCode, nil
+	}
+
+	clientConn, clientBuf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return res.StatusCode, fmt.Errorf("unable to hijack connection: %s", err)
+	}
+	defer clientConn.Close()
+
+	// Clear any deadlines set by the http.Server or the handshake
+	clientConn.SetDeadline(time.Time{})
+	upstreamConn.SetDeadline(time.Time{})
+
+	if err := writeUpgradeResponse(clientBuf.Writer, res); err != nil {
+		return res.StatusCode, err
+	}
+
+	p.pipe(clientConn, clientBuf.Reader, upstreamConn, upstreamReader)
+
+	return res.StatusCode, nil
+}
+
+// pipe copies bytes in both directions until either side closes, or
+// until the connection has been idle for IdleTimeout.
+func (p *WebSocketProxy) pipe(clientConn net.Conn, clientReader io.Reader, upstreamConn net.Conn, upstreamReader io.Reader) {
+	lastActive := &atomic.Int64{}
+	lastActive.Store(time.Now().UnixNano())
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		p.copy(upstreamConn, clientConn, clientReader, lastActive)
+		upstreamConn.Close()
+	}()
+
+	go func() {
+		defer wg.Done()
+		p.copy(clientConn, upstreamConn, upstreamReader, lastActive)
+		clientConn.Close()
+	}()
+
+	wg.Wait()
+}
+
+func (p *WebSocketProxy) copy(dst net.Conn, src net.Conn, srcReader io.Reader, lastActive *atomic.Int64) {
+	buf := make([]byte, 32*1024)
+	for {
+		if p.IdleTimeout > 0 {
+			src.SetReadDeadline(time.Now().Add(p.IdleTimeout))
+		}
+
+		n, err := srcReader.Read(buf)
+		if n > 0 {
+			lastActive.Store(time.Now().UnixNano())
+			if _, writeErr := dst.Write(buf[:n]); writeErr != nil {
+				return
+			}
+		}
+
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				// The other direction may still be active
+				idle := time.Since(time.Unix(0, lastActive.Load()))
+				if idle < p.IdleTimeout {
+					continue
+				}
+			}
+			return
+		}
+	}
+}
+
+func (p *WebSocketProxy) notify(originalURL string, statusCode int, event string, duration time.Duration) {
+	for _, notifier := range p.Notifiers {
+		notifier.NotifyWebSocket(originalURL, statusCode, event, duration)
+	}
+}
+
+func writeUpgradeResponse(w *bufio.Writer, res *http.Response) error {
+	if _, err := fmt.Fprintf(w, "HTTP/1.1 %s\r\n", res.Status); err != nil {
+		return err
+	}
+	if err := res.Header.Write(w); err != nil {
+		return err
+	}
+	if _, err := w.WriteString(crlf); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// isUpgradeRequest is true when the request asks for a protocol upgrade
+// via the Connection and Upgrade headers.
+func isUpgradeRequest(r *http.Request) bool {
+	if len(r.Header.Get("Upgrade")) == 0 {
+		return false
+	}
+
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// dial connects to the function, with TLS when its URL is https or wss
+func (p *WebSocketProxy) dial(u *url.URL) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: p.DialTimeout}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "ws":
+		return dialer.Dial("tcp", upstreamHost(u))
+	case "https", "wss":
+		config := &tls.Config{}
+		if p.TLSClientConfig != nil {
+			config = p.TLSClientConfig.Clone()
+		}
+		if len(config.ServerName) == 0 {
+			config.ServerName = u.Hostname()
+		}
+		return tls.DialWithDialer(dialer, "tcp", upstreamHost(u), config)
+	}
+
+	return nil, fmt.Errorf("unsupported scheme for an upgrade: %q", u.Scheme)
+}
+
+// upstreamHost gives the host and port to dial, with the default port for
+// the scheme when the URL has none
+func upstreamHost(u *url.URL) string {
+	if len(u.Port()) > 0 {
+		return u.Host
+	}
+
+	port := "80"
+	if scheme := strings.ToLower(u.Scheme); scheme == "https" || scheme == "wss" {
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
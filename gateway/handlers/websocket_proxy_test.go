@@ -0,0 +1,239 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/pkg/middleware"
+)
+
+// echoUpgradeServer accepts an upgrade and echoes back any bytes received
+func echoUpgradeServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(echoUpgradeHandler(t))
+}
+
+func echoUpgradeHandler(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+
+		conn, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("unable to hijack: %s", err)
+			return
+		}
+		defer conn.Close()
+
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		buf.Flush()
+
+		io.Copy(conn, buf)
+	}
+}
+
+func dialUpgrade(t *testing.T, gatewayURL string) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", gatewayURL[len("http://"):])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Fprintf(conn, "GET /function/echo HTTP/1.1\r\nHost: gateway\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn, reader, res
+}
+
+func Test_WebSocketProxy_PipesBytesBothWays(t *testing.T) {
+	upstream := echoUpgradeServer(t)
+	defer upstream.Close()
+
+	proxy := &WebSocketProxy{
+		BaseURLResolver:    middleware.SingleHostBaseURLResolver{BaseURL: upstream.URL},
+		URLPathTransformer: middleware.TransparentURLPathTransformer{},
+		DialTimeout:        time.Second,
+		IdleTimeout:        time.Second,
+	}
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("next should not be called for an upgrade")
+	}
+
+	gateway := httptest.NewServer(MakeWebSocketHandler(next, proxy))
+	defer gateway.Close()
+
+	conn, reader, res := dialUpgrade(t, gateway.URL)
+	defer conn.Close()
+
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status want: %d, got: %d", http.StatusSwitchingProtocols, res.StatusCode)
+	}
+
+	conn.Write([]byte("ping"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(reader, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "ping" {
+		t.Errorf("echo want: %s, got: %s", "ping", string(got))
+	}
+
+	if proxy.Open() != 1 {
+		t.Errorf("open connections want: %d, got: %d", 1, proxy.Open())
+	}
+
+	conn.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for proxy.Open() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if proxy.Open() != 0 {
+		t.Errorf("open connections want: %d, got: %d", 0, proxy.Open())
+	}
+}
+
+func Test_WebSocketProxy_DialsHTTPSWithTLS(t *testing.T) {
+	upstream := httptest.NewTLSServer(echoUpgradeHandler(t))
+	defer upstream.Close()
+
+	proxy := &WebSocketProxy{
+		BaseURLResolver:    middleware.SingleHostBaseURLResolver{BaseURL: upstream.URL},
+		URLPathTransformer: middleware.TransparentURLPathTransformer{},
+		DialTimeout:        time.Second,
+		TLSClientConfig:    upstream.Client().Transport.(*http.Transport).TLSClientConfig,
+	}
+
+	gateway := httptest.NewServer(MakeWebSocketHandler(nil, proxy))
+	defer gateway.Close()
+
+	conn, reader, res := dialUpgrade(t, gateway.URL)
+	defer conn.Close()
+
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status want: %d, got: %d", http.StatusSwitchingProtocols, res.StatusCode)
+	}
+
+	conn.Write([]byte("ping"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(reader, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "ping" {
+		t.Errorf("echo want: %s, got: %s", "ping", string(got))
+	}
+}
+
+func Test_upstreamHost(t *testing.T) {
+	scenarios := map[string]string{
+		"http://echo.openfaas-fn":       "echo.openfaas-fn:80",
+		"https://echo.openfaas-fn":      "echo.openfaas-fn:443",
+		"wss://echo.openfaas-fn":        "echo.openfaas-fn:443",
+		"https://echo.openfaas-fn:8443": "echo.openfaas-fn:8443",
+	}
+
+	for raw, want := range scenarios {
+		u, _ := url.Parse(raw)
+		if got := upstreamHost(u); got != want {
+			t.Errorf("%s want: %s, got: %s", raw, want, got)
+		}
+	}
+}
+
+func Test_WebSocketProxy_RejectsOverMaxConnections(t *testing.T) {
+	upstream := echoUpgradeServer(t)
+	defer upstream.Close()
+
+	proxy := &WebSocketProxy{
+		BaseURLResolver:    middleware.SingleHostBaseURLResolver{BaseURL: upstream.URL},
+		URLPathTransformer: middleware.TransparentURLPathTransformer{},
+		DialTimeout:        time.Second,
+		MaxConnections:     1,
+	}
+
+	gateway := httptest.NewServer(MakeWebSocketHandler(nil, proxy))
+	defer gateway.Close()
+
+	first, _, res := dialUpgrade(t, gateway.URL)
+	defer first.Close()
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status want: %d, got: %d", http.StatusSwitchingProtocols, res.StatusCode)
+	}
+
+	second, _, res := dialUpgrade(t, gateway.URL)
+	defer second.Close()
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("status want: %d, got: %d", http.StatusServiceUnavailable, res.StatusCode)
+	}
+}
+
+func Test_WebSocketProxy_ClosesIdleConnection(t *testing.T) {
+	upstream := echoUpgradeServer(t)
+	defer upstream.Close()
+
+	proxy := &WebSocketProxy{
+		BaseURLResolver:    middleware.SingleHostBaseURLResolver{BaseURL: upstream.URL},
+		URLPathTransformer: middleware.TransparentURLPathTransformer{},
+		DialTimeout:        time.Second,
+		IdleTimeout:        time.Millisecond * 50,
+	}
+
+	gateway := httptest.NewServer(MakeWebSocketHandler(nil, proxy))
+	defer gateway.Close()
+
+	conn, reader, _ := dialUpgrade(t, gateway.URL)
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("want connection to be closed with EOF, got: %v", err)
+	}
+}
+
+func Test_isUpgradeRequest(t *testing.T) {
+	scenarios := []struct {
+		name       string
+		connection string
+		upgrade    string
+		want       bool
+	}{
+		{name: "websocket upgrade", connection: "Upgrade", upgrade: "websocket", want: true},
+		{name: "upgrade in token list", connection: "keep-alive, Upgrade", upgrade: "websocket", want: true},
+		{name: "no upgrade header", connection: "Upgrade", upgrade: "", want: false},
+		{name: "no connection header", connection: "", upgrade: "websocket", want: false},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/function/echo", nil)
+			req.Header.Set("Connection", s.connection)
+			req.Header.Set("Upgrade", s.upgrade)
+
+			if got := isUpgradeRequest(req); got != s.want {
+				t.Errorf("want: %t, got: %t", s.want, got)
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -40,12 +41,13 @@ func main() {
 	}
 
 	fmt.Printf("OpenFaaS Gateway - Community Edition (CE)\n"+
-		"\nVersion: %s Commit: %s\nTimeouts: read=%s\twrite=%s\tupstream=%s\nFunction provider: %s\n\n",
+		"\nVersion: %s Commit: %s\nTimeouts: read=%s\twrite=%s\tupstream=%s\tmax upstream=%s\nFunction provider: %s\n\n",
 		version.BuildVersion(),
 		version.GitCommitSHA,
 		config.ReadTimeout,
 		config.WriteTimeout,
 		config.UpstreamTimeout,
+		config.MaxUpstreamTimeout,
 		config.FunctionsProviderURL)
 
 	// credentials is used for service-to-service auth
@@ -86,6 +88,7 @@ func main() {
 
 	functionNotifiers := []handlers.HTTPNotifier{loggingNotifier, prometheusNotifier}
 	forwardingNotifiers := []handlers.HTTPNotifier{loggingNotifier}
+	queuedNotifiers := []handlers.HTTPNotifier{loggingNotifier}
 	quietNotifier := []handlers.HTTPNotifier{}
 
 	urlResolver := middleware.SingleHostBaseURLResolver{BaseURL: config.FunctionsProviderURL.String()}
@@ -106,26 +109,175 @@ func main() {
 	// externalServiceQuery is used to query metadata from the provider about a function
 	externalServiceQuery := plugin.NewExternalServiceQuery(*config.FunctionsProviderURL, serviceAuthInjector)
 
+	// replicaCeiling caps the replicas of functions, the annotations of
+	// namespaces are re-read after the expiry
+	externalNamespaceQuery := plugin.NewExternalNamespaceQuery(*config.FunctionsProviderURL, serviceAuthInjector)
+	replicaCeiling := scaling.NewReplicaCeiling(config.MaxReplicas, externalNamespaceQuery, time.Second*30)
+
+	// scaleEvents keeps the recent changes of replicas for
+	// /system/scale-events, it is nil when scale_events_size is 0
+	var scaleEvents *scaling.ScaleEventLog
+	if config.ScaleEventsSize > 0 {
+		scaleEvents = scaling.NewScaleEventLog(config.ScaleEventsSize)
+		faasHandlers.ScaleEvents = handlers.MakeScaleEventsHandler(scaleEvents, config.Namespace)
+	}
+
 	scalingConfig := scaling.ScalingConfig{
-		MaxPollCount:         uint(1000),
-		SetScaleRetries:      uint(20),
-		FunctionPollInterval: time.Millisecond * 100,
+		MaxPollCount:         config.ScalePollCount,
+		SetScaleRetries:      config.ScaleSetRetries,
+		FunctionPollInterval: config.ScalePollInterval,
 		CacheExpiry:          time.Millisecond * 250, // freshness of replica values before going stale
 		ServiceQuery:         externalServiceQuery,
+		ColdStartPolicy:      scaling.ColdStartPolicy(config.ColdStartPolicy),
+		ColdStartMaxWait:     config.ColdStartMaxWait,
+		Events:               scaleEvents,
+	}
+
+	webSocketNotifiers := []handlers.WebSocketNotifier{loggingNotifier, prometheusNotifier}
+
+	// replicaScheduler is nil unless scale_schedules is set, the other
+	// scalers then ignore schedules
+	var replicaScheduler *scaling.ReplicaScheduler
+	if config.ScaleSchedules {
+		schedulerConfig := scaling.ReplicaSchedulerConfig{
+			DefaultNamespace: config.Namespace,
+			Ceiling:          replicaCeiling,
+			Events:           scaleEvents,
+		}
+		replicaScheduler = scaling.NewReplicaScheduler(schedulerConfig, externalServiceQuery)
+		exporter.AddServiceListener(replicaScheduler.Update)
+
+		faasHandlers.ReplicaSchedules = scaling.MakeReplicaScheduleHandler(replicaScheduler)
+	}
+
+	// prewarmer is nil unless prewarm is set, functions are then only
+	// scaled from zero when invoked
+	var prewarmer *scaling.Prewarmer
+	if config.Prewarm {
+		prewarmerConfig := scaling.PrewarmerConfig{
+			DefaultNamespace: config.Namespace,
+			Ceiling:          replicaCeiling,
+			Events:           scaleEvents,
+			Threshold:        config.PrewarmThreshold,
+		}
+		prewarmer = scaling.NewPrewarmer(prewarmerConfig, externalServiceQuery)
+		exporter.AddServiceListener(prewarmer.Update)
+
+		prewarmNotifier := handlers.InvocationNotifier{Recorder: prewarmer, FunctionNamespace: config.Namespace}
+		functionNotifiers = append(functionNotifiers, prewarmNotifier)
+
+		faasHandlers.ScalePredictions = scaling.MakePredictionReportHandler(prewarmer)
+	}
+
+	if config.ScaleToZero {
+		idlerConfig := scaling.IdlerConfig{
+			DryRun:           config.ScaleToZeroDryRun,
+			Namespaces:       config.ScaleToZeroNamespaces,
+			DefaultNamespace: config.Namespace,
+			Schedule:         replicaScheduler,
+			Prewarm:          prewarmer,
+			Events:           scaleEvents,
+		}
+		idler := scaling.NewIdler(idlerConfig, externalServiceQuery, metricsOptions.GatewayFunctionScaleToZero)
+		exporter.AddServiceListener(idler.Update)
+
+		idleNotifier := handlers.InvocationNotifier{Recorder: idler, FunctionNamespace: config.Namespace}
+		functionNotifiers = append(functionNotifiers, idleNotifier)
+		webSocketNotifiers = append(webSocketNotifiers, idleNotifier)
+		queuedNotifiers = append(queuedNotifiers, idleNotifier)
+	}
+
+	if config.Autoscaler {
+		autoscalerConfig := scaling.AutoscalerConfig{
+			ScaleUpWindow:    config.AutoscalerScaleUpWindow,
+			ScaleDownWindow:  config.AutoscalerScaleDownWindow,
+			DefaultNamespace: config.Namespace,
+			Ceiling:          replicaCeiling,
+			Schedule:         replicaScheduler,
+			Events:           scaleEvents,
+		}
+		autoscaler := scaling.NewAutoscaler(autoscalerConfig, externalServiceQuery, metricsOptions.GatewayFunctionDesiredReplicas)
+		exporter.AddServiceListener(autoscaler.Update)
+
+		autoscalerNotifier := handlers.InvocationNotifier{Recorder: autoscaler, FunctionNamespace: config.Namespace}
+		functionNotifiers = append(functionNotifiers, autoscalerNotifier)
+		webSocketNotifiers = append(webSocketNotifiers, autoscalerNotifier)
 	}
 
 	// This cache can be used to query a function's annotations.
 	functionAnnotationCache := scaling.NewFunctionCache(scalingConfig.CacheExpiry)
 	cachedFunctionQuery := scaling.NewCachedFunctionQuery(functionAnnotationCache, externalServiceQuery)
 
+	// Functions with the com.openfaas.coldstart.probe annotation are probed
+	// through the provider after scaling from zero, before requests are
+	// released to them
+	scalingConfig.ReadinessProbe = scaling.NewHTTPReadinessProbe(functionURLResolver, cachedFunctionQuery, false, time.Second)
+
+	webSocketProxy := &handlers.WebSocketProxy{
+		BaseURLResolver:    functionURLResolver,
+		URLPathTransformer: functionURLTransformer,
+		Notifiers:          webSocketNotifiers,
+		DialTimeout:        config.UpstreamTimeout,
+		IdleTimeout:        config.WebSocketIdleTimeout,
+		MaxConnections:     int64(config.WebSocketMaxConnections),
+	}
+
+	// domainRouter maps custom domains to functions from config and the
+	// com.openfaas.domain annotation, refreshed by the service watcher
+	domainRouter := handlers.NewDomainRouter(config.DomainSuffix, config.DomainRoutes, config.Namespace)
+	exporter.AddServiceListener(domainRouter.Update)
+
+	// apiRouter maps external paths to functions from api_routes_file and
+	// the com.openfaas.routes annotation, refreshed by the service watcher
+	var apiRoutes []handlers.APIRoute
+	if len(config.APIRoutesFile) > 0 {
+		routesFile, err := os.Open(config.APIRoutesFile)
+		if err != nil {
+			log.Fatalf("Unable to open api_routes_file: %s", err)
+		}
+		apiRoutes, err = handlers.ParseAPIRoutes(routesFile)
+		routesFile.Close()
+		if err != nil {
+			log.Fatalf("Unable to read api_routes_file %s: %s", config.APIRoutesFile, err)
+		}
+	}
+
+	apiRouter, err := handlers.NewAPIRouter(apiRoutes, config.Namespace)
+	if err != nil {
+		log.Fatalf("Invalid api_routes_file %s: %s", config.APIRoutesFile, err)
+	}
+	exporter.AddServiceListener(apiRouter.Update)
+
+	functionReverseProxy := reverseProxy
+	if config.UpstreamRetries > 0 {
+		retryOptions := handlers.RetryOptions{
+			Attempts:    config.UpstreamRetries,
+			Backoff:     types.Backoff{Initial: config.UpstreamRetryBackoff, Max: config.UpstreamRetryMaxBackoff},
+			StatusCodes: config.UpstreamRetryStatusCodes,
+			BudgetRatio: config.UpstreamRetryBudget,
+		}
+		functionReverseProxy = handlers.NewRetryReverseProxy(reverseProxy, retryOptions, config.Namespace, &metricsOptions)
+	}
+
 	faasHandlers.Proxy = handlers.MakeCallIDMiddleware(
-		handlers.MakeForwardingProxyHandler(reverseProxy, functionNotifiers, functionURLResolver, functionURLTransformer, nil),
+		handlers.MakeWebSocketHandler(
+			handlers.MakeFunctionTimeoutHandler(
+				handlers.MakeTrafficMirrorHandler(
+					handlers.MakeForwardingProxyHandler(functionReverseProxy, functionNotifiers, functionURLResolver, functionURLTransformer, nil),
+					reverseProxy, functionURLResolver, functionURLTransformer, cachedFunctionQuery, config.Namespace, &metricsOptions,
+				),
+				cachedFunctionQuery, config.Namespace, config.MaxUpstreamTimeout,
+			),
+			webSocketProxy,
+		),
 	)
 
 	faasHandlers.ListFunctions = handlers.MakeForwardingProxyHandler(reverseProxy, forwardingNotifiers, urlResolver, nilURLTransformer, serviceAuthInjector)
-	faasHandlers.DeployFunction = handlers.MakeForwardingProxyHandler(reverseProxy, forwardingNotifiers, urlResolver, nilURLTransformer, serviceAuthInjector)
+	faasHandlers.DeployFunction = handlers.MakeAnnotationClaimHandler(
+		handlers.MakeForwardingProxyHandler(reverseProxy, forwardingNotifiers, urlResolver, nilURLTransformer, serviceAuthInjector), domainRouter, apiRouter)
 	faasHandlers.DeleteFunction = handlers.MakeForwardingProxyHandler(reverseProxy, forwardingNotifiers, urlResolver, nilURLTransformer, serviceAuthInjector)
-	faasHandlers.UpdateFunction = handlers.MakeForwardingProxyHandler(reverseProxy, forwardingNotifiers, urlResolver, nilURLTransformer, serviceAuthInjector)
+	faasHandlers.UpdateFunction = handlers.MakeAnnotationClaimHandler(
+		handlers.MakeForwardingProxyHandler(reverseProxy, forwardingNotifiers, urlResolver, nilURLTransformer, serviceAuthInjector), domainRouter, apiRouter)
 	faasHandlers.FunctionStatus = handlers.MakeForwardingProxyHandler(reverseProxy, forwardingNotifiers, urlResolver, nilURLTransformer, serviceAuthInjector)
 
 	faasHandlers.InfoHandler = handlers.MakeInfoHandler(handlers.MakeForwardingProxyHandler(reverseProxy, forwardingNotifiers, urlResolver, nilURLTransformer, serviceAuthInjector))
@@ -136,21 +288,22 @@ func main() {
 	faasHandlers.NamespaceListerHandler = handlers.MakeForwardingProxyHandler(reverseProxy, forwardingNotifiers, urlResolver, nilURLTransformer, serviceAuthInjector)
 	faasHandlers.NamespaceMutatorHandler = handlers.MakeForwardingProxyHandler(reverseProxy, forwardingNotifiers, urlResolver, nilURLTransformer, serviceAuthInjector)
 
+	alertScalingConfig := handlers.AlertScalingConfig{
+		DefaultNamespace:  config.Namespace,
+		Ceiling:           replicaCeiling,
+		ScaleDownStep:     config.AlertScaleDownStep,
+		ScaleDownCooldown: config.AlertScaleDownCooldown,
+		Schedule:          replicaScheduler,
+		Events:            scaleEvents,
+	}
+
 	faasHandlers.Alert = handlers.MakeNotifierWrapper(
-		handlers.MakeAlertHandler(externalServiceQuery, config.Namespace),
+		handlers.MakeAlertHandler(handlers.NewAlertScaler(alertScalingConfig, externalServiceQuery)),
 		quietNotifier,
 	)
 
 	faasHandlers.LogProxyHandler = handlers.NewLogHandlerFunc(*config.LogsProviderURL, config.WriteTimeout)
 
-	functionProxy := faasHandlers.Proxy
-
-	if config.ScaleFromZero {
-		scalingFunctionCache := scaling.NewFunctionCache(scalingConfig.CacheExpiry)
-		scaler := scaling.NewFunctionScaler(scalingConfig, scalingFunctionCache)
-		functionProxy = handlers.MakeScalingHandler(functionProxy, scaler, scalingConfig, config.Namespace)
-	}
-
 	if config.UseNATS() {
 		log.Println("Async enabled: Using NATS Streaming")
 		log.Println("Deprecation Notice: NATS Streaming is no longer maintained and won't receive updates from June 2023")
@@ -167,15 +320,108 @@ func main() {
 
 		faasHandlers.QueuedProxy = handlers.MakeNotifierWrapper(
 			handlers.MakeCallIDMiddleware(handlers.MakeQueuedProxy(metricsOptions, natsQueue, trimURLTransformer, config.Namespace, cachedFunctionQuery)),
-			forwardingNotifiers,
+			queuedNotifiers,
 		)
 	}
 
+	functionProxy := faasHandlers.Proxy
+	functionProxy = handlers.MakeCircuitBreakerHandler(functionProxy, handlers.NewCircuitBreakers(), cachedFunctionQuery, config.Namespace, &metricsOptions)
+
+	if config.ScaleFromZero {
+		scalingFunctionCache := scaling.NewFunctionCache(scalingConfig.CacheExpiry)
+		scaler := scaling.NewFunctionScaler(scalingConfig, scalingFunctionCache)
+
+		var waitingRoom *handlers.WaitingRoom
+		if config.ColdStartMaxRequests > 0 {
+			waitingRoomConfig := handlers.WaitingRoomConfig{
+				MaxRequests:  config.ColdStartMaxRequests,
+				MaxBytes:     config.ColdStartMaxBytes,
+				MaxBodyBytes: config.ColdStartMaxBodyBytes,
+			}
+			waitingRoom = handlers.NewWaitingRoom(waitingRoomConfig, metricsOptions.GatewayFunctionColdStartHistogram)
+		}
+
+		if scalingConfig.ColdStartPolicy == scaling.ColdStartQueue && faasHandlers.QueuedProxy == nil {
+			log.Println("cold_start_policy=queue needs NATS to be configured, requests for functions which are not ready will be rejected")
+		}
+
+		functionProxy = handlers.MakeScalingHandler(functionProxy, scaler, scalingConfig, config.Namespace, cachedFunctionQuery, faasHandlers.QueuedProxy, waitingRoom)
+	}
+
+	functionProxy = handlers.MakeConcurrencyLimitHandler(functionProxy, handlers.NewConcurrencyLimiter(), cachedFunctionQuery, config.Namespace, &metricsOptions)
+	functionProxy = handlers.MakeRateLimitHandler(functionProxy, handlers.NewRateLimiter(), cachedFunctionQuery, config.Namespace, &metricsOptions)
+
+	// The response cache sees the version chosen by the traffic split, so
+	// that versions do not share cached responses
+	if config.ResponseCacheMaxBytes > 0 {
+		responseCache := handlers.NewResponseCache(config.ResponseCacheMaxBytes, metricsOptions.GatewayResponseCacheBytes)
+		functionProxy = handlers.MakeResponseCacheHandler(functionProxy, responseCache, cachedFunctionQuery, config.Namespace, &metricsOptions)
+		faasHandlers.CachePurge = handlers.MakeCachePurgeHandler(responseCache, config.Namespace)
+	}
+
+	functionProxy = handlers.MakeTrafficSplitHandler(functionProxy, cachedFunctionQuery, config.Namespace)
+	functionProxy = handlers.MakeRequestCoalescingHandler(functionProxy, cachedFunctionQuery, config.Namespace, &metricsOptions)
+
 	prometheusQuery := metrics.NewPrometheusQuery(config.PrometheusHost, config.PrometheusPort, http.DefaultClient, version.BuildVersion())
 	faasHandlers.ListFunctions = metrics.AddMetricsHandler(faasHandlers.ListFunctions, prometheusQuery)
-	faasHandlers.ScaleFunction = scaling.MakeHorizontalScalingHandler(handlers.MakeForwardingProxyHandler(reverseProxy, forwardingNotifiers, urlResolver, nilURLTransformer, serviceAuthInjector))
+	faasHandlers.ScaleFunction = scaling.MakeHorizontalScalingHandler(
+		handlers.MakeForwardingProxyHandler(reverseProxy, forwardingNotifiers, urlResolver, nilURLTransformer, serviceAuthInjector),
+		cachedFunctionQuery, replicaCeiling, scaleEvents, config.Namespace,
+	)
 
-	if credentials != nil {
+	if config.CompressResponses {
+		compressionOptions := handlers.CompressionOptions{
+			MinSize:      config.CompressMinSize,
+			ContentTypes: config.CompressContentTypes,
+		}
+
+		functionProxy = handlers.MakeCompressionHandler(functionProxy, compressionOptions)
+		faasHandlers.ListFunctions = handlers.MakeCompressionHandler(faasHandlers.ListFunctions, compressionOptions)
+		faasHandlers.InfoHandler = handlers.MakeCompressionHandler(faasHandlers.InfoHandler, compressionOptions)
+	}
+
+	if len(config.AuthProxyURL) > 0 {
+		log.Printf("Using external auth proxy: %s\n", config.AuthProxyURL)
+
+		authDecisions := handlers.NewAuthDecisionCache(config.AuthProxyCacheExpiry)
+		decorateExternalAuth := func(next http.HandlerFunc) http.HandlerFunc {
+			return handlers.MakeExternalAuthHandler(next, config.UpstreamTimeout, config.AuthProxyURL, config.AuthProxyPassBody, authDecisions)
+		}
+
+		faasHandlers.Alert = decorateExternalAuth(faasHandlers.Alert)
+		faasHandlers.UpdateFunction = decorateExternalAuth(faasHandlers.UpdateFunction)
+		faasHandlers.DeleteFunction = decorateExternalAuth(faasHandlers.DeleteFunction)
+		faasHandlers.DeployFunction = decorateExternalAuth(faasHandlers.DeployFunction)
+		faasHandlers.ListFunctions = decorateExternalAuth(faasHandlers.ListFunctions)
+		faasHandlers.ScaleFunction = decorateExternalAuth(faasHandlers.ScaleFunction)
+		faasHandlers.FunctionStatus = decorateExternalAuth(faasHandlers.FunctionStatus)
+		faasHandlers.InfoHandler = decorateExternalAuth(faasHandlers.InfoHandler)
+		faasHandlers.SecretHandler = decorateExternalAuth(faasHandlers.SecretHandler)
+		faasHandlers.LogProxyHandler = decorateExternalAuth(faasHandlers.LogProxyHandler)
+		faasHandlers.NamespaceListerHandler = decorateExternalAuth(faasHandlers.NamespaceListerHandler)
+		faasHandlers.NamespaceMutatorHandler = decorateExternalAuth(faasHandlers.NamespaceMutatorHandler)
+		faasHandlers.TelemetryHandler = decorateExternalAuth(faasHandlers.TelemetryHandler)
+		if faasHandlers.CachePurge != nil {
+			faasHandlers.CachePurge = decorateExternalAuth(faasHandlers.CachePurge)
+		}
+		if faasHandlers.ReplicaSchedules != nil {
+			faasHandlers.ReplicaSchedules = decorateExternalAuth(faasHandlers.ReplicaSchedules)
+		}
+		if faasHandlers.ScaleEvents != nil {
+			faasHandlers.ScaleEvents = decorateExternalAuth(faasHandlers.ScaleEvents)
+		}
+
+		if faasHandlers.ScalePredictions != nil {
+			faasHandlers.ScalePredictions = decorateExternalAuth(faasHandlers.ScalePredictions)
+		}
+
+		if config.AuthProxyFunctions {
+			functionProxy = decorateExternalAuth(functionProxy)
+			if faasHandlers.QueuedProxy != nil {
+				faasHandlers.QueuedProxy = decorateExternalAuth(faasHandlers.QueuedProxy)
+			}
+		}
+	} else if credentials != nil {
 		faasHandlers.Alert =
 			auth.DecorateWithBasicAuth(faasHandlers.Alert, credentials)
 		faasHandlers.UpdateFunction =
@@ -202,6 +448,23 @@ func main() {
 			auth.DecorateWithBasicAuth(faasHandlers.NamespaceMutatorHandler, credentials)
 		faasHandlers.TelemetryHandler =
 			auth.DecorateWithBasicAuth(faasHandlers.TelemetryHandler, credentials)
+		if faasHandlers.CachePurge != nil {
+			faasHandlers.CachePurge =
+				auth.DecorateWithBasicAuth(faasHandlers.CachePurge, credentials)
+		}
+		if faasHandlers.ReplicaSchedules != nil {
+			faasHandlers.ReplicaSchedules =
+				auth.DecorateWithBasicAuth(faasHandlers.ReplicaSchedules, credentials)
+		}
+		if faasHandlers.ScaleEvents != nil {
+			faasHandlers.ScaleEvents =
+				auth.DecorateWithBasicAuth(faasHandlers.ScaleEvents, credentials)
+		}
+
+		if faasHandlers.ScalePredictions != nil {
+			faasHandlers.ScalePredictions =
+				auth.DecorateWithBasicAuth(faasHandlers.ScalePredictions, credentials)
+		}
 	}
 
 	r := mux.NewRouter()
@@ -230,6 +493,22 @@ func main() {
 	r.HandleFunc("/system/namespace/{namespace:["+NameExpression+"]*}", faasHandlers.NamespaceMutatorHandler).
 		Methods(http.MethodPost, http.MethodDelete, http.MethodPut, http.MethodGet)
 
+	if faasHandlers.CachePurge != nil {
+		r.HandleFunc("/system/cache", faasHandlers.CachePurge).Methods(http.MethodDelete)
+	}
+
+	if faasHandlers.ReplicaSchedules != nil {
+		r.HandleFunc("/system/scale-schedules", faasHandlers.ReplicaSchedules).Methods(http.MethodGet)
+	}
+
+	if faasHandlers.ScaleEvents != nil {
+		r.HandleFunc("/system/scale-events", faasHandlers.ScaleEvents).Methods(http.MethodGet)
+	}
+
+	if faasHandlers.ScalePredictions != nil {
+		r.HandleFunc("/system/scale-predictions", faasHandlers.ScalePredictions).Methods(http.MethodGet)
+	}
+
 	if faasHandlers.QueuedProxy != nil {
 		r.HandleFunc("/async-function/{name:["+NameExpression+"]+}/", faasHandlers.QueuedProxy).Methods(http.MethodPost)
 		r.HandleFunc("/async-function/{name:["+NameExpression+"]+}", faasHandlers.QueuedProxy).Methods(http.MethodPost)
@@ -258,8 +537,18 @@ func main() {
 	r.HandleFunc("/healthz",
 		handlers.MakeForwardingProxyHandler(reverseProxy, forwardingNotifiers, urlResolver, nilURLTransformer, serviceAuthInjector)).Methods(http.MethodGet)
 
+	r.MatcherFunc(apiRouter.Match).HandlerFunc(handlers.MakeAPIRouteHandler(apiRouter, functionProxy))
+
 	r.Handle("/", http.RedirectHandler("/ui/", http.StatusTemporaryRedirect)).Methods(http.MethodGet)
 
+	mainHandler := http.HandlerFunc(r.ServeHTTP)
+	domainProxy := functionProxy
+	if config.AdaptiveConcurrency {
+		limiter := handlers.NewAdaptiveLimiter(config.AdaptiveConcurrencyInitial, config.AdaptiveConcurrencyMin, config.AdaptiveConcurrencyMax, &metricsOptions)
+		mainHandler = handlers.MakeLoadSheddingHandler(mainHandler, limiter, &metricsOptions)
+		domainProxy = handlers.MakeLoadSheddingHandler(domainProxy, limiter, &metricsOptions)
+	}
+
 	tcpPort := 8080
 
 	s := &http.Server{
@@ -267,7 +556,7 @@ func main() {
 		ReadTimeout:    config.ReadTimeout,
 		WriteTimeout:   config.WriteTimeout,
 		MaxHeaderBytes: http.DefaultMaxHeaderBytes, // 1MB - can be overridden by setting Server.MaxHeaderBytes.
-		Handler:        r,
+		Handler:        handlers.MakeDomainRoutingHandler(mainHandler, domainRouter, domainProxy),
 	}
 
 	log.Fatal(s.ListenAndServe())
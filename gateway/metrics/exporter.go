@@ -14,6 +14,7 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"sync"
 	"time"
 
 	"log"
@@ -29,6 +30,9 @@ type Exporter struct {
 	services          []types.FunctionStatus
 	credentials       *auth.BasicAuthCredentials
 	FunctionNamespace string
+
+	listeners []func([]types.FunctionStatus)
+	lock      sync.Mutex
 }
 
 // NewExporter creates a new exporter for the OpenFaaS gateway metrics
@@ -48,6 +52,25 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	e.metricOptions.GatewayFunctionsHistogram.Describe(ch)
 	e.metricOptions.ServiceReplicasGauge.Describe(ch)
 	e.metricOptions.GatewayFunctionInvocationStarted.Describe(ch)
+	e.metricOptions.GatewayFunctionRateLimited.Describe(ch)
+	e.metricOptions.GatewayFunctionQueueDepth.Describe(ch)
+	e.metricOptions.GatewayFunctionQueueWaitHistogram.Describe(ch)
+	e.metricOptions.GatewayFunctionMirrorTotal.Describe(ch)
+	e.metricOptions.GatewayFunctionMirrorLatencyDifference.Describe(ch)
+	e.metricOptions.GatewayFunctionCacheTotal.Describe(ch)
+	e.metricOptions.GatewayFunctionCoalescedTotal.Describe(ch)
+	e.metricOptions.GatewayFunctionRetriesTotal.Describe(ch)
+	e.metricOptions.GatewayFunctionRetryBudgetExhausted.Describe(ch)
+	e.metricOptions.GatewayFunctionCircuitBreakerState.Describe(ch)
+	e.metricOptions.GatewayFunctionScaleToZero.Describe(ch)
+	e.metricOptions.GatewayFunctionDesiredReplicas.Describe(ch)
+	e.metricOptions.GatewayFunctionColdStartHistogram.Describe(ch)
+	e.metricOptions.GatewayConcurrencyLimit.Describe(ch)
+	e.metricOptions.GatewayInflightRequests.Describe(ch)
+	e.metricOptions.GatewayRequestsShed.Describe(ch)
+	e.metricOptions.GatewayResponseCacheBytes.Describe(ch)
+	e.metricOptions.GatewayWebSocketConnectionsOpen.Describe(ch)
+	e.metricOptions.GatewayWebSocketConnections.Describe(ch)
 }
 
 // Collect collects data to be consumed by prometheus
@@ -56,6 +79,22 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 	e.metricOptions.GatewayFunctionsHistogram.Collect(ch)
 
 	e.metricOptions.GatewayFunctionInvocationStarted.Collect(ch)
+	e.metricOptions.GatewayFunctionRateLimited.Collect(ch)
+	e.metricOptions.GatewayFunctionQueueDepth.Collect(ch)
+	e.metricOptions.GatewayFunctionQueueWaitHistogram.Collect(ch)
+	e.metricOptions.GatewayFunctionMirrorTotal.Collect(ch)
+	e.metricOptions.GatewayFunctionMirrorLatencyDifference.Collect(ch)
+	e.metricOptions.GatewayFunctionCacheTotal.Collect(ch)
+	e.metricOptions.GatewayFunctionCoalescedTotal.Collect(ch)
+	e.metricOptions.GatewayFunctionRetriesTotal.Collect(ch)
+	e.metricOptions.GatewayFunctionRetryBudgetExhausted.Collect(ch)
+	e.metricOptions.GatewayFunctionCircuitBreakerState.Collect(ch)
+	e.metricOptions.GatewayFunctionScaleToZero.Collect(ch)
+	e.metricOptions.GatewayFunctionDesiredReplicas.Collect(ch)
+	e.metricOptions.GatewayFunctionColdStartHistogram.Collect(ch)
+	e.metricOptions.GatewayRequestsShed.Collect(ch)
+	e.metricOptions.GatewayWebSocketConnectionsOpen.Collect(ch)
+	e.metricOptions.GatewayWebSocketConnections.Collect(ch)
 
 	e.metricOptions.ServiceReplicasGauge.Reset()
 
@@ -74,6 +113,18 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 	}
 
 	e.metricOptions.ServiceReplicasGauge.Collect(ch)
+	e.metricOptions.GatewayResponseCacheBytes.Collect(ch)
+	e.metricOptions.GatewayConcurrencyLimit.Collect(ch)
+	e.metricOptions.GatewayInflightRequests.Collect(ch)
+}
+
+// AddServiceListener calls listener with the list of functions each time
+// the service watcher refreshes it
+func (e *Exporter) AddServiceListener(listener func([]types.FunctionStatus)) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.listeners = append(e.listeners, listener)
 }
 
 // StartServiceWatcher starts a ticker and collects service replica counts to expose to prometheus
@@ -86,33 +137,26 @@ func (e *Exporter) StartServiceWatcher(endpointURL url.URL, metricsOptions Metri
 			select {
 			case <-ticker.C:
 
-				namespaces, err := e.getNamespaces(endpointURL)
-				if err != nil {
-					log.Printf("Error listing namespaces: %s", err)
+				services, complete := e.listServices(endpointURL)
+				if services == nil {
+					continue
 				}
 
-				services := []types.FunctionStatus{}
-
-				// Providers like faasd for instance have no namespaces.
-				if len(namespaces) == 0 {
-					services, err = e.getFunctions(endpointURL, e.FunctionNamespace)
-					if err != nil {
-						log.Printf("Error getting functions from: %s, error: %s", e.FunctionNamespace, err)
-						continue
-					}
-					e.services = services
-				} else {
-					for _, namespace := range namespaces {
-						nsServices, err := e.getFunctions(endpointURL, namespace)
-						if err != nil {
-							log.Printf("Error getting functions from: %s, error: %s", e.FunctionNamespace, err)
-							continue
-						}
-						services = append(services, nsServices...)
-					}
+				e.services = services
+
+				// Listeners act on functions missing from the list, so they
+				// are only given a complete list
+				if !complete {
+					continue
 				}
 
-				e.services = services
+				e.lock.Lock()
+				listeners := e.listeners
+				e.lock.Unlock()
+
+				for _, listener := range listeners {
+					listener(services)
+				}
 
 			case <-quit:
 				return
@@ -121,6 +165,42 @@ func (e *Exporter) StartServiceWatcher(endpointURL url.URL, metricsOptions Metri
 	}()
 }
 
+// listServices lists the functions in every namespace, complete is false
+// when the namespaces or the functions of a namespace could not be listed.
+// The services are nil when nothing could be listed.
+func (e *Exporter) listServices(endpointURL url.URL) ([]types.FunctionStatus, bool) {
+	complete := true
+
+	namespaces, err := e.getNamespaces(endpointURL)
+	if err != nil {
+		log.Printf("Error listing namespaces: %s", err)
+		complete = false
+	}
+
+	// Providers like faasd for instance have no namespaces.
+	if len(namespaces) == 0 {
+		services, err := e.getFunctions(endpointURL, e.FunctionNamespace)
+		if err != nil {
+			log.Printf("Error getting functions from: %s, error: %s", e.FunctionNamespace, err)
+			return nil, false
+		}
+		return services, complete
+	}
+
+	services := []types.FunctionStatus{}
+	for _, namespace := range namespaces {
+		nsServices, err := e.getFunctions(endpointURL, namespace)
+		if err != nil {
+			log.Printf("Error getting functions from: %s, error: %s", namespace, err)
+			complete = false
+			continue
+		}
+		services = append(services, nsServices...)
+	}
+
+	return services, complete
+}
+
 func (e *Exporter) getHTTPClient(timeout time.Duration) http.Client {
 
 	return http.Client{
@@ -1,6 +1,10 @@
 package metrics
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 
 	types "github.com/openfaas/faas-provider/types"
@@ -90,3 +94,48 @@ func Test_Collect_CollectsTheNumberOfReplicasOfAService(t *testing.T) {
 	ch = nil
 
 }
+
+func Test_listServices_PartialListing(t *testing.T) {
+	scenarios := []struct {
+		name          string
+		namespaces    int
+		failNamespace string
+		wantServices  int
+		wantComplete  bool
+	}{
+		{name: "all namespaces listed", namespaces: http.StatusOK, wantServices: 2, wantComplete: true},
+		{name: "no namespaces endpoint", namespaces: http.StatusNotFound, wantServices: 1, wantComplete: true},
+		{name: "namespaces fail to list", namespaces: http.StatusInternalServerError, wantServices: 1, wantComplete: false},
+		{name: "one namespace fails to list", namespaces: http.StatusOK, failNamespace: "staging", wantServices: 1, wantComplete: false},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/system/namespaces" {
+					w.WriteHeader(s.namespaces)
+					if s.namespaces == http.StatusOK {
+						w.Write([]byte(`["openfaas-fn","staging"]`))
+					}
+					return
+				}
+
+				namespace := r.URL.Query().Get("namespace")
+				if namespace == s.failNamespace {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				json.NewEncoder(w).Encode([]types.FunctionStatus{{Name: "figlet", Namespace: namespace}})
+			}))
+			defer provider.Close()
+
+			endpointURL, _ := url.Parse(provider.URL)
+			exporter := NewExporter(BuildMetricsOptions(), nil, "openfaas-fn")
+
+			services, complete := exporter.listServices(*endpointURL)
+			if len(services) != s.wantServices || complete != s.wantComplete {
+				t.Errorf("want %d services complete: %t, got: %d %t", s.wantServices, s.wantComplete, len(services), complete)
+			}
+		})
+	}
+}
@@ -19,6 +19,70 @@ type MetricOptions struct {
 	GatewayFunctionsHistogram        *prometheus.HistogramVec
 	GatewayFunctionInvocationStarted *prometheus.CounterVec
 
+	// GatewayFunctionRateLimited counts invocations rejected by a rate limit
+	GatewayFunctionRateLimited *prometheus.CounterVec
+
+	// GatewayFunctionQueueDepth is the number of invocations waiting for a
+	// concurrency slot
+	GatewayFunctionQueueDepth *prometheus.GaugeVec
+	// GatewayFunctionQueueWaitHistogram is the time spent waiting for a
+	// concurrency slot
+	GatewayFunctionQueueWaitHistogram *prometheus.HistogramVec
+
+	// GatewayFunctionMirrorTotal counts mirrored invocations by the shadow
+	// function's status code and whether it matched the primary's
+	GatewayFunctionMirrorTotal *prometheus.CounterVec
+	// GatewayFunctionMirrorLatencyDifference is the shadow function's latency
+	// minus the primary's, negative when the shadow was faster
+	GatewayFunctionMirrorLatencyDifference *prometheus.HistogramVec
+
+	// GatewayFunctionCacheTotal counts cached GET requests by result, hit or miss
+	GatewayFunctionCacheTotal *prometheus.CounterVec
+	// GatewayResponseCacheBytes is the size of the responses in the cache
+	GatewayResponseCacheBytes prometheus.Gauge
+
+	// GatewayFunctionCoalescedTotal counts requests which shared the
+	// response of an identical in-flight request
+	GatewayFunctionCoalescedTotal *prometheus.CounterVec
+
+	// GatewayFunctionRetriesTotal counts upstream retries by the reason
+	// for the retry
+	GatewayFunctionRetriesTotal *prometheus.CounterVec
+	// GatewayFunctionRetryBudgetExhausted counts failed upstream calls
+	// which were not retried because the retry budget was spent
+	GatewayFunctionRetryBudgetExhausted *prometheus.CounterVec
+
+	// GatewayFunctionCircuitBreakerState is the state of a function's circuit
+	// breaker, 0 for closed, 1 for half-open and 2 for open
+	GatewayFunctionCircuitBreakerState *prometheus.GaugeVec
+
+	// GatewayFunctionScaleToZero counts idle functions scaled to zero by
+	// the gateway, with a result of scaled, dry_run or failed
+	GatewayFunctionScaleToZero *prometheus.CounterVec
+
+	// GatewayFunctionDesiredReplicas is the replica count computed for a
+	// function by the autoscaler
+	GatewayFunctionDesiredReplicas *prometheus.GaugeVec
+
+	// GatewayFunctionColdStartHistogram is the time requests waited in the
+	// waiting room for a function to scale from zero, by result
+	GatewayFunctionColdStartHistogram *prometheus.HistogramVec
+
+	// GatewayConcurrencyLimit is the current adaptive limit of requests
+	// in-flight in the gateway
+	GatewayConcurrencyLimit prometheus.Gauge
+	// GatewayInflightRequests is the number of requests in-flight counted
+	// against the adaptive limit
+	GatewayInflightRequests prometheus.Gauge
+	// GatewayRequestsShed counts requests rejected by the adaptive limit
+	// by priority
+	GatewayRequestsShed *prometheus.CounterVec
+
+	// GatewayWebSocketConnectionsOpen tracks upgraded connections which are open
+	GatewayWebSocketConnectionsOpen *prometheus.GaugeVec
+	// GatewayWebSocketConnections counts upgrade attempts by handshake status code
+	GatewayWebSocketConnections *prometheus.CounterVec
+
 	ServiceReplicasGauge *prometheus.GaugeVec
 }
 
@@ -79,11 +143,216 @@ func BuildMetricsOptions() MetricOptions {
 		[]string{"function_name"},
 	)
 
+	gatewayFunctionRateLimited := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "gateway",
+			Subsystem: "function",
+			Name:      "rate_limited_total",
+			Help:      "The total number of function HTTP requests rejected by a rate limit.",
+		},
+		[]string{"function_name"},
+	)
+
+	gatewayFunctionQueueDepth := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "gateway",
+			Subsystem: "function",
+			Name:      "queue_depth",
+			Help:      "Current count of function HTTP requests waiting for a concurrency slot.",
+		},
+		[]string{"function_name"},
+	)
+
+	gatewayFunctionQueueWaitHistogram := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "gateway",
+			Subsystem: "function",
+			Name:      "queue_wait_seconds",
+			Help:      "Time function HTTP requests waited for a concurrency slot.",
+		},
+		[]string{"function_name"},
+	)
+
+	gatewayFunctionMirrorTotal := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "gateway",
+			Subsystem: "function",
+			Name:      "mirror_total",
+			Help:      "The total number of function HTTP requests mirrored to a shadow function.",
+		},
+		[]string{"function_name", "mirror_name", "code", "status_match"},
+	)
+
+	gatewayFunctionMirrorLatencyDifference := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "gateway",
+			Subsystem: "function",
+			Name:      "mirror_latency_difference_seconds",
+			Help:      "Latency of the shadow function minus the latency of the primary function.",
+			Buckets:   []float64{-5, -1, -0.5, -0.1, -0.05, -0.01, 0, 0.01, 0.05, 0.1, 0.5, 1, 5},
+		},
+		[]string{"function_name", "mirror_name"},
+	)
+
+	gatewayFunctionCacheTotal := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "gateway",
+			Subsystem: "function",
+			Name:      "cache_total",
+			Help:      "The total number of function HTTP requests looked up in the response cache by result.",
+		},
+		[]string{"function_name", "result"},
+	)
+
+	gatewayResponseCacheBytes := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "gateway",
+			Subsystem: "response_cache",
+			Name:      "bytes",
+			Help:      "Current size of the responses held in the response cache.",
+		},
+	)
+
+	gatewayFunctionCoalescedTotal := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "gateway",
+			Subsystem: "function",
+			Name:      "coalesced_total",
+			Help:      "The total number of function HTTP requests served by an identical in-flight request.",
+		},
+		[]string{"function_name"},
+	)
+
+	gatewayFunctionRetriesTotal := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "gateway",
+			Subsystem: "function",
+			Name:      "retries_total",
+			Help:      "The total number of function HTTP requests retried against the upstream by reason.",
+		},
+		[]string{"function_name", "reason"},
+	)
+
+	gatewayFunctionRetryBudgetExhausted := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "gateway",
+			Subsystem: "function",
+			Name:      "retry_budget_exhausted_total",
+			Help:      "The total number of failed function HTTP requests not retried because the retry budget was spent.",
+		},
+		[]string{"function_name"},
+	)
+
+	gatewayFunctionCircuitBreakerState := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "gateway",
+			Subsystem: "function",
+			Name:      "circuit_breaker_state",
+			Help:      "State of the function's circuit breaker: 0 closed, 1 half-open, 2 open.",
+		},
+		[]string{"function_name"},
+	)
+
+	gatewayFunctionScaleToZero := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "gateway",
+			Subsystem: "function",
+			Name:      "scale_to_zero_total",
+			Help:      "The total number of decisions to scale an idle function to zero replicas by result.",
+		},
+		[]string{"function_name", "result"},
+	)
+
+	gatewayFunctionDesiredReplicas := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "gateway",
+			Subsystem: "function",
+			Name:      "desired_replicas",
+			Help:      "Replica count computed for the function by the autoscaler.",
+		},
+		[]string{"function_name"},
+	)
+
+	gatewayConcurrencyLimit := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "gateway",
+			Name:      "concurrency_limit",
+			Help:      "Current adaptive limit of requests in-flight in the gateway.",
+		},
+	)
+
+	gatewayFunctionColdStartHistogram := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "gateway",
+			Subsystem: "function",
+			Name:      "cold_start_seconds",
+			Help:      "Time function HTTP requests waited for the function to scale from zero.",
+			Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		},
+		[]string{"function_name", "result"},
+	)
+
+	gatewayInflightRequests := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "gateway",
+			Name:      "inflight_requests",
+			Help:      "Current count of requests in-flight in the gateway.",
+		},
+	)
+
+	gatewayRequestsShed := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "gateway",
+			Name:      "requests_shed_total",
+			Help:      "The total number of requests rejected by the adaptive concurrency limit by priority.",
+		},
+		[]string{"priority"},
+	)
+
+	gatewayWebSocketConnectionsOpen := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "gateway",
+			Subsystem: "websocket",
+			Name:      "connections_open",
+			Help:      "Current count of open WebSocket connections to functions",
+		},
+		[]string{"function_name"},
+	)
+
+	gatewayWebSocketConnections := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "gateway",
+			Subsystem: "websocket",
+			Name:      "connections_total",
+			Help:      "The total number of WebSocket upgrade requests to functions",
+		},
+		[]string{"function_name", "code"},
+	)
+
 	metricsOptions := MetricOptions{
-		GatewayFunctionsHistogram:        gatewayFunctionsHistogram,
-		GatewayFunctionInvocation:        gatewayFunctionInvocation,
-		ServiceReplicasGauge:             serviceReplicas,
-		GatewayFunctionInvocationStarted: gatewayFunctionInvocationStarted,
+		GatewayFunctionsHistogram:              gatewayFunctionsHistogram,
+		GatewayFunctionInvocation:              gatewayFunctionInvocation,
+		ServiceReplicasGauge:                   serviceReplicas,
+		GatewayFunctionInvocationStarted:       gatewayFunctionInvocationStarted,
+		GatewayFunctionRateLimited:             gatewayFunctionRateLimited,
+		GatewayFunctionQueueDepth:              gatewayFunctionQueueDepth,
+		GatewayFunctionQueueWaitHistogram:      gatewayFunctionQueueWaitHistogram,
+		GatewayFunctionMirrorTotal:             gatewayFunctionMirrorTotal,
+		GatewayFunctionMirrorLatencyDifference: gatewayFunctionMirrorLatencyDifference,
+		GatewayFunctionCacheTotal:              gatewayFunctionCacheTotal,
+		GatewayResponseCacheBytes:              gatewayResponseCacheBytes,
+		GatewayFunctionRetriesTotal:            gatewayFunctionRetriesTotal,
+		GatewayFunctionRetryBudgetExhausted:    gatewayFunctionRetryBudgetExhausted,
+		GatewayFunctionCircuitBreakerState:     gatewayFunctionCircuitBreakerState,
+		GatewayFunctionScaleToZero:             gatewayFunctionScaleToZero,
+		GatewayFunctionDesiredReplicas:         gatewayFunctionDesiredReplicas,
+		GatewayFunctionColdStartHistogram:      gatewayFunctionColdStartHistogram,
+		GatewayConcurrencyLimit:                gatewayConcurrencyLimit,
+		GatewayInflightRequests:                gatewayInflightRequests,
+		GatewayRequestsShed:                    gatewayRequestsShed,
+		GatewayFunctionCoalescedTotal:          gatewayFunctionCoalescedTotal,
+		GatewayWebSocketConnectionsOpen:        gatewayWebSocketConnectionsOpen,
+		GatewayWebSocketConnections:            gatewayWebSocketConnections,
 	}
 
 	return metricsOptions
@@ -0,0 +1,32 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package middleware
+
+import "testing"
+
+func Test_ReplaceServiceName(t *testing.T) {
+	scenarios := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "root path", path: "/function/checkout", want: "/function/checkout-v2"},
+		{name: "trailing slash", path: "/function/checkout/", want: "/function/checkout-v2/"},
+		{name: "keeps namespace", path: "/function/checkout.staging", want: "/function/checkout-v2.staging"},
+		{name: "keeps rest of path", path: "/function/checkout.staging/cart/1", want: "/function/checkout-v2.staging/cart/1"},
+		{name: "async function", path: "/async-function/checkout", want: "/async-function/checkout-v2"},
+		{name: "not a function", path: "/system/functions", want: "/system/functions"},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			got := ReplaceServiceName(s.path, "checkout-v2")
+			if got != s.want {
+				t.Errorf("want: %s, got: %s", s.want, got)
+			}
+		})
+	}
+}
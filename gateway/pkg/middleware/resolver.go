@@ -154,3 +154,25 @@ func GetServiceName(urlValue string) string {
 	}
 	return strings.Trim(serviceName, "/")
 }
+
+// ReplaceServiceName swaps the function name in a path such as
+// `/function/xyz.ns/rest/of/path` for functionName, keeping the namespace
+// suffix and the rest of the path. Paths which do not address a function
+// are returned unchanged.
+func ReplaceServiceName(urlPath string, functionName string) string {
+	matcher := functionMatcher.Copy()
+	indices := matcher.FindStringSubmatchIndex(urlPath)
+	if len(indices) != hasPathCount*2 {
+		return urlPath
+	}
+
+	start, end := indices[nameIndex*2], indices[nameIndex*2+1]
+	name := urlPath[start:end]
+
+	replacement := functionName
+	if index := strings.LastIndex(name, "."); index > -1 {
+		replacement = functionName + name[index:]
+	}
+
+	return urlPath[:start] + replacement + urlPath[end:]
+}
@@ -34,6 +34,15 @@ type ExternalServiceQuery struct {
 
 // NewExternalServiceQuery proxies service queries to external plugin via HTTP
 func NewExternalServiceQuery(externalURL url.URL, authInjector middleware.AuthInjector) scaling.ServiceQuery {
+	return newExternalServiceQuery(externalURL, authInjector)
+}
+
+// NewExternalNamespaceQuery proxies namespace queries to external plugin via HTTP
+func NewExternalNamespaceQuery(externalURL url.URL, authInjector middleware.AuthInjector) scaling.NamespaceQuery {
+	return newExternalServiceQuery(externalURL, authInjector)
+}
+
+func newExternalServiceQuery(externalURL url.URL, authInjector middleware.AuthInjector) ExternalServiceQuery {
 	timeout := 3 * time.Second
 
 	proxyClient := http.Client{
@@ -109,7 +118,7 @@ func (s ExternalServiceQuery) GetReplicas(serviceName, serviceNamespace string)
 	}
 
 	minReplicas := uint64(scaling.DefaultMinReplicas)
-	maxReplicas := uint64(scaling.DefaultMaxReplicas)
+	maxReplicas := uint64(0)
 	scalingFactor := uint64(scaling.DefaultScalingFactor)
 	availableReplicas := function.AvailableReplicas
 
@@ -164,10 +173,11 @@ func (s ExternalServiceQuery) SetReplicas(serviceName, serviceNamespace string,
 
 	if err != nil {
 		log.Println(urlPath, err)
-	} else {
-		if res.Body != nil {
-			defer res.Body.Close()
-		}
+		return err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
 	}
 
 	if !(res.StatusCode == http.StatusOK || res.StatusCode == http.StatusAccepted) {
@@ -196,3 +206,42 @@ func extractLabelValue(rawLabelValue string, fallback uint64) uint64 {
 
 	return uint64(value)
 }
+
+// GetNamespace reads a namespace with its annotations and labels, providers
+// without namespaces give an empty FunctionNamespace
+func (s ExternalServiceQuery) GetNamespace(namespace string) (types.FunctionNamespace, error) {
+	ns := types.FunctionNamespace{Name: namespace}
+
+	urlPath := fmt.Sprintf("%ssystem/namespace/%s", s.URL.String(), namespace)
+	req, err := http.NewRequest(http.MethodGet, urlPath, nil)
+	if err != nil {
+		return ns, err
+	}
+
+	if s.AuthInjector != nil {
+		s.AuthInjector.Inject(req)
+	}
+
+	res, err := s.ProxyClient.Do(req)
+	if err != nil {
+		return ns, err
+	}
+
+	var bytesOut []byte
+	if res.Body != nil {
+		defer res.Body.Close()
+		bytesOut, _ = io.ReadAll(res.Body)
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		if err := json.Unmarshal(bytesOut, &ns); err != nil {
+			return ns, fmt.Errorf("unable to unmarshal namespace %s: %s", namespace, err)
+		}
+		return ns, nil
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return ns, nil
+	}
+
+	return ns, fmt.Errorf("server returned non-200 status code (%d) for namespace, %s, body: %s", res.StatusCode, namespace, string(bytesOut))
+}
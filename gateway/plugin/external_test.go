@@ -71,7 +71,7 @@ func TestGetReplicasExistentFn(t *testing.T) {
 
 	expectedSvcQryResp := scaling.ServiceQueryResponse{
 		Replicas:          0,
-		MaxReplicas:       uint64(scaling.DefaultMaxReplicas),
+		MaxReplicas:       0,
 		MinReplicas:       uint64(scaling.DefaultMinReplicas),
 		ScalingFactor:     uint64(scaling.DefaultScalingFactor),
 		AvailableReplicas: 0,
@@ -136,3 +136,46 @@ func TestSetReplicasExistentFn(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestGetNamespace(t *testing.T) {
+	testServer := httptest.NewServer(
+		http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			if req.URL.Path != "/system/namespace/staging" {
+				res.WriteHeader(http.StatusNotFound)
+				return
+			}
+			res.WriteHeader(http.StatusOK)
+			res.Write([]byte(`{"name":"staging","annotations":{"com.openfaas.scale.max":"10"}}`))
+		}))
+	defer testServer.Close()
+
+	var injector middleware.AuthInjector
+	url, _ := url.Parse(testServer.URL + "/")
+	nsq := NewExternalNamespaceQuery(*url, injector)
+
+	ns, err := nsq.GetNamespace("staging")
+	if err != nil {
+		t.Fatalf("Expected err to be nil got: %s", err)
+	}
+	if got := ns.Annotations[scaling.MaxScaleLabel]; got != "10" {
+		t.Errorf("Wanted annotation %s: 10, got: %q", scaling.MaxScaleLabel, got)
+	}
+
+	ns, err = nsq.GetNamespace("unknown")
+	if err != nil {
+		t.Fatalf("Expected err to be nil for a provider without the namespace got: %s", err)
+	}
+	if len(ns.Annotations) != 0 {
+		t.Errorf("Wanted no annotations, got: %v", ns.Annotations)
+	}
+}
+
+func TestSetReplicasUnreachable(t *testing.T) {
+	var injector middleware.AuthInjector
+	url, _ := url.Parse("http://127.0.0.1:1/")
+	esq := NewExternalServiceQuery(*url, injector)
+
+	if err := esq.SetReplicas("figlet", "", 1); err == nil {
+		t.Errorf("Expected an error when the provider is unreachable")
+	}
+}
@@ -5,21 +5,44 @@
 
 package requests
 
+import "time"
+
 // PrometheusInnerAlertLabel PrometheusInnerAlertLabel
 type PrometheusInnerAlertLabel struct {
 	AlertName    string `json:"alertname"`
 	FunctionName string `json:"function_name"`
+
+	// Namespace of the function when function_name has no namespace suffix
+	Namespace string `json:"namespace,omitempty"`
 }
 
 // PrometheusInnerAlert PrometheusInnerAlert
 type PrometheusInnerAlert struct {
-	Status string                    `json:"status"`
-	Labels PrometheusInnerAlertLabel `json:"labels"`
+	Status      string                    `json:"status"`
+	Labels      PrometheusInnerAlertLabel `json:"labels"`
+	Annotations map[string]string         `json:"annotations,omitempty"`
+
+	// StartsAt is when the alert started firing, and EndsAt when it was
+	// resolved, or zero while firing
+	StartsAt time.Time `json:"startsAt"`
+	EndsAt   time.Time `json:"endsAt"`
+
+	GeneratorURL string `json:"generatorURL,omitempty"`
+
+	// Fingerprint identifies the alert across notifications
+	Fingerprint string `json:"fingerprint,omitempty"`
 }
 
 // PrometheusAlert as produced by AlertManager
 type PrometheusAlert struct {
+	Version  string                 `json:"version,omitempty"`
 	Status   string                 `json:"status"`
 	Receiver string                 `json:"receiver"`
 	Alerts   []PrometheusInnerAlert `json:"alerts"`
+
+	GroupLabels       map[string]string `json:"groupLabels,omitempty"`
+	CommonLabels      map[string]string `json:"commonLabels,omitempty"`
+	CommonAnnotations map[string]string `json:"commonAnnotations,omitempty"`
+	ExternalURL       string            `json:"externalURL,omitempty"`
+	TruncatedAlerts   int               `json:"truncatedAlerts,omitempty"`
 }
@@ -8,6 +8,7 @@ package requests
 import (
 	"encoding/json"
 	"testing"
+	"time"
 )
 
 // TestUnmarshallAlert is an exploratory test from TDD'ing the struct to parse a Prometheus alert
@@ -88,3 +89,52 @@ func TestUnmarshallAlert(t *testing.T) {
 	}
 
 }
+
+func TestUnmarshallAlert_V2(t *testing.T) {
+	file := []byte(`{
+    "version": "4",
+    "groupKey": "{}:{alertname=\"APIHighInvocationRate\"}",
+    "truncatedAlerts": 0,
+    "status": "resolved",
+    "receiver": "scale-up",
+    "alerts": [{
+        "status": "resolved",
+        "labels": {
+            "alertname": "APIHighInvocationRate",
+            "function_name": "nodeinfo",
+            "namespace": "staging"
+        },
+        "annotations": {
+            "summary": "High invocation total on gateway:8080"
+        },
+        "startsAt": "2024-03-15T15:52:57.805Z",
+        "endsAt": "2024-03-15T15:55:57.805Z",
+        "generatorURL": "http://prometheus:9090/graph",
+        "fingerprint": "c50a3a8e0e0b5f12"
+    }],
+    "groupLabels": {"alertname": "APIHighInvocationRate"},
+    "commonLabels": {"alertname": "APIHighInvocationRate"},
+    "commonAnnotations": {},
+    "externalURL": "http://alertmanager:9093"
+}`)
+
+	var alert PrometheusAlert
+	if err := json.Unmarshal(file, &alert); err != nil {
+		t.Fatal(err)
+	}
+
+	if alert.Version != "4" {
+		t.Errorf("want version 4, got: %q", alert.Version)
+	}
+
+	inner := alert.Alerts[0]
+	if inner.Labels.Namespace != "staging" {
+		t.Errorf("want namespace staging, got: %q", inner.Labels.Namespace)
+	}
+	if inner.Fingerprint != "c50a3a8e0e0b5f12" {
+		t.Errorf("want fingerprint c50a3a8e0e0b5f12, got: %q", inner.Fingerprint)
+	}
+	if got := inner.EndsAt.Sub(inner.StartsAt); got != time.Minute*3 {
+		t.Errorf("want the alert to have fired for 3m, got: %s", got)
+	}
+}
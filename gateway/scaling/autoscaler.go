@@ -0,0 +1,366 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package scaling
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	types "github.com/openfaas/faas-provider/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// ScaleTypeLabel is the metric the Autoscaler targets for a function:
+	// "rps", "capacity" for requests in-flight or "latency"
+	ScaleTypeLabel = "com.openfaas.scale.type"
+
+	// ScaleTargetLabel is the target value of the metric per replica, i.e.
+	// "50" requests per second per replica, or the average latency such as
+	// "500ms" for the latency type. Functions without a target are not
+	// scaled by the Autoscaler.
+	ScaleTargetLabel = "com.openfaas.scale.target"
+
+	// ScaleTypeCapacity scales on the number of requests in-flight
+	ScaleTypeCapacity = "capacity"
+
+	// ScaleTypeLatency scales on the average latency of requests
+	ScaleTypeLatency = "latency"
+)
+
+// AutoscalerConfig configures the Autoscaler
+type AutoscalerConfig struct {
+	// ScaleUpWindow is how long a higher replica count must be recommended
+	// before the function is scaled up
+	ScaleUpWindow time.Duration
+
+	// ScaleDownWindow is how long a lower replica count must be recommended
+	// before the function is scaled down
+	ScaleDownWindow time.Duration
+
+	// DefaultNamespace is used for functions listed without a namespace
+	DefaultNamespace string
+
+	// Ceiling caps the replicas of every function
+	Ceiling *ReplicaCeiling
+
+	// Schedule gives the minimum replicas of functions with an active
+	// replica schedule, it can be nil
+	Schedule *ReplicaScheduler
+
+	// Events records each change of replicas, it can be nil
+	Events *ScaleEventLog
+}
+
+// recommendation is a replica count computed by the Autoscaler
+type recommendation struct {
+	replicas uint64
+	at       time.Time
+}
+
+// functionLoad is the load on a function since the last time the
+// Autoscaler computed its replicas
+type functionLoad struct {
+	inflight int
+	started  int
+
+	// busy is the total duration of the completed invocations, latencies
+	// counts the invocations which had a duration
+	busy      time.Duration
+	latencies int
+
+	recommendations []recommendation
+}
+
+// Autoscaler sets the replicas of functions from the load seen by the
+// gateway, without a round trip through Prometheus and AlertManager. A
+// function opts in with the ScaleTargetLabel, and is kept between its
+// com.openfaas.scale.min and com.openfaas.scale.max replicas. Scale up and
+// scale down are each stabilized over a window, so that a short burst or
+// lull does not cause the replicas to flap.
+type Autoscaler struct {
+	config       AutoscalerConfig
+	serviceQuery ServiceQuery
+	desired      *prometheus.GaugeVec
+
+	lastUpdate time.Time
+	load       map[string]*functionLoad
+	lock       sync.Mutex
+}
+
+// NewAutoscaler creates an Autoscaler which scales functions through
+// serviceQuery and exports the replicas it computes in desired
+func NewAutoscaler(config AutoscalerConfig, serviceQuery ServiceQuery, desired *prometheus.GaugeVec) *Autoscaler {
+	return &Autoscaler{
+		config:       config,
+		serviceQuery: serviceQuery,
+		desired:      desired,
+		lastUpdate:   time.Now(),
+		load:         make(map[string]*functionLoad),
+	}
+}
+
+// Started records the start of an invocation
+func (a *Autoscaler) Started(functionName, namespace string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	l := a.get(functionName + "." + namespace)
+	l.inflight++
+	l.started++
+}
+
+// Completed records the end of an invocation
+func (a *Autoscaler) Completed(functionName, namespace string, duration time.Duration) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	l := a.get(functionName + "." + namespace)
+	if l.inflight > 0 {
+		l.inflight--
+	}
+	if duration > 0 {
+		l.busy += duration
+		l.latencies++
+	}
+}
+
+func (a *Autoscaler) get(key string) *functionLoad {
+	l, ok := a.load[key]
+	if !ok {
+		l = &functionLoad{}
+		a.load[key] = l
+	}
+	return l
+}
+
+// Update computes the replicas for each function in services from the load
+// since the last update, and scales the functions which need to change
+func (a *Autoscaler) Update(services []types.FunctionStatus) {
+	a.update(services, time.Now())
+}
+
+// scaleDecision is a change of replicas for a function
+type scaleDecision struct {
+	name      string
+	namespace string
+	current   uint64
+	replicas  uint64
+}
+
+func (a *Autoscaler) update(services []types.FunctionStatus, now time.Time) {
+	decisions := []scaleDecision{}
+	listed := make(map[string]bool, len(services))
+
+	a.lock.Lock()
+	elapsed := now.Sub(a.lastUpdate)
+	a.lastUpdate = now
+
+	for _, service := range services {
+		namespace := service.Namespace
+		if len(namespace) == 0 {
+			namespace = a.config.DefaultNamespace
+		}
+		key := service.Name + "." + namespace
+		listed[key] = true
+
+		l := a.get(key)
+		started, busy, latencies := l.started, l.busy, l.latencies
+		l.started, l.busy, l.latencies = 0, 0, 0
+
+		// Functions scaled to zero are left for scale from zero
+		if service.Replicas == 0 || elapsed <= 0 {
+			continue
+		}
+
+		scaleType, target, ok := scaleTarget(service)
+		if !ok {
+			continue
+		}
+
+		current := service.Replicas
+		var replicas uint64
+		switch scaleType {
+		case ScaleTypeCapacity:
+			// The average in-flight requests over the interval, or those
+			// in-flight now when they have not completed yet
+			inflight := math.Max(busy.Seconds()/elapsed.Seconds(), float64(l.inflight))
+			replicas = uint64(math.Ceil(inflight / target))
+		case ScaleTypeLatency:
+			if latencies == 0 {
+				replicas = current
+				if l.inflight == 0 {
+					replicas = 0
+				}
+			} else {
+				latency := busy.Seconds() / float64(latencies)
+				replicas = uint64(math.Ceil(float64(current) * latency / target))
+			}
+		default:
+			rps := float64(started) / elapsed.Seconds()
+			replicas = uint64(math.Ceil(rps / target))
+		}
+
+		ceiling, _ := a.config.Ceiling.Max(namespace)
+		minReplicas, maxReplicas := replicaRange(service, ceiling)
+		if scheduled, ok := a.config.Schedule.Scheduled(service.Name, namespace); ok {
+			minReplicas = min(scheduled, maxReplicas)
+		}
+		replicas = max(minReplicas, min(maxReplicas, replicas))
+
+		replicas = l.stabilize(replicas, current, now, a.config.ScaleUpWindow, a.config.ScaleDownWindow)
+		a.desired.WithLabelValues(key).Set(float64(replicas))
+
+		if replicas != current {
+			decisions = append(decisions, scaleDecision{name: service.Name, namespace: namespace, current: current, replicas: replicas})
+		}
+	}
+
+	// Forget functions which have been removed
+	for key, l := range a.load {
+		if !listed[key] && l.inflight == 0 {
+			delete(a.load, key)
+			a.desired.DeleteLabelValues(key)
+		}
+	}
+	a.lock.Unlock()
+
+	for _, d := range decisions {
+		log.Printf("[Autoscaler] function=%s.%s %d => %d", d.name, d.namespace, d.current, d.replicas)
+		if err := d.apply(a.serviceQuery, a.config.Events, TriggerAutoscaler); err != nil {
+			log.Printf("[Autoscaler] function=%s.%s unable to scale: %s", d.name, d.namespace, err)
+		}
+	}
+}
+
+// apply scales the function through serviceQuery and records the event
+func (d scaleDecision) apply(serviceQuery ServiceQuery, events *ScaleEventLog, trigger ScaleTrigger) error {
+	start := time.Now()
+	err := serviceQuery.SetReplicas(d.name, d.namespace, d.replicas)
+
+	events.Record(ScaleEvent{
+		Name:            d.name,
+		Namespace:       d.namespace,
+		Trigger:         trigger,
+		From:            d.current,
+		To:              d.replicas,
+		DurationSeconds: time.Since(start).Seconds(),
+		Error:           errorString(err),
+	})
+	return err
+}
+
+// stabilize records replicas as a recommendation and returns the replicas
+// to scale to. The function is only scaled up to the lowest recommendation
+// in the scale up window, and only scaled down to the highest
+// recommendation in the scale down window.
+func (l *functionLoad) stabilize(replicas, current uint64, now time.Time, upWindow, downWindow time.Duration) uint64 {
+	l.recommendations = append(l.recommendations, recommendation{replicas: replicas, at: now})
+
+	longest := max(upWindow, downWindow)
+	kept := l.recommendations[:0]
+	for _, r := range l.recommendations {
+		if now.Sub(r.at) <= longest {
+			kept = append(kept, r)
+		}
+	}
+	l.recommendations = kept
+
+	up, down := replicas, replicas
+	for _, r := range l.recommendations {
+		if now.Sub(r.at) <= upWindow {
+			up = min(up, r.replicas)
+		}
+		if now.Sub(r.at) <= downWindow {
+			down = max(down, r.replicas)
+		}
+	}
+
+	desired := current
+	if desired < up {
+		desired = up
+	}
+	if desired > down {
+		desired = down
+	}
+	return desired
+}
+
+// scaleTarget reads the type and target of a function which has opted in
+// to the Autoscaler from its annotations or labels, the target of the
+// latency type is in seconds
+func scaleTarget(service types.FunctionStatus) (string, float64, bool) {
+	value, ok := functionSetting(service, ScaleTargetLabel)
+	if !ok {
+		return "", 0, false
+	}
+
+	scaleType, _ := functionSetting(service, ScaleTypeLabel)
+	if len(scaleType) == 0 {
+		scaleType = DefaultTypeScale
+	}
+
+	var target float64
+	var err error
+	switch scaleType {
+	case DefaultTypeScale, ScaleTypeCapacity:
+		target, err = strconv.ParseFloat(value, 64)
+	case ScaleTypeLatency:
+		var latency time.Duration
+		latency, err = time.ParseDuration(value)
+		target = latency.Seconds()
+	default:
+		log.Printf("[Autoscaler] function=%s has an invalid %s annotation: %q", service.Name, ScaleTypeLabel, scaleType)
+		return "", 0, false
+	}
+
+	if err != nil || target <= 0 {
+		log.Printf("[Autoscaler] function=%s has an invalid %s annotation: %q", service.Name, ScaleTargetLabel, value)
+		return "", 0, false
+	}
+	return scaleType, target, true
+}
+
+// replicaRange reads the minimum and maximum replicas of a function from
+// its labels in the same way as the provider, the maximum is the ceiling
+// unless the function's label sets a lower one
+func replicaRange(service types.FunctionStatus, ceiling uint64) (uint64, uint64) {
+	minReplicas := uint64(DefaultMinReplicas)
+	maxReplicas := ceiling
+
+	if service.Labels != nil {
+		labels := *service.Labels
+		if val, err := strconv.ParseUint(labels[MinScaleLabel], 10, 64); err == nil && val > 0 {
+			minReplicas = val
+		}
+		if val, err := strconv.ParseUint(labels[MaxScaleLabel], 10, 64); err == nil && val > 0 {
+			maxReplicas = val
+		}
+	}
+
+	maxReplicas = min(maxReplicas, ceiling)
+	minReplicas = min(minReplicas, maxReplicas)
+	return minReplicas, maxReplicas
+}
+
+// functionSetting reads a setting from a function's annotations, falling
+// back to its labels
+func functionSetting(service types.FunctionStatus, key string) (string, bool) {
+	if service.Annotations != nil {
+		if value, ok := (*service.Annotations)[key]; ok {
+			return value, true
+		}
+	}
+	if service.Labels != nil {
+		if value, ok := (*service.Labels)[key]; ok {
+			return value, true
+		}
+	}
+	return "", false
+}
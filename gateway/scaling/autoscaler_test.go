@@ -0,0 +1,164 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package scaling
+
+import (
+	"testing"
+	"time"
+
+	types "github.com/openfaas/faas-provider/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newTestAutoscaler(config AutoscalerConfig) (*Autoscaler, *recordingServiceQuery, time.Time) {
+	query := &recordingServiceQuery{}
+	desired := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "desired"}, []string{"function_name"})
+
+	config.Ceiling = NewReplicaCeiling(DefaultMaxReplicas, nil, 0)
+	a := NewAutoscaler(config, query, desired)
+	now := time.Now()
+	a.lastUpdate = now
+	return a, query, now
+}
+
+func autoscaledService(replicas uint64, annotations map[string]string, labels map[string]string) types.FunctionStatus {
+	return types.FunctionStatus{Name: "api", Namespace: "openfaas-fn", Replicas: replicas, Annotations: &annotations, Labels: &labels}
+}
+
+func invoke(a *Autoscaler, count int, duration time.Duration) {
+	for i := 0; i < count; i++ {
+		a.Started("api", "openfaas-fn")
+		a.Completed("api", "openfaas-fn", duration)
+	}
+}
+
+func Test_Autoscaler_ScaleTypes(t *testing.T) {
+	scenarios := []struct {
+		name        string
+		annotations map[string]string
+		labels      map[string]string
+		current     uint64
+		invocations int
+		duration    time.Duration
+		want        uint64
+	}{
+		{name: "rps", annotations: map[string]string{ScaleTargetLabel: "10"}, current: 1, invocations: 40, duration: time.Millisecond, want: 4},
+		{name: "rps capped by max label", annotations: map[string]string{ScaleTargetLabel: "10"}, labels: map[string]string{MaxScaleLabel: "3"}, current: 1, invocations: 100, duration: time.Millisecond, want: 3},
+		{name: "rps kept at min label", annotations: map[string]string{ScaleTargetLabel: "10"}, labels: map[string]string{MinScaleLabel: "2"}, current: 4, invocations: 1, duration: time.Millisecond, want: 2},
+		{name: "capacity", annotations: map[string]string{ScaleTypeLabel: "capacity", ScaleTargetLabel: "2"}, current: 1, invocations: 10, duration: time.Millisecond * 500, want: 3},
+		{name: "latency", annotations: map[string]string{ScaleTypeLabel: "latency", ScaleTargetLabel: "500ms"}, current: 2, invocations: 5, duration: time.Second, want: 4},
+		{name: "target from labels", labels: map[string]string{ScaleTargetLabel: "10"}, current: 1, invocations: 20, duration: time.Millisecond, want: 2},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			a, query, now := newTestAutoscaler(AutoscalerConfig{})
+			invoke(a, s.invocations, s.duration)
+
+			a.update([]types.FunctionStatus{autoscaledService(s.current, s.annotations, s.labels)}, now.Add(time.Second))
+
+			if len(query.replicas) != 1 || query.replicas[0] != s.want {
+				t.Errorf("want api.openfaas-fn scaled to %d, got: %v", s.want, query.replicas)
+			}
+		})
+	}
+}
+
+func Test_Autoscaler_IgnoresFunctionsWithoutTarget(t *testing.T) {
+	a, query, now := newTestAutoscaler(AutoscalerConfig{})
+	invoke(a, 100, time.Millisecond)
+
+	services := []types.FunctionStatus{
+		autoscaledService(1, map[string]string{}, nil),
+		autoscaledService(0, map[string]string{ScaleTargetLabel: "10"}, nil),
+	}
+	a.update(services, now.Add(time.Second))
+
+	if len(query.scaled) != 0 {
+		t.Errorf("want nothing scaled, got: %v", query.scaled)
+	}
+}
+
+func Test_Autoscaler_StabilizationWindows(t *testing.T) {
+	a, query, now := newTestAutoscaler(AutoscalerConfig{ScaleUpWindow: time.Second * 10, ScaleDownWindow: time.Second * 60})
+	annotations := map[string]string{ScaleTargetLabel: "10"}
+
+	last := time.Duration(0)
+	tick := func(current uint64, rps int, at time.Duration) {
+		invoke(a, rps*int((at-last)/time.Second), time.Millisecond)
+		last = at
+		a.update([]types.FunctionStatus{autoscaledService(current, annotations, nil)}, now.Add(at))
+	}
+
+	tick(1, 5, time.Second*5)
+	tick(1, 40, time.Second*10)
+	tick(1, 40, time.Second*15)
+	if len(query.replicas) != 0 {
+		t.Fatalf("want a burst within the scale up window ignored, got: %v", query.replicas)
+	}
+
+	tick(1, 40, time.Second*20)
+	if len(query.replicas) != 1 || query.replicas[0] != 4 {
+		t.Fatalf("want scaled up to 4 once sustained, got: %v", query.replicas)
+	}
+
+	tick(4, 5, time.Second*25)
+	tick(4, 5, time.Second*75)
+	if len(query.replicas) != 1 {
+		t.Fatalf("want no scale down within the scale down window, got: %v", query.replicas)
+	}
+
+	tick(4, 5, time.Second*85)
+	if len(query.replicas) != 2 || query.replicas[1] != 1 {
+		t.Errorf("want scaled down to 1 after the scale down window, got: %v", query.replicas)
+	}
+}
+
+func Test_replicaRange(t *testing.T) {
+	scenarios := []struct {
+		name    string
+		labels  map[string]string
+		ceiling uint64
+		wantMin uint64
+		wantMax uint64
+	}{
+		{name: "no labels", labels: map[string]string{}, ceiling: 50, wantMin: 1, wantMax: 50},
+		{name: "function maximum", labels: map[string]string{MaxScaleLabel: "8"}, ceiling: 50, wantMin: 1, wantMax: 8},
+		{name: "function maximum above ceiling", labels: map[string]string{MinScaleLabel: "2", MaxScaleLabel: "80"}, ceiling: 50, wantMin: 2, wantMax: 50},
+		{name: "minimum above ceiling", labels: map[string]string{MinScaleLabel: "10"}, ceiling: 4, wantMin: 4, wantMax: 4},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			minReplicas, maxReplicas := replicaRange(types.FunctionStatus{Name: "api", Labels: &s.labels}, s.ceiling)
+			if minReplicas != s.wantMin || maxReplicas != s.wantMax {
+				t.Errorf("range want: %d-%d, got: %d-%d", s.wantMin, s.wantMax, minReplicas, maxReplicas)
+			}
+		})
+	}
+}
+
+func Test_Autoscaler_ScheduledMinimumWithoutMaxLabel(t *testing.T) {
+	query := &recordingServiceQuery{}
+	ceiling := NewReplicaCeiling(20, nil, 0)
+
+	scheduler := NewReplicaScheduler(ReplicaSchedulerConfig{Ceiling: ceiling}, query)
+	api := autoscaledService(10, map[string]string{ScaleTargetLabel: "10", ScheduleAnnotation: "* * * * * 10"}, map[string]string{})
+	scheduler.update([]types.FunctionStatus{api}, time.Now())
+	query.scaled, query.replicas = nil, nil
+
+	desired := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "desired"}, []string{"function_name"})
+	a := NewAutoscaler(AutoscalerConfig{Ceiling: ceiling, Schedule: scheduler}, query, desired)
+	now := time.Now()
+	a.lastUpdate = now
+
+	invoke(a, 1, time.Millisecond)
+	a.update([]types.FunctionStatus{api}, now.Add(time.Second))
+
+	if len(query.replicas) != 0 {
+		t.Errorf("want api kept at its scheduled 10 replicas, got: %v", query.replicas)
+	}
+}
@@ -0,0 +1,98 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package scaling
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	types "github.com/openfaas/faas-provider/types"
+)
+
+// NamespaceQuery reads a namespace and its annotations from the provider
+type NamespaceQuery interface {
+	GetNamespace(namespace string) (types.FunctionNamespace, error)
+}
+
+// namespaceCeiling is a cached ceiling read from a namespace's annotations,
+// max is 0 when the namespace has none
+type namespaceCeiling struct {
+	max     uint64
+	fetched time.Time
+}
+
+// ReplicaCeiling is the most replicas any function can be scaled to. The
+// gateway's ceiling can be lowered for the functions in a namespace with the
+// com.openfaas.scale.max annotation on the namespace.
+type ReplicaCeiling struct {
+	max            uint64
+	namespaceQuery NamespaceQuery
+	expiry         time.Duration
+
+	namespaces map[string]namespaceCeiling
+	lock       sync.Mutex
+}
+
+// NewReplicaCeiling creates a ReplicaCeiling of max replicas, the annotations
+// of namespaces are read with namespaceQuery and cached for expiry. When
+// namespaceQuery is nil only the gateway's ceiling applies.
+func NewReplicaCeiling(max uint64, namespaceQuery NamespaceQuery, expiry time.Duration) *ReplicaCeiling {
+	return &ReplicaCeiling{
+		max:            max,
+		namespaceQuery: namespaceQuery,
+		expiry:         expiry,
+		namespaces:     make(map[string]namespaceCeiling),
+	}
+}
+
+// Max gives the ceiling for functions in namespace, and whether it was set
+// by the namespace rather than the gateway
+func (c *ReplicaCeiling) Max(namespace string) (uint64, bool) {
+	if c.namespaceQuery == nil || len(namespace) == 0 {
+		return c.max, false
+	}
+
+	c.lock.Lock()
+	cached, ok := c.namespaces[namespace]
+	c.lock.Unlock()
+
+	if !ok || time.Since(cached.fetched) > c.expiry {
+		cached = namespaceCeiling{max: c.fetch(namespace), fetched: time.Now()}
+
+		c.lock.Lock()
+		c.namespaces[namespace] = cached
+		c.lock.Unlock()
+	}
+
+	if cached.max > 0 && cached.max < c.max {
+		return cached.max, true
+	}
+	return c.max, false
+}
+
+// fetch reads the ceiling from the namespace's annotations, errors are
+// logged and treated as no ceiling so that scaling is still possible
+func (c *ReplicaCeiling) fetch(namespace string) uint64 {
+	ns, err := c.namespaceQuery.GetNamespace(namespace)
+	if err != nil {
+		log.Printf("Unable to read the replica ceiling of namespace %s: %s", namespace, err)
+		return 0
+	}
+
+	value, ok := ns.Annotations[MaxScaleLabel]
+	if !ok {
+		return 0
+	}
+
+	max, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		log.Printf("Namespace %s has an invalid %s annotation: %q", namespace, MaxScaleLabel, value)
+		return 0
+	}
+	return max
+}
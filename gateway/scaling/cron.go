@@ -0,0 +1,212 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package scaling
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSearchYears bounds the search for a matching time, so that an
+// expression which never matches such as "0 0 30 2 *" ends
+const cronSearchYears = 5
+
+// cronSchedule is a parsed cron expression of five fields: minute, hour,
+// day of month, month and day of week. Each field is a bit set of the
+// values it matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// When either day field starts with "*" both must match, otherwise a
+	// day matches either field as with cron
+	anyDom, anyDow bool
+}
+
+// cronField is the range and names of the values of a field
+type cronField struct {
+	min, max uint
+	names    map[string]uint
+}
+
+var cronFields = []cronField{
+	{min: 0, max: 59},
+	{min: 0, max: 23},
+	{min: 1, max: 31},
+	{min: 1, max: 12, names: map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}},
+	{min: 0, max: 7, names: map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}},
+}
+
+// parseCron parses a cron expression such as "0 8 * * mon-fri". Each field
+// is a list of values, ranges and steps i.e. "*/15" or "1-5,0".
+func parseCron(expression string) (cronSchedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != len(cronFields) {
+		return cronSchedule{}, fmt.Errorf("%q should have %d fields, got: %d", expression, len(cronFields), len(fields))
+	}
+
+	var bits [5]uint64
+	for i, field := range fields {
+		b, err := cronFields[i].parse(field)
+		if err != nil {
+			return cronSchedule{}, fmt.Errorf("%q: %s", expression, err)
+		}
+		bits[i] = b
+	}
+
+	// Sunday can be given as 0 or 7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	return cronSchedule{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		anyDom: strings.HasPrefix(fields[2], "*"),
+		anyDow: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+func (f cronField) parse(field string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		values, stepValue, hasStep := strings.Cut(part, "/")
+
+		step := uint64(1)
+		if hasStep {
+			var err error
+			step, err = strconv.ParseUint(stepValue, 10, 8)
+			if err != nil || step == 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		lo, hi := f.min, f.max
+		if values != "*" {
+			first, last, isRange := strings.Cut(values, "-")
+
+			var err error
+			if lo, err = f.value(first); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = f.value(last); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = f.max
+			}
+		}
+
+		if lo > hi {
+			return 0, fmt.Errorf("invalid range %q", part)
+		}
+		for v := lo; v <= hi; v += uint(step) {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (f cronField) value(s string) (uint, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.ParseUint(s, 10, 8)
+	if err != nil || uint(v) < f.min || uint(v) > f.max {
+		return 0, fmt.Errorf("%q should be between %d and %d", s, f.min, f.max)
+	}
+	return uint(v), nil
+}
+
+func (c cronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	if c.anyDom || c.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// prev gives the latest minute at or before t which matches the schedule
+func (c cronSchedule) prev(t time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute)
+	limit := t.AddDate(-cronSearchYears, 0, 0)
+
+	for t.After(limit) {
+		y, m, d := t.Date()
+		loc := t.Location()
+
+		switch {
+		case c.month&(1<<uint(m)) == 0:
+			t = before(t, time.Date(y, m, 1, 0, 0, 0, 0, loc))
+		case !c.dayMatches(t):
+			t = before(t, time.Date(y, m, d, 0, 0, 0, 0, loc))
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = before(t, time.Date(y, m, d, t.Hour(), 0, 0, 0, loc))
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(-time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// next gives the earliest minute after t which matches the schedule
+func (c cronSchedule) next(t time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(cronSearchYears, 0, 0)
+
+	for t.Before(limit) {
+		y, m, d := t.Date()
+		loc := t.Location()
+
+		switch {
+		case c.month&(1<<uint(m)) == 0:
+			t = after(t, time.Date(y, m+1, 1, 0, 0, 0, 0, loc))
+		case !c.dayMatches(t):
+			t = after(t, time.Date(y, m, d+1, 0, 0, 0, 0, loc))
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = after(t, time.Date(y, m, d, t.Hour()+1, 0, 0, 0, loc))
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// before gives the minute before start, or the minute before t when a
+// daylight saving change puts start after t
+func before(t, start time.Time) time.Time {
+	if start.After(t) {
+		return t.Add(-time.Minute)
+	}
+	return start.Add(-time.Minute)
+}
+
+// after gives start, or the minute after t when a daylight saving change
+// puts start before t
+func after(t, start time.Time) time.Time {
+	if !start.After(t) {
+		return t.Add(time.Minute)
+	}
+	return start
+}
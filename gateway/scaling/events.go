@@ -0,0 +1,124 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package scaling
+
+import (
+	"sync"
+	"time"
+)
+
+// ScaleTrigger is what caused a function to be scaled
+type ScaleTrigger string
+
+const (
+	// TriggerScaleFromZero is an invocation of a function with no replicas
+	TriggerScaleFromZero ScaleTrigger = "scale-from-zero"
+
+	// TriggerAlert is a notification from AlertManager
+	TriggerAlert ScaleTrigger = "alert"
+
+	// TriggerAPI is a request to /system/scale-function, i.e. from faas-cli
+	TriggerAPI ScaleTrigger = "api"
+
+	// TriggerScaleToZero is the Idler scaling an idle function to zero
+	TriggerScaleToZero ScaleTrigger = "scale-to-zero"
+
+	// TriggerAutoscaler is the Autoscaler
+	TriggerAutoscaler ScaleTrigger = "autoscaler"
+
+	// TriggerSchedule is a match of a function's replica schedule
+	TriggerSchedule ScaleTrigger = "schedule"
+
+	// TriggerPrewarm is the Prewarmer scaling a function ahead of
+	// predicted invocations
+	TriggerPrewarm ScaleTrigger = "prewarm"
+)
+
+// ScaleEvent is a change of replicas made or requested by the gateway
+type ScaleEvent struct {
+	Time      time.Time    `json:"time"`
+	Name      string       `json:"name"`
+	Namespace string       `json:"namespace"`
+	Trigger   ScaleTrigger `json:"trigger"`
+	From      uint64       `json:"from"`
+	To        uint64       `json:"to"`
+
+	// DurationSeconds is how long the change took, for scale from zero it
+	// includes waiting for a replica to be ready
+	DurationSeconds float64 `json:"durationSeconds"`
+
+	// Error is set when the change failed
+	Error string `json:"error,omitempty"`
+}
+
+// ScaleEventLog keeps the most recent ScaleEvents in memory, the oldest
+// event is replaced once it is full. A nil ScaleEventLog records nothing.
+type ScaleEventLog struct {
+	events []ScaleEvent
+	next   int
+	lock   sync.Mutex
+}
+
+// NewScaleEventLog creates a ScaleEventLog which keeps size events
+func NewScaleEventLog(size int) *ScaleEventLog {
+	return &ScaleEventLog{
+		events: make([]ScaleEvent, 0, size),
+	}
+}
+
+// Record adds an event, its Time is set when it is zero
+func (l *ScaleEventLog) Record(event ScaleEvent) {
+	if l == nil || cap(l.events) == 0 {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if len(l.events) < cap(l.events) {
+		l.events = append(l.events, event)
+		return
+	}
+
+	l.events[l.next] = event
+	l.next = (l.next + 1) % len(l.events)
+}
+
+// Events lists the events of a function oldest first, an empty
+// functionName lists all functions and an empty namespace all namespaces
+func (l *ScaleEventLog) Events(functionName, namespace string) []ScaleEvent {
+	events := []ScaleEvent{}
+	if l == nil {
+		return events
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for i := range l.events {
+		event := l.events[(l.next+i)%len(l.events)]
+		if len(functionName) > 0 && event.Name != functionName {
+			continue
+		}
+		if len(namespace) > 0 && event.Namespace != namespace {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// errorString gives the message of err, or an empty string when it is nil
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,46 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package scaling
+
+import (
+	"testing"
+)
+
+func Test_ScaleEventLog_KeepsMostRecent(t *testing.T) {
+	events := NewScaleEventLog(3)
+
+	for i := uint64(1); i <= 5; i++ {
+		namespace := "openfaas-fn"
+		if i == 4 {
+			namespace = "staging"
+		}
+		events.Record(ScaleEvent{Name: "api", Namespace: namespace, Trigger: TriggerAlert, From: i - 1, To: i})
+	}
+
+	all := events.Events("", "")
+	if len(all) != 3 || all[0].To != 3 || all[1].To != 4 || all[2].To != 5 {
+		t.Fatalf("want the 3 most recent events oldest first, got: %+v", all)
+	}
+	if all[0].Time.IsZero() {
+		t.Errorf("want the time of the event set")
+	}
+
+	if got := events.Events("api", "staging"); len(got) != 1 || got[0].To != 4 {
+		t.Errorf("want one event in staging, got: %+v", got)
+	}
+	if got := events.Events("other", ""); len(got) != 0 {
+		t.Errorf("want no events for other, got: %+v", got)
+	}
+}
+
+func Test_ScaleEventLog_Nil(t *testing.T) {
+	var events *ScaleEventLog
+	events.Record(ScaleEvent{Name: "api"})
+
+	if got := events.Events("", ""); len(got) != 0 {
+		t.Errorf("want no events, got: %+v", got)
+	}
+}
@@ -1,8 +1,10 @@
 package scaling
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/openfaas/faas/gateway/types"
@@ -16,6 +18,7 @@ func NewFunctionScaler(config ScalingConfig, functionCacher FunctionCacher) Func
 		Cache:        functionCacher,
 		Config:       config,
 		SingleFlight: &singleflight.Group{},
+		cold:         &sync.Map{},
 	}
 }
 
@@ -24,6 +27,11 @@ type FunctionScaler struct {
 	Cache        FunctionCacher
 	Config       ScalingConfig
 	SingleFlight *singleflight.Group
+
+	// cold holds the functions last seen without an available replica,
+	// which are probed before they are used again. It is kept apart from
+	// the Cache so that the probe runs even after an entry has expired.
+	cold *sync.Map
 }
 
 // FunctionScaleResult holds the result of scaling from zero
@@ -34,15 +42,52 @@ type FunctionScaleResult struct {
 	Duration  time.Duration
 }
 
+// NotReady reports whether the function was last seen without an available
+// replica, from the cache and without querying the provider
+func (f *FunctionScaler) NotReady(functionName, namespace string) bool {
+	cachedResponse, hit := f.Cache.Get(functionName, namespace)
+	return hit && cachedResponse.AvailableReplicas == 0
+}
+
 // Scale scales a function from zero replicas to 1 or the value set in
 // the minimum replicas metadata
 func (f *FunctionScaler) Scale(functionName, namespace string) FunctionScaleResult {
+	return f.ScaleWithPolls(context.Background(), functionName, namespace, f.Config.MaxPollCount)
+}
+
+// ScaleWithPolls scales a function like Scale, then queries it up to
+// maxPollCount times for a ready replica. Available is false when no
+// replica became ready, and polling stops with ctx's error once ctx is
+// done.
+func (f *FunctionScaler) ScaleWithPolls(ctx context.Context, functionName, namespace string, maxPollCount uint) (result FunctionScaleResult) {
 	start := time.Now()
 
+	// scaledTo is set by the request which scaled the function up, so that
+	// concurrent requests waiting for the same replica record one event
+	var scaledTo uint64
+	defer func() {
+		if scaledTo == 0 {
+			return
+		}
+
+		message := errorString(result.Error)
+		if len(message) == 0 && !result.Available {
+			message = fmt.Sprintf("no replica was ready after %s", result.Duration.Round(time.Millisecond))
+		}
+		f.Config.Events.Record(ScaleEvent{
+			Name:            functionName,
+			Namespace:       namespace,
+			Trigger:         TriggerScaleFromZero,
+			To:              scaledTo,
+			DurationSeconds: result.Duration.Seconds(),
+			Error:           message,
+		})
+	}()
+
 	// First check the cache, if there are available replicas, then the
 	// request can be served.
-	if cachedResponse, hit := f.Cache.Get(functionName, namespace); hit &&
-		cachedResponse.AvailableReplicas > 0 {
+	cachedResponse, hit := f.Cache.Get(functionName, namespace)
+	if hit && cachedResponse.AvailableReplicas > 0 {
 		return FunctionScaleResult{
 			Error:     nil,
 			Available: true,
@@ -75,8 +120,10 @@ func (f *FunctionScaler) Scale(functionName, namespace string) FunctionScaleResu
 		}
 	}
 
-	// Check if there are available replicas in the live data
-	if res.(ServiceQueryResponse).AvailableReplicas > 0 {
+	// Check if there are available replicas in the live data, a function
+	// last seen without one is probed first
+	queryResponse := res.(ServiceQueryResponse)
+	if f.available(functionName, namespace, queryResponse) {
 		return FunctionScaleResult{
 			Error:     nil,
 			Available: true,
@@ -84,9 +131,9 @@ func (f *FunctionScaler) Scale(functionName, namespace string) FunctionScaleResu
 			Duration:  time.Since(start),
 		}
 	}
+	queryResponse.AvailableReplicas = 0
 
 	// Store the result of GetReplicas in the cache
-	queryResponse := res.(ServiceQueryResponse)
 	f.Cache.Set(functionName, namespace, queryResponse)
 
 	// If the desired replica count is 0, then a scale up event
@@ -126,6 +173,7 @@ func (f *FunctionScaler) Scale(functionName, namespace string) FunctionScaleResu
 
 				log.Printf("[Scale %d/%d] function=%s 0 => %d requested",
 					attempt, int(f.Config.SetScaleRetries), functionName, minReplicas)
+				scaledTo = minReplicas
 
 				if err := f.Config.ServiceQuery.SetReplicas(functionName, namespace, minReplicas); err != nil {
 					return nil, fmt.Errorf("unable to scale function [%s], err: %s", functionName, err)
@@ -151,7 +199,7 @@ func (f *FunctionScaler) Scale(functionName, namespace string) FunctionScaleResu
 	}
 
 	// Holding pattern for at least one function replica to be available
-	for i := 0; i < int(f.Config.MaxPollCount); i++ {
+	for i := 0; i < int(maxPollCount); i++ {
 
 		res, err, _ := f.SingleFlight.Do(getKey, func() (interface{}, error) {
 			return f.Config.ServiceQuery.GetReplicas(functionName, namespace)
@@ -159,6 +207,11 @@ func (f *FunctionScaler) Scale(functionName, namespace string) FunctionScaleResu
 		queryResponse := res.(ServiceQueryResponse)
 
 		if err == nil {
+			// Cached as unavailable until the probe passes, so that other
+			// requests are held too
+			if !f.available(functionName, namespace, queryResponse) {
+				queryResponse.AvailableReplicas = 0
+			}
 			f.Cache.Set(functionName, namespace, queryResponse)
 		}
 
@@ -185,13 +238,57 @@ func (f *FunctionScaler) Scale(functionName, namespace string) FunctionScaleResu
 			}
 		}
 
-		time.Sleep(f.Config.FunctionPollInterval)
+		select {
+		case <-ctx.Done():
+			return FunctionScaleResult{
+				Error:     ctx.Err(),
+				Available: false,
+				Found:     true,
+				Duration:  time.Since(start),
+			}
+		case <-time.After(f.Config.FunctionPollInterval):
+		}
 	}
 
 	return FunctionScaleResult{
 		Error:     nil,
-		Available: true,
+		Available: false,
 		Found:     true,
 		Duration:  time.Since(start),
 	}
 }
+
+// available reports whether the function has a replica which can serve
+// requests. A function seen without an available replica is remembered as
+// cold, and is probed on the first sighting of a replica after that, no
+// matter which component scaled it up.
+func (f *FunctionScaler) available(functionName, namespace string, queryResponse ServiceQueryResponse) bool {
+	key := functionName + "." + namespace
+	if queryResponse.AvailableReplicas == 0 {
+		f.cold.Store(key, true)
+		return false
+	}
+
+	if _, cold := f.cold.Load(key); !cold {
+		return true
+	}
+	if !f.ready(functionName, namespace) {
+		return false
+	}
+
+	f.cold.Delete(key)
+	return true
+}
+
+// ready checks the ReadinessProbe of the config, concurrent requests for a
+// function share one probe. A function is ready when there is no probe.
+func (f *FunctionScaler) ready(functionName, namespace string) bool {
+	if f.Config.ReadinessProbe == nil {
+		return true
+	}
+
+	ready, _, _ := f.SingleFlight.Do(fmt.Sprintf("Ready-%s.%s", functionName, namespace), func() (interface{}, error) {
+		return f.Config.ReadinessProbe.Ready(functionName, namespace), nil
+	})
+	return ready.(bool)
+}
@@ -0,0 +1,273 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package scaling
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	types "github.com/openfaas/faas-provider/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// ScaleToZeroAnnotation opts a function into being scaled to zero
+	// replicas when it is idle i.e. "true"
+	ScaleToZeroAnnotation = "com.openfaas.scale.zero"
+
+	// ScaleToZeroDurationAnnotation is how long a function must go without
+	// invocations before it is scaled to zero i.e. "15m". Asynchronous
+	// invocations count from when they are queued, so the duration should
+	// cover the time they may wait in the queue.
+	ScaleToZeroDurationAnnotation = "com.openfaas.scale.zero-duration"
+
+	// DefaultScaleToZeroDuration is used when a function opts in without
+	// giving a duration
+	DefaultScaleToZeroDuration = time.Minute * 15
+)
+
+// IdlerConfig configures the Idler
+type IdlerConfig struct {
+	// DryRun logs and counts the functions which would be scaled to zero
+	// without scaling them
+	DryRun bool
+
+	// Namespaces in which functions can be scaled to zero, when empty all
+	// namespaces are enabled
+	Namespaces []string
+
+	// DefaultNamespace is used for functions listed without a namespace
+	DefaultNamespace string
+
+	// Schedule keeps functions scheduled to have replicas from being
+	// scaled to zero, it can be nil
+	Schedule *ReplicaScheduler
+
+	// Prewarm keeps functions with predicted invocations from being scaled
+	// to zero, it can be nil
+	Prewarm *Prewarmer
+
+	// Events records each function scaled to zero, it can be nil
+	Events *ScaleEventLog
+}
+
+// InvocationRecorder is told about the invocations of functions through
+// the gateway. A duration of zero is used for a WebSocket connection.
+type InvocationRecorder interface {
+	Started(functionName, namespace string)
+	Completed(functionName, namespace string, duration time.Duration)
+}
+
+// functionActivity is the last time a function was invoked and the number
+// of its invocations still in-flight
+type functionActivity struct {
+	lastSeen time.Time
+	inflight int
+}
+
+// Idler scales functions to zero replicas once they have had no invocations
+// through the gateway for their idle duration. Functions opt in with the
+// ScaleToZeroAnnotation. The list of functions is passed to Update by the
+// service watcher and invocations are passed to Started and Completed.
+type Idler struct {
+	config       IdlerConfig
+	serviceQuery ServiceQuery
+	decisions    *prometheus.CounterVec
+
+	// started is used as the last invocation of functions which have not
+	// been invoked since the gateway started
+	started  time.Time
+	activity map[string]*functionActivity
+	lock     sync.Mutex
+}
+
+// NewIdler creates an Idler which scales functions through serviceQuery and
+// counts its decisions in decisions
+func NewIdler(config IdlerConfig, serviceQuery ServiceQuery, decisions *prometheus.CounterVec) *Idler {
+	return &Idler{
+		config:       config,
+		serviceQuery: serviceQuery,
+		decisions:    decisions,
+		started:      time.Now(),
+		activity:     make(map[string]*functionActivity),
+	}
+}
+
+// Started records the start of an invocation, the function is not idle
+// until it completes
+func (i *Idler) Started(functionName, namespace string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	a := i.get(functionName + "." + namespace)
+	a.inflight++
+	a.lastSeen = time.Now()
+}
+
+// Completed records the end of an invocation
+func (i *Idler) Completed(functionName, namespace string, duration time.Duration) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	a := i.get(functionName + "." + namespace)
+	if a.inflight > 0 {
+		a.inflight--
+	}
+	a.lastSeen = time.Now()
+}
+
+func (i *Idler) get(key string) *functionActivity {
+	a, ok := i.activity[key]
+	if !ok {
+		a = &functionActivity{}
+		i.activity[key] = a
+	}
+	return a
+}
+
+// Update scales the idle functions in services to zero
+func (i *Idler) Update(services []types.FunctionStatus) {
+	i.update(services, time.Now())
+}
+
+// idleFunction is a function which the Idler has decided to scale to zero
+type idleFunction struct {
+	name      string
+	namespace string
+	replicas  uint64
+	idle      time.Duration
+}
+
+func (i *Idler) update(services []types.FunctionStatus, now time.Time) {
+	idle := []idleFunction{}
+	listed := make(map[string]bool, len(services))
+
+	i.lock.Lock()
+	for _, service := range services {
+		namespace := service.Namespace
+		if len(namespace) == 0 {
+			namespace = i.config.DefaultNamespace
+		}
+		key := service.Name + "." + namespace
+		listed[key] = true
+
+		if service.Replicas == 0 || !i.namespaceEnabled(namespace) {
+			continue
+		}
+
+		if scheduled, ok := i.config.Schedule.Scheduled(service.Name, namespace); ok && scheduled > 0 {
+			continue
+		}
+
+		if i.config.Prewarm.Warm(service.Name, namespace) {
+			continue
+		}
+
+		duration, ok := scaleToZeroDuration(service)
+		if !ok {
+			continue
+		}
+
+		lastSeen := i.started
+		if a, ok := i.activity[key]; ok {
+			if a.inflight > 0 {
+				continue
+			}
+			if a.lastSeen.After(lastSeen) {
+				lastSeen = a.lastSeen
+			}
+		}
+
+		if now.Sub(lastSeen) >= duration {
+			idle = append(idle, idleFunction{name: service.Name, namespace: namespace, replicas: service.Replicas, idle: now.Sub(lastSeen)})
+
+			// Wait for another idle duration before deciding again, so
+			// that a dry-run or a slow provider is not logged every time
+			i.get(key).lastSeen = now
+		}
+	}
+
+	// Forget functions which have been removed
+	for key, a := range i.activity {
+		if !listed[key] && a.inflight == 0 {
+			delete(i.activity, key)
+		}
+	}
+	i.lock.Unlock()
+
+	for _, fn := range idle {
+		i.scaleToZero(fn)
+	}
+}
+
+func (i *Idler) scaleToZero(fn idleFunction) {
+	label := fn.name + "." + fn.namespace
+
+	if i.config.DryRun {
+		log.Printf("[Idler] function=%s idle for %s, would scale to zero (dry-run)", label, fn.idle.Round(time.Second))
+		i.decisions.WithLabelValues(label, "dry_run").Inc()
+		return
+	}
+
+	start := time.Now()
+	err := i.serviceQuery.SetReplicas(fn.name, fn.namespace, 0)
+	i.config.Events.Record(ScaleEvent{
+		Name:            fn.name,
+		Namespace:       fn.namespace,
+		Trigger:         TriggerScaleToZero,
+		From:            fn.replicas,
+		DurationSeconds: time.Since(start).Seconds(),
+		Error:           errorString(err),
+	})
+
+	if err != nil {
+		log.Printf("[Idler] function=%s idle for %s, unable to scale to zero: %s", label, fn.idle.Round(time.Second), err)
+		i.decisions.WithLabelValues(label, "failed").Inc()
+		return
+	}
+
+	log.Printf("[Idler] function=%s idle for %s, scaled to zero", label, fn.idle.Round(time.Second))
+	i.decisions.WithLabelValues(label, "scaled").Inc()
+}
+
+func (i *Idler) namespaceEnabled(namespace string) bool {
+	if len(i.config.Namespaces) == 0 {
+		return true
+	}
+	for _, ns := range i.config.Namespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// scaleToZeroDuration reads the idle duration of a function which has opted
+// in to being scaled to zero
+func scaleToZeroDuration(service types.FunctionStatus) (time.Duration, bool) {
+	if service.Annotations == nil {
+		return 0, false
+	}
+	annotations := *service.Annotations
+
+	if enabled, _ := strconv.ParseBool(annotations[ScaleToZeroAnnotation]); !enabled {
+		return 0, false
+	}
+
+	value, ok := annotations[ScaleToZeroDurationAnnotation]
+	if !ok {
+		return DefaultScaleToZeroDuration, true
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Printf("[Idler] function=%s has an invalid %s annotation: %q", service.Name, ScaleToZeroDurationAnnotation, value)
+		return 0, false
+	}
+	return duration, true
+}
@@ -0,0 +1,146 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package scaling
+
+import (
+	"testing"
+	"time"
+
+	types "github.com/openfaas/faas-provider/types"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// recordingServiceQuery records the functions scaled with SetReplicas and
+// their replica counts
+type recordingServiceQuery struct {
+	scaled   []string
+	replicas []uint64
+}
+
+func (r *recordingServiceQuery) GetReplicas(service, namespace string) (ServiceQueryResponse, error) {
+	return ServiceQueryResponse{}, nil
+}
+
+func (r *recordingServiceQuery) SetReplicas(service, namespace string, count uint64) error {
+	r.scaled = append(r.scaled, service+"."+namespace)
+	r.replicas = append(r.replicas, count)
+	return nil
+}
+
+func newTestDecisions() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{Name: "decisions"}, []string{"function_name", "result"})
+}
+
+func counterValue(c prometheus.Counter) float64 {
+	m := &dto.Metric{}
+	c.Write(m)
+	return m.GetCounter().GetValue()
+}
+
+func idleService(name, namespace string, replicas uint64, annotations map[string]string) types.FunctionStatus {
+	return types.FunctionStatus{Name: name, Namespace: namespace, Replicas: replicas, Annotations: &annotations}
+}
+
+func Test_Idler_ScalesIdleFunctions(t *testing.T) {
+	query := &recordingServiceQuery{}
+	decisions := newTestDecisions()
+	idler := NewIdler(IdlerConfig{DefaultNamespace: "openfaas-fn"}, query, decisions)
+
+	optIn := map[string]string{ScaleToZeroAnnotation: "true", ScaleToZeroDurationAnnotation: "1m"}
+	services := []types.FunctionStatus{
+		idleService("idle", "openfaas-fn", 1, optIn),
+		idleService("busy", "openfaas-fn", 1, optIn),
+		idleService("recent", "openfaas-fn", 1, optIn),
+		idleService("zero", "openfaas-fn", 0, optIn),
+		idleService("opted-out", "openfaas-fn", 1, map[string]string{}),
+		idleService("default-duration", "", 1, map[string]string{ScaleToZeroAnnotation: "true"}),
+	}
+
+	idler.Started("busy", "openfaas-fn")
+	idler.Started("recent", "openfaas-fn")
+	idler.Completed("recent", "openfaas-fn", time.Millisecond)
+	now := time.Now()
+
+	idler.update(services, now.Add(time.Second*30))
+	if len(query.scaled) != 0 {
+		t.Fatalf("want nothing scaled within the idle duration, scaled: %v", query.scaled)
+	}
+
+	idler.update(services, now.Add(time.Minute*2))
+	if len(query.scaled) != 2 || query.scaled[0] != "idle.openfaas-fn" || query.scaled[1] != "recent.openfaas-fn" {
+		t.Errorf("want idle.openfaas-fn and recent.openfaas-fn scaled with busy.openfaas-fn in-flight, got: %v", query.scaled)
+	}
+
+	query.scaled = nil
+	services[0].Replicas = 0
+	services[2].Replicas = 0
+	idler.update(services, now.Add(DefaultScaleToZeroDuration+time.Minute))
+	if len(query.scaled) != 1 || query.scaled[0] != "default-duration.openfaas-fn" {
+		t.Errorf("want default-duration.openfaas-fn scaled after the default duration, got: %v", query.scaled)
+	}
+
+	if got := counterValue(decisions.WithLabelValues("idle.openfaas-fn", "scaled")); got != 1 {
+		t.Errorf("decisions want: %d, got: %f", 1, got)
+	}
+}
+
+func Test_Idler_DryRun(t *testing.T) {
+	query := &recordingServiceQuery{}
+	decisions := newTestDecisions()
+	idler := NewIdler(IdlerConfig{DryRun: true}, query, decisions)
+
+	services := []types.FunctionStatus{
+		idleService("idle", "openfaas-fn", 1, map[string]string{ScaleToZeroAnnotation: "true", ScaleToZeroDurationAnnotation: "1m"}),
+	}
+
+	now := time.Now().Add(time.Minute * 2)
+	idler.update(services, now)
+	idler.update(services, now.Add(time.Second*5))
+
+	if len(query.scaled) != 0 {
+		t.Errorf("want nothing scaled in dry-run, got: %v", query.scaled)
+	}
+	if got := counterValue(decisions.WithLabelValues("idle.openfaas-fn", "dry_run")); got != 1 {
+		t.Errorf("want one dry-run decision per idle duration, got: %f", got)
+	}
+}
+
+func Test_Idler_Namespaces(t *testing.T) {
+	query := &recordingServiceQuery{}
+	idler := NewIdler(IdlerConfig{Namespaces: []string{"staging"}}, query, newTestDecisions())
+
+	optIn := map[string]string{ScaleToZeroAnnotation: "true", ScaleToZeroDurationAnnotation: "1m"}
+	services := []types.FunctionStatus{
+		idleService("api", "staging", 1, optIn),
+		idleService("api", "production", 1, optIn),
+	}
+
+	idler.update(services, time.Now().Add(time.Minute*2))
+
+	if len(query.scaled) != 1 || query.scaled[0] != "api.staging" {
+		t.Errorf("want only api.staging scaled, got: %v", query.scaled)
+	}
+}
+
+func Test_Idler_KeepsWarmFunctions(t *testing.T) {
+	prewarmer := NewPrewarmer(PrewarmerConfig{Threshold: 1}, &recordingServiceQuery{})
+	prewarmer.functions["reports.openfaas-fn"] = &profiledFunction{name: "reports", namespace: "openfaas-fn", warm: true}
+
+	query := &recordingServiceQuery{}
+	idler := NewIdler(IdlerConfig{DefaultNamespace: "openfaas-fn", Prewarm: prewarmer}, query, newTestDecisions())
+
+	optIn := map[string]string{ScaleToZeroAnnotation: "true", ScaleToZeroDurationAnnotation: "1m"}
+	services := []types.FunctionStatus{
+		idleService("reports", "openfaas-fn", 1, optIn),
+		idleService("idle", "openfaas-fn", 1, optIn),
+	}
+	idler.update(services, time.Now().Add(time.Hour))
+
+	if len(query.scaled) != 1 || query.scaled[0] != "idle.openfaas-fn" {
+		t.Errorf("want only idle scaled to zero, got: %v", query.scaled)
+	}
+}
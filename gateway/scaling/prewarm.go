@@ -0,0 +1,370 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package scaling
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	types "github.com/openfaas/faas-provider/types"
+)
+
+const (
+	// PrewarmAnnotation opts a function into being scaled to its minimum
+	// replicas ahead of the hours in which invocations are predicted. The
+	// value is how far ahead i.e. "10m", or "true" for DefaultPrewarmLead.
+	PrewarmAnnotation = "com.openfaas.scale.prewarm"
+
+	// DefaultPrewarmLead is used when a function opts in without giving a
+	// duration
+	DefaultPrewarmLead = time.Minute * 10
+
+	// prewarmMinDays is how many days of history an hour needs before
+	// invocations are predicted for it
+	prewarmMinDays = 2
+
+	// prewarmWeight is the weight of the latest day in the average
+	// invocations of an hour, older days decay exponentially
+	prewarmWeight = 0.3
+
+	// prewarmMaxGap is the most hours folded into a profile at once, after
+	// a longer gap every hour has decayed towards zero anyway
+	prewarmMaxGap = 24 * 7
+)
+
+// PrewarmerConfig configures the Prewarmer
+type PrewarmerConfig struct {
+	// DefaultNamespace is used for functions listed without a namespace
+	DefaultNamespace string
+
+	// Ceiling caps the replicas of every function
+	Ceiling *ReplicaCeiling
+
+	// Events records each function scaled ahead of demand, it can be nil
+	Events *ScaleEventLog
+
+	// Threshold is the average invocations in an hour from which the hour
+	// is predicted to have demand
+	Threshold float64
+}
+
+// hourlyCount is the history of one hour of the day in a profile
+type hourlyCount struct {
+	// average is the invocations predicted for the next occurrence of the
+	// hour, and days the number of occurrences it was averaged over
+	average float64
+	days    int
+
+	// predicted, demand and actual are the prediction made for the last
+	// occurrence of the hour and the invocations seen in it
+	predicted float64
+	demand    bool
+	actual    uint64
+}
+
+// invocationProfile counts the invocations of a function in each hour of
+// the day, in UTC
+type invocationProfile struct {
+	hours [24]hourlyCount
+
+	// current is the start of the hour being counted
+	current time.Time
+	count   uint64
+}
+
+// advance folds the hours which have ended before now into the profile,
+// hours without invocations are folded in as zero
+func (p *invocationProfile) advance(now time.Time, threshold float64) {
+	hour := now.UTC().Truncate(time.Hour)
+	if p.current.IsZero() {
+		p.current = hour
+		return
+	}
+
+	for gap := 0; p.current.Before(hour) && gap < prewarmMaxGap; gap++ {
+		h := &p.hours[p.current.Hour()]
+		h.predicted, h.demand, h.actual = h.average, h.hasDemand(threshold), p.count
+
+		if h.days == 0 {
+			h.average = float64(p.count)
+		} else {
+			h.average += prewarmWeight * (float64(p.count) - h.average)
+		}
+		h.days++
+
+		p.count = 0
+		p.current = p.current.Add(time.Hour)
+	}
+	p.current = hour
+}
+
+// hasDemand is true when the hour has enough history and its average is
+// at or over threshold
+func (h hourlyCount) hasDemand(threshold float64) bool {
+	return h.days >= prewarmMinDays && h.average >= threshold
+}
+
+// profiledFunction is a function with an invocation profile
+type profiledFunction struct {
+	name      string
+	namespace string
+	profile   invocationProfile
+
+	// lead is how far ahead the function is warmed, zero when it has not
+	// opted in
+	lead time.Duration
+
+	// warm is true while demand is predicted within the lead, and warmed
+	// is the hour the function was last scaled up for
+	warm   bool
+	warmed time.Time
+}
+
+// Prewarmer keeps an hourly profile of the invocations of each function
+// through the gateway, counted like gateway_function_invocation_started.
+// Functions with the PrewarmAnnotation are scaled from zero to their
+// minimum replicas shortly before an hour in which invocations are
+// predicted, and are kept from being scaled to zero through Warm until the
+// hour has passed. The list of functions is passed to Update by the service
+// watcher and invocations are passed to Started.
+type Prewarmer struct {
+	config       PrewarmerConfig
+	serviceQuery ServiceQuery
+
+	functions map[string]*profiledFunction
+	lock      sync.Mutex
+}
+
+// NewPrewarmer creates a Prewarmer which scales functions through
+// serviceQuery
+func NewPrewarmer(config PrewarmerConfig, serviceQuery ServiceQuery) *Prewarmer {
+	return &Prewarmer{
+		config:       config,
+		serviceQuery: serviceQuery,
+		functions:    make(map[string]*profiledFunction),
+	}
+}
+
+// Started counts an invocation in the current hour of the function's profile
+func (p *Prewarmer) Started(functionName, namespace string) {
+	p.started(functionName, namespace, time.Now())
+}
+
+// Completed is a no-op, as invocations are counted when they start
+func (p *Prewarmer) Completed(functionName, namespace string, duration time.Duration) {
+}
+
+func (p *Prewarmer) started(functionName, namespace string, now time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	fn := p.get(functionName, namespace)
+	fn.profile.advance(now, p.config.Threshold)
+	fn.profile.count++
+}
+
+func (p *Prewarmer) get(functionName, namespace string) *profiledFunction {
+	key := functionName + "." + namespace
+	fn, ok := p.functions[key]
+	if !ok {
+		fn = &profiledFunction{name: functionName, namespace: namespace}
+		p.functions[key] = fn
+	}
+	return fn
+}
+
+// Warm is true when demand is predicted for a function which has opted
+// in, within its lead or in the current hour, and false when the
+// Prewarmer is nil
+func (p *Prewarmer) Warm(functionName, namespace string) bool {
+	if p == nil {
+		return false
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	fn, ok := p.functions[functionName+"."+namespace]
+	return ok && fn.warm
+}
+
+// Update scales the functions in services which have no replicas and
+// demand predicted within their lead
+func (p *Prewarmer) Update(services []types.FunctionStatus) {
+	p.update(services, time.Now())
+}
+
+func (p *Prewarmer) update(services []types.FunctionStatus, now time.Time) {
+	decisions := []scaleDecision{}
+	listed := make(map[string]bool, len(services))
+
+	p.lock.Lock()
+	for _, service := range services {
+		namespace := service.Namespace
+		if len(namespace) == 0 {
+			namespace = p.config.DefaultNamespace
+		}
+		listed[service.Name+"."+namespace] = true
+
+		fn := p.get(service.Name, namespace)
+		fn.profile.advance(now, p.config.Threshold)
+		fn.lead = prewarmLead(service)
+		if fn.lead == 0 {
+			fn.warm = false
+			continue
+		}
+
+		// The function is scaled up for the hour reached within the lead,
+		// and kept warm until the end of an hour with demand
+		upcoming := now.Add(fn.lead).UTC().Truncate(time.Hour)
+		demand := fn.profile.hours[upcoming.Hour()].hasDemand(p.config.Threshold)
+		fn.warm = demand || fn.profile.hours[now.UTC().Hour()].hasDemand(p.config.Threshold)
+		if !demand || service.Replicas > 0 || fn.warmed.Equal(upcoming) {
+			continue
+		}
+
+		// Warmed once for each hour, so a function scaled to zero by
+		// other means is left at zero until the next hour
+		fn.warmed = upcoming
+
+		ceiling, _ := p.config.Ceiling.Max(namespace)
+		minReplicas, _ := replicaRange(service, ceiling)
+		decisions = append(decisions, scaleDecision{name: service.Name, namespace: namespace, current: service.Replicas, replicas: minReplicas})
+	}
+
+	// Forget functions which have been removed
+	for key := range p.functions {
+		if !listed[key] {
+			delete(p.functions, key)
+		}
+	}
+	p.lock.Unlock()
+
+	for _, d := range decisions {
+		log.Printf("[Prewarm] function=%s.%s %d => %d ahead of predicted invocations", d.name, d.namespace, d.current, d.replicas)
+		if err := d.apply(p.serviceQuery, p.config.Events, TriggerPrewarm); err != nil {
+			log.Printf("[Prewarm] function=%s.%s unable to scale: %s", d.name, d.namespace, err)
+		}
+	}
+}
+
+// prewarmLead reads how far ahead of predicted demand a function is
+// warmed, zero when it has not opted in
+func prewarmLead(service types.FunctionStatus) time.Duration {
+	value, ok := functionSetting(service, PrewarmAnnotation)
+	if !ok {
+		return 0
+	}
+
+	if enabled, err := strconv.ParseBool(value); err == nil {
+		if enabled {
+			return DefaultPrewarmLead
+		}
+		return 0
+	}
+
+	lead, err := time.ParseDuration(value)
+	if err != nil || lead <= 0 {
+		log.Printf("[Prewarm] function=%s has an invalid %s annotation: %q", service.Name, PrewarmAnnotation, value)
+		return 0
+	}
+	return lead
+}
+
+// HourlyPrediction compares the invocations predicted for an hour with the
+// invocations seen in it
+type HourlyPrediction struct {
+	Hour time.Time `json:"hour"`
+
+	// Predicted is the average invocations expected, and Demand is true
+	// when it was enough to warm the function
+	Predicted float64 `json:"predicted"`
+	Demand    bool    `json:"demand"`
+
+	// Actual is the invocations through the gateway, so far for the
+	// current hour
+	Actual uint64 `json:"actual"`
+}
+
+// FunctionPrediction is the invocation profile of a function, as returned
+// by the API
+type FunctionPrediction struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+
+	// Prewarm is true when the function has opted in with the lead given
+	// in LeadSeconds, and Warm while demand is predicted
+	Prewarm     bool    `json:"prewarm"`
+	LeadSeconds float64 `json:"leadSeconds,omitempty"`
+	Warm        bool    `json:"warm"`
+
+	// Hours are the last 24 hours, ending with the current hour
+	Hours []HourlyPrediction `json:"hours"`
+}
+
+// Predictions lists the profiles of functions in namespace, or in all
+// namespaces when namespace is empty
+func (p *Prewarmer) Predictions(namespace string, now time.Time) []FunctionPrediction {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	predictions := []FunctionPrediction{}
+	for _, fn := range p.functions {
+		if len(namespace) > 0 && fn.namespace != namespace {
+			continue
+		}
+
+		fn.profile.advance(now, p.config.Threshold)
+
+		prediction := FunctionPrediction{
+			Name:        fn.name,
+			Namespace:   fn.namespace,
+			Prewarm:     fn.lead > 0,
+			LeadSeconds: fn.lead.Seconds(),
+			Warm:        fn.warm,
+			Hours:       make([]HourlyPrediction, 0, 24),
+		}
+
+		for ago := 23; ago >= 0; ago-- {
+			hour := fn.profile.current.Add(-time.Duration(ago) * time.Hour)
+			h := fn.profile.hours[hour.Hour()]
+
+			if ago == 0 {
+				prediction.Hours = append(prediction.Hours, HourlyPrediction{Hour: hour, Predicted: h.average, Demand: h.hasDemand(p.config.Threshold), Actual: fn.profile.count})
+			} else {
+				prediction.Hours = append(prediction.Hours, HourlyPrediction{Hour: hour, Predicted: h.predicted, Demand: h.demand, Actual: h.actual})
+			}
+		}
+
+		predictions = append(predictions, prediction)
+	}
+
+	sort.Slice(predictions, func(i, j int) bool {
+		if predictions[i].Namespace != predictions[j].Namespace {
+			return predictions[i].Namespace < predictions[j].Namespace
+		}
+		return predictions[i].Name < predictions[j].Name
+	})
+	return predictions
+}
+
+// MakePredictionReportHandler lists the invocations predicted for each hour
+// and the invocations seen, the namespace query parameter limits the list
+// to one namespace
+func MakePredictionReportHandler(prewarmer *Prewarmer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		predictions := prewarmer.Predictions(r.URL.Query().Get("namespace"), time.Now())
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(predictions)
+	}
+}
@@ -0,0 +1,178 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package scaling
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	types "github.com/openfaas/faas-provider/types"
+)
+
+func prewarmService(name string, replicas uint64, prewarm string) types.FunctionStatus {
+	annotations := map[string]string{}
+	if len(prewarm) > 0 {
+		annotations[PrewarmAnnotation] = prewarm
+	}
+
+	return types.FunctionStatus{
+		Name:        name,
+		Namespace:   "openfaas-fn",
+		Replicas:    replicas,
+		Labels:      &map[string]string{MinScaleLabel: "2"},
+		Annotations: &annotations,
+	}
+}
+
+// invokeDaily invokes each function five times at 09:10 UTC on the given
+// number of days, starting from day
+func invokeDaily(prewarmer *Prewarmer, day time.Time, days int, functions ...string) {
+	for d := 0; d < days; d++ {
+		for _, fn := range functions {
+			for i := 0; i < 5; i++ {
+				prewarmer.started(fn, "openfaas-fn", day.AddDate(0, 0, d).Add(time.Hour*9+time.Minute*10))
+			}
+		}
+	}
+}
+
+func Test_Prewarmer_ScalesAheadOfDemand(t *testing.T) {
+	query := &recordingServiceQuery{}
+	prewarmer := NewPrewarmer(PrewarmerConfig{Ceiling: NewReplicaCeiling(20, nil, 0), Threshold: 1}, query)
+
+	services := []types.FunctionStatus{
+		prewarmService("reports", 0, "30m"),
+		prewarmService("default-lead", 0, "true"),
+		prewarmService("opted-out", 0, ""),
+		prewarmService("invalid", 0, "soon"),
+	}
+	names := []string{"reports", "default-lead", "opted-out", "invalid"}
+
+	day := time.Date(2024, time.March, 11, 0, 0, 0, 0, time.UTC)
+	prewarmer.update(services, day)
+
+	// One day of history is not enough to predict the hour
+	invokeDaily(prewarmer, day, 1, names...)
+	prewarmer.update(services, day.AddDate(0, 0, 1).Add(time.Hour*8+time.Minute*35))
+	if len(query.scaled) != 0 {
+		t.Fatalf("want no function scaled after one day, got: %v", query.scaled)
+	}
+
+	invokeDaily(prewarmer, day.AddDate(0, 0, 1), 1, names...)
+
+	// 30 minutes ahead of 09:00 on the third day
+	morning := day.AddDate(0, 0, 2).Add(time.Hour*8 + time.Minute*35)
+	prewarmer.update(services, morning)
+	if len(query.scaled) != 1 || query.scaled[0] != "reports.openfaas-fn" || query.replicas[0] != 2 {
+		t.Fatalf("want reports scaled to its minimum of 2, got: %v %v", query.scaled, query.replicas)
+	}
+	if !prewarmer.Warm("reports", "openfaas-fn") || prewarmer.Warm("opted-out", "openfaas-fn") {
+		t.Errorf("want only reports warm")
+	}
+
+	// reports is still at zero but is not scaled again for the same hour,
+	// while default-lead is now within its lead
+	prewarmer.update(services, morning.Add(time.Minute*20))
+	if len(query.scaled) != 2 || query.scaled[1] != "default-lead.openfaas-fn" {
+		t.Fatalf("want default-lead scaled 10 minutes ahead, got: %v", query.scaled)
+	}
+
+	if prewarmer.update(services, morning.Add(time.Hour)); !prewarmer.Warm("reports", "openfaas-fn") {
+		t.Errorf("want reports warm during the predicted hour")
+	}
+	if prewarmer.update(services, morning.Add(time.Hour*2)); prewarmer.Warm("reports", "openfaas-fn") {
+		t.Errorf("want reports no longer warm after the predicted hour")
+	}
+	if len(query.scaled) != 2 {
+		t.Errorf("want no more functions scaled, got: %v", query.scaled)
+	}
+}
+
+func Test_Prewarmer_NilIsCold(t *testing.T) {
+	var prewarmer *Prewarmer
+	if prewarmer.Warm("reports", "openfaas-fn") {
+		t.Errorf("want a nil prewarmer to keep no function warm")
+	}
+}
+
+func Test_invocationProfile_Average(t *testing.T) {
+	profile := invocationProfile{}
+	day := time.Date(2024, time.March, 11, 0, 0, 0, 0, time.UTC)
+
+	profile.advance(day, 1)
+	for d, count := range []uint64{10, 0, 20} {
+		profile.advance(day.AddDate(0, 0, d).Add(time.Hour*9), 1)
+		profile.count += count
+	}
+	profile.advance(day.AddDate(0, 0, 3), 1)
+
+	// 10, then 10 + 0.3 * (0 - 10), then 7 + 0.3 * (20 - 7)
+	h := profile.hours[9]
+	if h.days != 3 || h.average < 10.89 || h.average > 10.91 {
+		t.Errorf("want an average of 10.9 over 3 days, got: %f over %d", h.average, h.days)
+	}
+	if h.predicted != 7 || !h.demand || h.actual != 20 {
+		t.Errorf("want 7 predicted with demand and 20 seen, got: %f %t %d", h.predicted, h.demand, h.actual)
+	}
+	if profile.hours[10].average != 0 || profile.hours[10].days != 3 {
+		t.Errorf("want hours without invocations folded in as zero, got: %+v", profile.hours[10])
+	}
+}
+
+func Test_MakePredictionReportHandler(t *testing.T) {
+	prewarmer := NewPrewarmer(PrewarmerConfig{Ceiling: NewReplicaCeiling(20, nil, 0), Threshold: 1}, &recordingServiceQuery{})
+
+	now := time.Now().UTC()
+	day := now.Truncate(time.Hour).Add(-time.Hour * 24 * 3)
+	services := []types.FunctionStatus{prewarmService("reports", 1, "true")}
+	prewarmer.update(services, day)
+
+	for h := 0; h < 24*3; h++ {
+		prewarmer.started("reports", "openfaas-fn", day.Add(time.Hour*time.Duration(h)+time.Minute))
+	}
+	prewarmer.started("reports", "openfaas-fn", now)
+	prewarmer.started("reports", "openfaas-fn", now)
+
+	rec := httptest.NewRecorder()
+	MakePredictionReportHandler(prewarmer).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/system/scale-predictions?namespace=openfaas-fn", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status want: %d, got: %d", http.StatusOK, rec.Code)
+	}
+
+	predictions := []FunctionPrediction{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &predictions); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(predictions) != 1 || !predictions[0].Prewarm || predictions[0].LeadSeconds != DefaultPrewarmLead.Seconds() {
+		t.Fatalf("want reports listed with the default lead, got: %+v", predictions)
+	}
+
+	hours := predictions[0].Hours
+	if len(hours) != 24 {
+		t.Fatalf("want 24 hours, got: %d", len(hours))
+	}
+
+	previous := hours[22]
+	if previous.Predicted != 1 || !previous.Demand || previous.Actual != 1 {
+		t.Errorf("want 1 invocation predicted and seen in the previous hour, got: %+v", previous)
+	}
+
+	current := hours[23]
+	if !current.Hour.Equal(now.Truncate(time.Hour)) || current.Actual != 2 || !current.Demand {
+		t.Errorf("want 2 invocations so far in the current hour, got: %+v", current)
+	}
+
+	rec = httptest.NewRecorder()
+	MakePredictionReportHandler(prewarmer).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/system/scale-predictions?namespace=other", nil))
+	if body := rec.Body.String(); body != "[]\n" {
+		t.Errorf("want an empty list for another namespace, got: %s", body)
+	}
+}
@@ -3,9 +3,14 @@ package scaling
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/openfaas/faas-provider/types"
 )
 
@@ -13,7 +18,8 @@ const (
 	// DefaultMinReplicas is the minimal amount of replicas for a service.
 	DefaultMinReplicas = 1
 
-	// DefaultMaxReplicas is the amount of replicas a service will auto-scale up to.
+	// DefaultMaxReplicas is the default ReplicaCeiling, which caps functions
+	// with or without the com.openfaas.scale.max label.
 	DefaultMaxReplicas = 5
 
 	// DefaultScalingFactor is the defining proportion for the scaling increments.
@@ -31,7 +37,22 @@ const (
 	ScalingFactorLabel = "com.openfaas.scale.factor"
 )
 
-func MakeHorizontalScalingHandler(next http.HandlerFunc) http.HandlerFunc {
+// ScaleResult is returned by /system/scale-function when the requested
+// replicas were changed to fit the function's range or a ceiling
+type ScaleResult struct {
+	ServiceName string `json:"serviceName"`
+	Namespace   string `json:"namespace"`
+	Requested   uint64 `json:"requested"`
+	Replicas    uint64 `json:"replicas"`
+	Reason      string `json:"reason"`
+}
+
+// MakeHorizontalScalingHandler keeps the replicas of a scale request between
+// the function's com.openfaas.scale.min and com.openfaas.scale.max labels
+// and below the ReplicaCeiling, a request for 0 replicas scales the function
+// to zero. When the replicas were changed, a successful response has a
+// ScaleResult body explaining why. Each request is recorded in events.
+func MakeHorizontalScalingHandler(next http.HandlerFunc, functionQuery FunctionQuery, ceiling *ReplicaCeiling, events *ScaleEventLog, defaultNamespace string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Only POST is allowed", http.StatusMethodNotAllowed)
@@ -55,18 +76,128 @@ func MakeHorizontalScalingHandler(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		if scaleRequest.Replicas < 1 {
-			scaleRequest.Replicas = 1
+		functionName := scaleRequest.ServiceName
+		if len(functionName) == 0 {
+			functionName = mux.Vars(r)["name"]
+		}
+		namespace := scaleRequest.Namespace
+		if len(namespace) == 0 {
+			namespace = r.URL.Query().Get("namespace")
+		}
+		if len(namespace) == 0 {
+			namespace = defaultNamespace
+		}
+
+		result := ScaleResult{
+			ServiceName: functionName,
+			Namespace:   namespace,
+			Requested:   scaleRequest.Replicas,
+			Replicas:    scaleRequest.Replicas,
 		}
+		function, err := functionQuery.Get(functionName, namespace)
+		found := err == nil
+		clampReplicas(&result, function, found, ceiling)
 
-		if scaleRequest.Replicas > DefaultMaxReplicas {
-			scaleRequest.Replicas = DefaultMaxReplicas
+		if result.Replicas != result.Requested {
+			log.Printf("[Scale] function=%s.%s %d => %d replicas, %s", functionName, namespace, result.Requested, result.Replicas, result.Reason)
 		}
 
+		scaleRequest.Replicas = result.Replicas
 		upstreamReq, _ := json.Marshal(scaleRequest)
 		// Restore the io.ReadCloser to its original state
 		r.Body = io.NopCloser(bytes.NewBuffer(upstreamReq))
+		r.ContentLength = int64(len(upstreamReq))
+
+		start := time.Now()
+		rec := &scaleRecorder{header: w.Header()}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		failed := rec.status < http.StatusOK || rec.status >= http.StatusMultipleChoices
+
+		event := ScaleEvent{
+			Name:            functionName,
+			Namespace:       namespace,
+			Trigger:         TriggerAPI,
+			From:            function.Replicas,
+			To:              result.Replicas,
+			DurationSeconds: time.Since(start).Seconds(),
+		}
+		if failed {
+			event.Error = fmt.Sprintf("provider responded with %d: %s", rec.status, strings.TrimSpace(rec.body.String()))
+		}
+		events.Record(event)
+
+		if failed || result.Replicas == result.Requested {
+			w.WriteHeader(rec.status)
+			w.Write(rec.body.Bytes())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Del("Content-Length")
+		w.WriteHeader(rec.status)
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// clampReplicas changes the replicas of result to fit the function's range
+// and the ceiling, and gives the reason
+func clampReplicas(result *ScaleResult, function ServiceQueryResponse, found bool, ceiling *ReplicaCeiling) {
+	if result.Replicas == 0 {
+		return
+	}
+
+	minReplicas := uint64(DefaultMinReplicas)
+	maxReplicas := uint64(0)
+	if found {
+		minReplicas = function.MinReplicas
+		maxReplicas = function.MaxReplicas
+	}
+
+	if result.Replicas < minReplicas {
+		result.Replicas = minReplicas
+		result.Reason = fmt.Sprintf("raised to the function's minimum of %d replicas from %s", minReplicas, MinScaleLabel)
+	}
+
+	if maxReplicas > 0 && result.Replicas > maxReplicas {
+		result.Replicas = maxReplicas
+		result.Reason = fmt.Sprintf("capped at the function's maximum of %d replicas from %s", maxReplicas, MaxScaleLabel)
+	}
+
+	if limit, fromNamespace := ceiling.Max(result.Namespace); result.Replicas > limit {
+		result.Replicas = limit
+		if fromNamespace {
+			result.Reason = fmt.Sprintf("capped at the maximum of %d replicas for namespace %s", limit, result.Namespace)
+		} else {
+			result.Reason = fmt.Sprintf("capped at the gateway's maximum of %d replicas", limit)
+		}
+	}
+}
+
+// scaleRecorder holds the provider's response so that it can be replaced
+// with a ScaleResult
+type scaleRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (s *scaleRecorder) Header() http.Header {
+	return s.header
+}
+
+func (s *scaleRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+}
 
-		next.ServeHTTP(w, r)
+func (s *scaleRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
 	}
+	return s.body.Write(b)
 }
@@ -0,0 +1,159 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package scaling
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	types "github.com/openfaas/faas-provider/types"
+)
+
+type fakeFunctionQuery struct {
+	response ServiceQueryResponse
+	err      error
+}
+
+func (f fakeFunctionQuery) Get(name, namespace string) (ServiceQueryResponse, error) {
+	return f.response, f.err
+}
+
+func (f fakeFunctionQuery) GetAnnotations(name, namespace string) (map[string]string, error) {
+	return map[string]string{}, f.err
+}
+
+// fakeNamespaceQuery gives namespaces their annotations and counts queries
+type fakeNamespaceQuery struct {
+	annotations map[string]map[string]string
+	queries     int
+}
+
+func (f *fakeNamespaceQuery) GetNamespace(namespace string) (types.FunctionNamespace, error) {
+	f.queries++
+	return types.FunctionNamespace{Name: namespace, Annotations: f.annotations[namespace]}, nil
+}
+
+func Test_ReplicaCeiling_Namespaces(t *testing.T) {
+	query := &fakeNamespaceQuery{annotations: map[string]map[string]string{
+		"staging": {MaxScaleLabel: "3"},
+		"big":     {MaxScaleLabel: "100"},
+	}}
+	ceiling := NewReplicaCeiling(20, query, time.Minute)
+
+	scenarios := []struct {
+		namespace     string
+		want          uint64
+		fromNamespace bool
+	}{
+		{namespace: "staging", want: 3, fromNamespace: true},
+		{namespace: "big", want: 20},
+		{namespace: "openfaas-fn", want: 20},
+		{namespace: "staging", want: 3, fromNamespace: true},
+	}
+
+	for _, s := range scenarios {
+		got, fromNamespace := ceiling.Max(s.namespace)
+		if got != s.want || fromNamespace != s.fromNamespace {
+			t.Errorf("%s want: %d %t, got: %d %t", s.namespace, s.want, s.fromNamespace, got, fromNamespace)
+		}
+	}
+
+	if query.queries != 3 {
+		t.Errorf("want namespaces cached, queries: %d", query.queries)
+	}
+}
+
+func Test_MakeHorizontalScalingHandler(t *testing.T) {
+	scenarios := []struct {
+		name         string
+		requested    uint64
+		function     ServiceQueryResponse
+		namespaceMax string
+		want         uint64
+		wantReason   string
+	}{
+		{name: "within range", requested: 4, function: ServiceQueryResponse{MinReplicas: 1, MaxReplicas: 10}, want: 4},
+		{name: "scale to zero", requested: 0, function: ServiceQueryResponse{MinReplicas: 1, MaxReplicas: 10}, want: 0},
+		{name: "below minimum", requested: 1, function: ServiceQueryResponse{MinReplicas: 2, MaxReplicas: 10}, want: 2, wantReason: "minimum of 2"},
+		{name: "above function maximum", requested: 12, function: ServiceQueryResponse{MinReplicas: 1, MaxReplicas: 10}, want: 10, wantReason: "function's maximum of 10"},
+		{name: "no function maximum", requested: 15, function: ServiceQueryResponse{MinReplicas: 1}, want: 15},
+		{name: "no function maximum above gateway ceiling", requested: 40, function: ServiceQueryResponse{MinReplicas: 1}, want: 20, wantReason: "gateway's maximum of 20"},
+		{name: "above gateway ceiling", requested: 40, function: ServiceQueryResponse{MinReplicas: 1, MaxReplicas: 50}, want: 20, wantReason: "gateway's maximum of 20"},
+		{name: "above namespace ceiling", requested: 8, function: ServiceQueryResponse{MinReplicas: 1, MaxReplicas: 50}, namespaceMax: "6", want: 6, wantReason: "namespace openfaas-fn"},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			namespaces := &fakeNamespaceQuery{annotations: map[string]map[string]string{
+				"openfaas-fn": {MaxScaleLabel: s.namespaceMax},
+			}}
+			ceiling := NewReplicaCeiling(20, namespaces, time.Minute)
+
+			var upstream types.ScaleServiceRequest
+			next := func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				json.Unmarshal(body, &upstream)
+				w.WriteHeader(http.StatusAccepted)
+			}
+
+			handler := MakeHorizontalScalingHandler(next, fakeFunctionQuery{response: s.function}, ceiling, nil, "openfaas-fn")
+
+			body := fmt.Sprintf(`{"serviceName":"api","replicas":%d}`, s.requested)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/system/scale-function/api", strings.NewReader(body)))
+
+			if rec.Code != http.StatusAccepted {
+				t.Fatalf("status want: %d, got: %d", http.StatusAccepted, rec.Code)
+			}
+			if upstream.Replicas != s.want {
+				t.Errorf("replicas want: %d, got: %d", s.want, upstream.Replicas)
+			}
+
+			if len(s.wantReason) == 0 {
+				if rec.Body.Len() > 0 {
+					t.Errorf("want the provider's empty body, got: %q", rec.Body.String())
+				}
+				return
+			}
+
+			result := ScaleResult{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+				t.Fatalf("want a ScaleResult body, got: %q", rec.Body.String())
+			}
+			if result.Requested != s.requested || result.Replicas != s.want || !strings.Contains(result.Reason, s.wantReason) {
+				t.Errorf("want %d => %d with reason containing %q, got: %+v", s.requested, s.want, s.wantReason, result)
+			}
+		})
+	}
+}
+
+func Test_MakeHorizontalScalingHandler_ProviderError(t *testing.T) {
+	next := func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "function not found", http.StatusNotFound)
+	}
+
+	ceiling := NewReplicaCeiling(5, nil, 0)
+	events := NewScaleEventLog(10)
+	handler := MakeHorizontalScalingHandler(next, fakeFunctionQuery{err: fmt.Errorf("not found")}, ceiling, events, "openfaas-fn")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/system/scale-function/api", strings.NewReader(`{"serviceName":"api","replicas":10}`)))
+
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "function not found") {
+		t.Errorf("want the provider's error passed through, got: %d %q", rec.Code, rec.Body.String())
+	}
+
+	recorded := events.Events("api", "openfaas-fn")
+	if len(recorded) != 1 || recorded[0].Trigger != TriggerAPI || !strings.Contains(recorded[0].Error, "404") {
+		t.Errorf("want the failed request recorded, got: %+v", recorded)
+	}
+}
@@ -0,0 +1,115 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package scaling
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openfaas/faas/gateway/pkg/middleware"
+)
+
+const (
+	// ReadinessProbeAnnotation opts a function into being probed after it
+	// is scaled from zero, before requests are released to it. The value is
+	// the path to probe i.e. "/_/health", or "true" for the path in the
+	// HealthPathAnnotation.
+	ReadinessProbeAnnotation = "com.openfaas.coldstart.probe"
+
+	// HealthPathAnnotation is the health path of a function, as used by
+	// the provider for its own probes
+	HealthPathAnnotation = "com.openfaas.health.http.path"
+
+	// DefaultHealthPath is the health path of the watchdog
+	DefaultHealthPath = "/_/health"
+)
+
+// ReadinessProbe checks that a function accepts requests once the provider
+// reports one of its replicas as available
+type ReadinessProbe interface {
+	Ready(functionName, namespace string) bool
+}
+
+// HTTPReadinessProbe sends a GET request to the health path of functions
+// with the ReadinessProbeAnnotation, built with BaseURLResolver.BuildURL. A
+// function is ready when the probe responds with a 2xx status, functions
+// without the annotation are always ready.
+type HTTPReadinessProbe struct {
+	resolver        middleware.BaseURLResolver
+	functionQuery   FunctionQuery
+	directFunctions bool
+	client          *http.Client
+}
+
+// NewHTTPReadinessProbe creates an HTTPReadinessProbe which reads the
+// annotations of functions with functionQuery, and gives up on each probe
+// after timeout
+func NewHTTPReadinessProbe(resolver middleware.BaseURLResolver, functionQuery FunctionQuery, directFunctions bool, timeout time.Duration) *HTTPReadinessProbe {
+	return &HTTPReadinessProbe{
+		resolver:        resolver,
+		functionQuery:   functionQuery,
+		directFunctions: directFunctions,
+		client:          &http.Client{Timeout: timeout},
+	}
+}
+
+// Ready probes the health path of the function
+func (p *HTTPReadinessProbe) Ready(functionName, namespace string) bool {
+	healthPath, ok := p.healthPath(functionName, namespace)
+	if !ok {
+		return true
+	}
+
+	probeURL := p.resolver.BuildURL(functionName, namespace, healthPath, p.directFunctions)
+
+	res, err := p.client.Get(probeURL)
+	if err != nil {
+		log.Printf("[Probe] function=%s.%s not ready: %s", functionName, namespace, err)
+		return false
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		log.Printf("[Probe] function=%s.%s not ready: %s returned %d", functionName, namespace, healthPath, res.StatusCode)
+		return false
+	}
+	return true
+}
+
+// healthPath reads the path to probe from the function's annotations, and
+// false when it has not opted in
+func (p *HTTPReadinessProbe) healthPath(functionName, namespace string) (string, bool) {
+	annotations, err := p.functionQuery.GetAnnotations(functionName, namespace)
+	if err != nil {
+		return "", false
+	}
+
+	value := strings.TrimSpace(annotations[ReadinessProbeAnnotation])
+	if len(value) == 0 {
+		return "", false
+	}
+
+	if strings.HasPrefix(value, "/") {
+		return value, true
+	}
+
+	if enabled, err := strconv.ParseBool(value); err != nil || !enabled {
+		if err != nil {
+			log.Printf("Function %s.%s has an invalid %s annotation: %q", functionName, namespace, ReadinessProbeAnnotation, value)
+		}
+		return "", false
+	}
+
+	if healthPath := annotations[HealthPathAnnotation]; len(healthPath) > 0 {
+		return healthPath, true
+	}
+	return DefaultHealthPath, true
+}
@@ -0,0 +1,70 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package scaling
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/pkg/middleware"
+)
+
+// annotationQuery gives every function the same annotations
+type annotationQuery struct {
+	annotations map[string]string
+}
+
+func (a annotationQuery) Get(name, namespace string) (ServiceQueryResponse, error) {
+	return ServiceQueryResponse{}, nil
+}
+
+func (a annotationQuery) GetAnnotations(name, namespace string) (map[string]string, error) {
+	return a.annotations, nil
+}
+
+func Test_HTTPReadinessProbe(t *testing.T) {
+	probed := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probed = r.URL.Path
+		if r.URL.Path == "/function/api.openfaas-fn/ready" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	resolver := middleware.SingleHostBaseURLResolver{BaseURL: server.URL}
+
+	scenarios := []struct {
+		name        string
+		annotations map[string]string
+		want        bool
+		wantPath    string
+	}{
+		{name: "not opted in", annotations: map[string]string{}, want: true},
+		{name: "path", annotations: map[string]string{ReadinessProbeAnnotation: "/ready"}, want: true, wantPath: "/function/api.openfaas-fn/ready"},
+		{name: "default health path", annotations: map[string]string{ReadinessProbeAnnotation: "true"}, want: false, wantPath: "/function/api.openfaas-fn/_/health"},
+		{name: "provider health path", annotations: map[string]string{ReadinessProbeAnnotation: "true", HealthPathAnnotation: "/ready"}, want: true, wantPath: "/function/api.openfaas-fn/ready"},
+		{name: "disabled", annotations: map[string]string{ReadinessProbeAnnotation: "false"}, want: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			probed = ""
+			probe := NewHTTPReadinessProbe(resolver, annotationQuery{annotations: s.annotations}, false, time.Second)
+
+			if got := probe.Ready("api", "openfaas-fn"); got != s.want {
+				t.Errorf("ready want: %t, got: %t", s.want, got)
+			}
+			if probed != s.wantPath {
+				t.Errorf("probed path want: %q, got: %q", s.wantPath, probed)
+			}
+		})
+	}
+}
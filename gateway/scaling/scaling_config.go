@@ -1,6 +1,7 @@
 package scaling
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -22,4 +23,47 @@ type ScalingConfig struct {
 	// SetScaleRetries is the number of times to try scaling a function before
 	// giving up due to errors
 	SetScaleRetries uint
+
+	// ColdStartPolicy decides what happens to a request when no replica is
+	// ready after MaxPollCount queries
+	ColdStartPolicy ColdStartPolicy
+
+	// ColdStartMaxWait is how long a request can wait for a replica in total
+	// with the ColdStartWait policy
+	ColdStartMaxWait time.Duration
+
+	// Events records each scale from zero, it can be nil
+	Events *ScaleEventLog
+
+	// ReadinessProbe is checked after a function scaled from zero has an
+	// available replica and before requests are released to it, it can be
+	// nil
+	ReadinessProbe ReadinessProbe
+}
+
+// ColdStartPolicy decides what happens to a request when a function does
+// not become ready in time after scaling from zero
+type ColdStartPolicy string
+
+const (
+	// ColdStartReject responds with a 503 and a Retry-After header, earlier
+	// versions of the gateway responded with an empty 200
+	ColdStartReject ColdStartPolicy = "reject"
+
+	// ColdStartQueue responds with a 202 and invokes the function
+	// asynchronously once it is ready, it falls back to ColdStartReject
+	// when NATS is not configured
+	ColdStartQueue ColdStartPolicy = "queue"
+
+	// ColdStartWait keeps the request waiting up to ColdStartMaxWait
+	ColdStartWait ColdStartPolicy = "wait"
+)
+
+// ParseColdStartPolicy validates the name of a ColdStartPolicy
+func ParseColdStartPolicy(value string) (ColdStartPolicy, error) {
+	switch policy := ColdStartPolicy(value); policy {
+	case ColdStartReject, ColdStartQueue, ColdStartWait:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown cold start policy: %q, valid policies are: reject, queue and wait", value)
 }
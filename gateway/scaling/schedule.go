@@ -0,0 +1,358 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package scaling
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	types "github.com/openfaas/faas-provider/types"
+)
+
+const (
+	// ScheduleAnnotation is a function's replica schedule, a list of cron
+	// expressions each followed by the replicas to scale to, separated by
+	// semicolons i.e. "0 8 * * mon-fri 10; 0 18 * * mon-fri 0"
+	ScheduleAnnotation = "com.openfaas.scale.schedule"
+
+	// ScheduleTimezoneAnnotation is the time zone of the replica schedule
+	// i.e. "Europe/London". Default: UTC
+	ScheduleTimezoneAnnotation = "com.openfaas.scale.schedule.timezone"
+)
+
+// ReplicaSchedulerConfig configures the ReplicaScheduler
+type ReplicaSchedulerConfig struct {
+	// DefaultNamespace is used for functions listed without a namespace
+	DefaultNamespace string
+
+	// Ceiling caps the replicas of every function
+	Ceiling *ReplicaCeiling
+
+	// Events records each change of replicas, it can be nil
+	Events *ScaleEventLog
+}
+
+// scheduleEntry is a cron expression and the replicas to scale to when it
+// matches
+type scheduleEntry struct {
+	expression string
+	cron       cronSchedule
+	replicas   uint64
+}
+
+// replicaSchedule is the parsed replica schedule of a function
+type replicaSchedule struct {
+	entries  []scheduleEntry
+	location *time.Location
+}
+
+// parseReplicaSchedule parses the ScheduleAnnotation in the time zone
+// given by the ScheduleTimezoneAnnotation
+func parseReplicaSchedule(value, timezone string) (replicaSchedule, error) {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return replicaSchedule{}, fmt.Errorf("unknown time zone %q", timezone)
+	}
+
+	schedule := replicaSchedule{location: location}
+	for _, entry := range strings.Split(value, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != len(cronFields)+1 {
+			return replicaSchedule{}, fmt.Errorf("%q should be a cron expression followed by replicas", strings.TrimSpace(entry))
+		}
+
+		expression := strings.Join(fields[:len(cronFields)], " ")
+		cron, err := parseCron(expression)
+		if err != nil {
+			return replicaSchedule{}, err
+		}
+
+		replicas, err := strconv.ParseUint(fields[len(cronFields)], 10, 64)
+		if err != nil {
+			return replicaSchedule{}, fmt.Errorf("invalid replicas %q for %q", fields[len(cronFields)], expression)
+		}
+
+		schedule.entries = append(schedule.entries, scheduleEntry{expression: expression, cron: cron, replicas: replicas})
+	}
+
+	if len(schedule.entries) == 0 {
+		return replicaSchedule{}, fmt.Errorf("no entries")
+	}
+	return schedule, nil
+}
+
+// active gives the entry which matched most recently at or before now and
+// when it matched, the last entry wins when several match at once
+func (s replicaSchedule) active(now time.Time) (scheduleEntry, time.Time, bool) {
+	var active scheduleEntry
+	var since time.Time
+	for _, entry := range s.entries {
+		if t, ok := entry.cron.prev(now.In(s.location)); ok && !t.Before(since) {
+			active, since = entry, t
+		}
+	}
+	return active, since, !since.IsZero()
+}
+
+// next gives the entry which matches soonest after now and when
+func (s replicaSchedule) next(now time.Time) (scheduleEntry, time.Time, bool) {
+	var next scheduleEntry
+	var at time.Time
+	for _, entry := range s.entries {
+		if t, ok := entry.cron.next(now.In(s.location)); ok && (at.IsZero() || !t.After(at)) {
+			next, at = entry, t
+		}
+	}
+	return next, at, !at.IsZero()
+}
+
+// scheduledFunction is a function with a replica schedule
+type scheduledFunction struct {
+	name      string
+	namespace string
+
+	value    string
+	timezone string
+	schedule replicaSchedule
+	err      error
+
+	// active is the entry in effect, since is when it matched and
+	// replicas is its replica count within the function's range
+	active   scheduleEntry
+	since    time.Time
+	replicas uint64
+
+	// applied is the match which the function has been scaled for
+	applied time.Time
+}
+
+// ReplicaScheduler scales functions to the replicas given by their
+// ScheduleAnnotation. A function is only scaled when an entry of its
+// schedule matches, so the replicas can be changed by other means until the
+// next entry, while the replicas of the active entry replace the minimum
+// replicas of the function for the other scalers through Scheduled. The
+// list of functions is passed to Update by the service watcher.
+type ReplicaScheduler struct {
+	config       ReplicaSchedulerConfig
+	serviceQuery ServiceQuery
+
+	functions map[string]*scheduledFunction
+	lock      sync.Mutex
+}
+
+// NewReplicaScheduler creates a ReplicaScheduler which scales functions
+// through serviceQuery
+func NewReplicaScheduler(config ReplicaSchedulerConfig, serviceQuery ServiceQuery) *ReplicaScheduler {
+	return &ReplicaScheduler{
+		config:       config,
+		serviceQuery: serviceQuery,
+		functions:    make(map[string]*scheduledFunction),
+	}
+}
+
+// Scheduled gives the replicas a function is scheduled to have, and false
+// when it has no active schedule or the scheduler is nil
+func (s *ReplicaScheduler) Scheduled(functionName, namespace string) (uint64, bool) {
+	if s == nil {
+		return 0, false
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	fn, ok := s.functions[functionName+"."+namespace]
+	if !ok || fn.since.IsZero() {
+		return 0, false
+	}
+	return fn.replicas, true
+}
+
+// Update scales the functions in services whose schedule has matched since
+// they were last scaled by the ReplicaScheduler
+func (s *ReplicaScheduler) Update(services []types.FunctionStatus) {
+	s.update(services, time.Now())
+}
+
+// scheduledChange is a change of replicas for a function made at a match
+// of its schedule
+type scheduledChange struct {
+	key   string
+	since time.Time
+	scaleDecision
+}
+
+func (s *ReplicaScheduler) update(services []types.FunctionStatus, now time.Time) {
+	changes := []scheduledChange{}
+	listed := make(map[string]bool, len(services))
+
+	s.lock.Lock()
+	for _, service := range services {
+		value, ok := functionSetting(service, ScheduleAnnotation)
+		if !ok {
+			continue
+		}
+
+		namespace := service.Namespace
+		if len(namespace) == 0 {
+			namespace = s.config.DefaultNamespace
+		}
+		key := service.Name + "." + namespace
+		listed[key] = true
+
+		timezone, _ := functionSetting(service, ScheduleTimezoneAnnotation)
+
+		fn, ok := s.functions[key]
+		if !ok || fn.value != value || fn.timezone != timezone {
+			schedule, err := parseReplicaSchedule(value, timezone)
+			if err != nil {
+				log.Printf("[Scheduler] function=%s has an invalid %s annotation: %s", key, ScheduleAnnotation, err)
+			}
+
+			fn = &scheduledFunction{name: service.Name, namespace: namespace, value: value, timezone: timezone, schedule: schedule, err: err}
+			s.functions[key] = fn
+		}
+		if fn.err != nil {
+			continue
+		}
+
+		fn.active, fn.since, ok = fn.schedule.active(now)
+		if !ok {
+			continue
+		}
+
+		ceiling, _ := s.config.Ceiling.Max(namespace)
+		_, maxReplicas := replicaRange(service, ceiling)
+		fn.replicas = min(fn.active.replicas, maxReplicas)
+
+		if fn.applied.Equal(fn.since) {
+			continue
+		}
+
+		if fn.replicas == service.Replicas {
+			fn.applied = fn.since
+			continue
+		}
+
+		changes = append(changes, scheduledChange{
+			key:           key,
+			since:         fn.since,
+			scaleDecision: scaleDecision{name: service.Name, namespace: namespace, current: service.Replicas, replicas: fn.replicas},
+		})
+	}
+
+	// Forget functions which have been removed or no longer have a schedule
+	for key := range s.functions {
+		if !listed[key] {
+			delete(s.functions, key)
+		}
+	}
+	s.lock.Unlock()
+
+	for _, c := range changes {
+		log.Printf("[Scheduler] function=%s.%s %d => %d", c.name, c.namespace, c.current, c.replicas)
+		if err := c.apply(s.serviceQuery, s.config.Events, TriggerSchedule); err != nil {
+			log.Printf("[Scheduler] function=%s.%s unable to scale: %s", c.name, c.namespace, err)
+			continue
+		}
+
+		s.lock.Lock()
+		if fn, ok := s.functions[c.key]; ok {
+			fn.applied = c.since
+		}
+		s.lock.Unlock()
+	}
+}
+
+// FunctionSchedule is the replica schedule of a function and its active
+// entry, as returned by the API
+type FunctionSchedule struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Schedule  string `json:"schedule"`
+	Timezone  string `json:"timezone,omitempty"`
+
+	// Active is the cron expression of the entry in effect since Since
+	Active   string     `json:"active,omitempty"`
+	Replicas uint64     `json:"replicas"`
+	Since    *time.Time `json:"since,omitempty"`
+
+	// Next is when the schedule next matches and NextReplicas the
+	// replicas it scales to
+	Next         *time.Time `json:"next,omitempty"`
+	NextReplicas uint64     `json:"nextReplicas"`
+
+	// Error explains why an invalid schedule is ignored
+	Error string `json:"error,omitempty"`
+}
+
+// Schedules lists the functions with a replica schedule, in namespace or
+// in all namespaces when namespace is empty
+func (s *ReplicaScheduler) Schedules(namespace string, now time.Time) []FunctionSchedule {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	schedules := []FunctionSchedule{}
+	for _, fn := range s.functions {
+		if len(namespace) > 0 && fn.namespace != namespace {
+			continue
+		}
+
+		schedule := FunctionSchedule{
+			Name:      fn.name,
+			Namespace: fn.namespace,
+			Schedule:  fn.value,
+			Timezone:  fn.timezone,
+		}
+
+		if fn.err != nil {
+			schedule.Error = fn.err.Error()
+		} else {
+			if !fn.since.IsZero() {
+				since := fn.since
+				schedule.Active = fn.active.expression
+				schedule.Replicas = fn.replicas
+				schedule.Since = &since
+			}
+			if next, at, ok := fn.schedule.next(now); ok {
+				schedule.Next = &at
+				schedule.NextReplicas = next.replicas
+			}
+		}
+
+		schedules = append(schedules, schedule)
+	}
+
+	sort.Slice(schedules, func(i, j int) bool {
+		if schedules[i].Namespace != schedules[j].Namespace {
+			return schedules[i].Namespace < schedules[j].Namespace
+		}
+		return schedules[i].Name < schedules[j].Name
+	})
+	return schedules
+}
+
+// MakeReplicaScheduleHandler lists the replica schedules of functions and
+// the entry active for each, the namespace query parameter limits the list
+// to one namespace
+func MakeReplicaScheduleHandler(scheduler *ReplicaScheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		schedules := scheduler.Schedules(r.URL.Query().Get("namespace"), time.Now())
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(schedules)
+	}
+}
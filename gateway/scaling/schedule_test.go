@@ -0,0 +1,211 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Copyright (c) OpenFaaS Author(s). All rights reserved.
+
+package scaling
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	types "github.com/openfaas/faas-provider/types"
+)
+
+func Test_parseCron_Invalid(t *testing.T) {
+	expressions := []string{
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * * funday",
+	}
+
+	for _, expression := range expressions {
+		if _, err := parseCron(expression); err == nil {
+			t.Errorf("want error for %q", expression)
+		}
+	}
+}
+
+func Test_cronSchedule_PrevNext(t *testing.T) {
+	// Wednesday
+	now := time.Date(2024, time.March, 13, 12, 30, 15, 0, time.UTC)
+
+	scenarios := []struct {
+		expression string
+		prev       time.Time
+		next       time.Time
+	}{
+		{
+			expression: "0 8 * * mon-fri",
+			prev:       time.Date(2024, time.March, 13, 8, 0, 0, 0, time.UTC),
+			next:       time.Date(2024, time.March, 14, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			expression: "*/15 * * * *",
+			prev:       time.Date(2024, time.March, 13, 12, 30, 0, 0, time.UTC),
+			next:       time.Date(2024, time.March, 13, 12, 45, 0, 0, time.UTC),
+		},
+		{
+			expression: "0 0 * * sat,sun",
+			prev:       time.Date(2024, time.March, 10, 0, 0, 0, 0, time.UTC),
+			next:       time.Date(2024, time.March, 16, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			expression: "0 6 1 jan *",
+			prev:       time.Date(2024, time.January, 1, 6, 0, 0, 0, time.UTC),
+			next:       time.Date(2025, time.January, 1, 6, 0, 0, 0, time.UTC),
+		},
+		{
+			// Either day field matches when both are given
+			expression: "0 0 15 * 0",
+			prev:       time.Date(2024, time.March, 10, 0, 0, 0, 0, time.UTC),
+			next:       time.Date(2024, time.March, 15, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, s := range scenarios {
+		cron, err := parseCron(s.expression)
+		if err != nil {
+			t.Fatalf("%s: %s", s.expression, err)
+		}
+
+		if prev, ok := cron.prev(now); !ok || !prev.Equal(s.prev) {
+			t.Errorf("%s prev want: %s, got: %s", s.expression, s.prev, prev)
+		}
+		if next, ok := cron.next(now); !ok || !next.Equal(s.next) {
+			t.Errorf("%s next want: %s, got: %s", s.expression, s.next, next)
+		}
+	}
+
+	never, _ := parseCron("0 0 30 2 *")
+	if _, ok := never.prev(now); ok {
+		t.Errorf("want no match for 30 February")
+	}
+}
+
+func Test_parseReplicaSchedule_Timezone(t *testing.T) {
+	schedule, err := parseReplicaSchedule("0 8 * * * 10; 0 18 * * * 0", "Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 10:00 UTC is 19:00 in Tokyo
+	entry, since, ok := schedule.active(time.Date(2024, time.March, 13, 10, 0, 0, 0, time.UTC))
+	if !ok || entry.replicas != 0 || !since.Equal(time.Date(2024, time.March, 13, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("want the 18:00 Tokyo entry active since 09:00 UTC, got: %d %s", entry.replicas, since)
+	}
+
+	if _, err := parseReplicaSchedule("0 8 * * * 10", "Mars/Olympus"); err == nil {
+		t.Errorf("want error for an unknown time zone")
+	}
+	if _, err := parseReplicaSchedule("0 8 * * * ten", ""); err == nil {
+		t.Errorf("want error for invalid replicas")
+	}
+}
+
+func scheduledService(name string, replicas uint64, schedule string) types.FunctionStatus {
+	return types.FunctionStatus{
+		Name:        name,
+		Namespace:   "openfaas-fn",
+		Replicas:    replicas,
+		Annotations: &map[string]string{ScheduleAnnotation: schedule},
+	}
+}
+
+func Test_ReplicaScheduler_ScalesAtBoundaries(t *testing.T) {
+	query := &recordingServiceQuery{}
+	scheduler := NewReplicaScheduler(ReplicaSchedulerConfig{Ceiling: NewReplicaCeiling(20, nil, 0)}, query)
+
+	weekdays := "0 8 * * mon-fri 10; 0 18 * * mon-fri 0"
+	labelled := scheduledService("labelled", 1, "0 8 * * * 10")
+	labelled.Labels = &map[string]string{MaxScaleLabel: "6"}
+
+	services := []types.FunctionStatus{
+		scheduledService("reports", 0, weekdays),
+		scheduledService("capped", 1, "0 8 * * * 50"),
+		labelled,
+		scheduledService("invalid", 1, "0 8 * * 10"),
+		{Name: "unscheduled", Namespace: "openfaas-fn", Replicas: 1},
+	}
+
+	morning := time.Date(2024, time.March, 13, 8, 0, 30, 0, time.UTC)
+	scheduler.update(services, morning)
+
+	// Without a com.openfaas.scale.max label only the ceiling applies
+	if len(query.scaled) != 3 || query.replicas[0] != 10 || query.replicas[1] != 20 || query.replicas[2] != 6 {
+		t.Fatalf("want reports scaled to 10, capped to the ceiling of 20 and labelled to its label of 6, got: %v %v", query.scaled, query.replicas)
+	}
+
+	// Scaled by another scaler until the next boundary
+	query.scaled, query.replicas = nil, nil
+	services[0].Replicas = 3
+	scheduler.update(services, morning.Add(time.Hour))
+	if len(query.scaled) != 0 {
+		t.Errorf("want no scaling between boundaries, got: %v", query.scaled)
+	}
+
+	if replicas, ok := scheduler.Scheduled("reports", "openfaas-fn"); !ok || replicas != 10 {
+		t.Errorf("scheduled want: 10, got: %d %t", replicas, ok)
+	}
+	if _, ok := scheduler.Scheduled("unscheduled", "openfaas-fn"); ok {
+		t.Errorf("want no schedule for unscheduled")
+	}
+
+	scheduler.update(services, morning.Add(time.Hour*10))
+	if len(query.scaled) != 1 || query.scaled[0] != "reports.openfaas-fn" || query.replicas[0] != 0 {
+		t.Errorf("want reports scaled to 0 at 18:00, got: %v %v", query.scaled, query.replicas)
+	}
+}
+
+func Test_ReplicaScheduler_NilIsInactive(t *testing.T) {
+	var scheduler *ReplicaScheduler
+	if _, ok := scheduler.Scheduled("reports", "openfaas-fn"); ok {
+		t.Errorf("want a nil scheduler to have no schedules")
+	}
+}
+
+func Test_MakeReplicaScheduleHandler(t *testing.T) {
+	scheduler := NewReplicaScheduler(ReplicaSchedulerConfig{Ceiling: NewReplicaCeiling(20, nil, 0)}, &recordingServiceQuery{})
+
+	services := []types.FunctionStatus{
+		scheduledService("reports", 0, "0 8 * * * 10; 0 18 * * * 0"),
+		scheduledService("invalid", 1, "0 8 * * 10"),
+	}
+	scheduler.update(services, time.Now())
+
+	rec := httptest.NewRecorder()
+	MakeReplicaScheduleHandler(scheduler).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/system/scale-schedules?namespace=openfaas-fn", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status want: %d, got: %d", http.StatusOK, rec.Code)
+	}
+
+	schedules := []FunctionSchedule{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &schedules); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(schedules) != 2 || schedules[0].Name != "invalid" || schedules[1].Name != "reports" {
+		t.Fatalf("want invalid and reports listed, got: %+v", schedules)
+	}
+	if len(schedules[0].Error) == 0 {
+		t.Errorf("want an error for the invalid schedule")
+	}
+
+	reports := schedules[1]
+	if reports.Since == nil || reports.Next == nil || len(reports.Active) == 0 {
+		t.Errorf("want the active and next entry of reports, got: %+v", reports)
+	}
+	if reports.Replicas+reports.NextReplicas != 10 {
+		t.Errorf("want one of the active and next entries to be 10 replicas, got: %+v", reports)
+	}
+}
@@ -13,7 +13,10 @@ type ServiceQuery interface {
 
 // ServiceQueryResponse response from querying a function status
 type ServiceQueryResponse struct {
-	Replicas          uint64
+	Replicas uint64
+
+	// MaxReplicas is from the function's com.openfaas.scale.max label, 0
+	// when it has none and only the ReplicaCeiling applies
 	MaxReplicas       uint64
 	MinReplicas       uint64
 	ScalingFactor     uint64
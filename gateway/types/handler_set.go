@@ -46,4 +46,17 @@ type HandlerSet struct {
 	NamespaceListerHandler http.HandlerFunc
 
 	NamespaceMutatorHandler http.HandlerFunc
+
+	// CachePurge removes responses from the response cache
+	CachePurge http.HandlerFunc
+
+	// ReplicaSchedules lists the replica schedules of functions
+	ReplicaSchedules http.HandlerFunc
+
+	// ScaleEvents lists the recent scale events of functions
+	ScaleEvents http.HandlerFunc
+
+	// ScalePredictions compares the invocations predicted for functions
+	// with their actual invocations
+	ScalePredictions http.HandlerFunc
 }
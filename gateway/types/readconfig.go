@@ -7,9 +7,11 @@ package types
 
 import (
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -65,6 +67,7 @@ func (ReadConfig) Read(hasEnv HasEnv) (*GatewayConfig, error) {
 	cfg.ReadTimeout = parseIntOrDurationValue(hasEnv.Getenv("read_timeout"), defaultDuration)
 	cfg.WriteTimeout = parseIntOrDurationValue(hasEnv.Getenv("write_timeout"), defaultDuration)
 	cfg.UpstreamTimeout = parseIntOrDurationValue(hasEnv.Getenv("upstream_timeout"), defaultDuration)
+	cfg.MaxUpstreamTimeout = parseIntOrDurationValue(hasEnv.Getenv("max_upstream_timeout"), cfg.UpstreamTimeout)
 
 	if len(hasEnv.Getenv("functions_provider_url")) > 0 {
 		var err error
@@ -139,6 +142,119 @@ func (ReadConfig) Read(hasEnv HasEnv) (*GatewayConfig, error) {
 	cfg.SecretMountPath = secretPath
 	cfg.ScaleFromZero = parseBoolValue(hasEnv.Getenv("scale_from_zero"))
 
+	cfg.ScalePollCount = 1000
+	if scalePollCount := hasEnv.Getenv("scale_poll_count"); len(scalePollCount) > 0 {
+		val, err := strconv.ParseUint(scalePollCount, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for scale_poll_count: %s", scalePollCount)
+		}
+		cfg.ScalePollCount = uint(val)
+	}
+
+	cfg.ScalePollInterval = parseIntOrDurationValue(hasEnv.Getenv("scale_poll_interval"), time.Millisecond*100)
+
+	cfg.ScaleSetRetries = 20
+	if scaleSetRetries := hasEnv.Getenv("scale_set_retries"); len(scaleSetRetries) > 0 {
+		val, err := strconv.ParseUint(scaleSetRetries, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for scale_set_retries: %s", scaleSetRetries)
+		}
+		cfg.ScaleSetRetries = uint(val)
+	}
+
+	cfg.ColdStartPolicy = "reject"
+	if coldStartPolicy := hasEnv.Getenv("cold_start_policy"); len(coldStartPolicy) > 0 {
+		switch coldStartPolicy {
+		case "reject", "queue", "wait":
+			cfg.ColdStartPolicy = coldStartPolicy
+		default:
+			return nil, fmt.Errorf("invalid value for cold_start_policy: %s", coldStartPolicy)
+		}
+	}
+
+	cfg.ColdStartMaxWait = parseIntOrDurationValue(hasEnv.Getenv("cold_start_max_wait"), time.Minute*5)
+
+	cfg.ColdStartMaxRequests = 1000
+	if coldStartMaxRequests := hasEnv.Getenv("cold_start_max_requests"); len(coldStartMaxRequests) > 0 {
+		val, err := strconv.Atoi(coldStartMaxRequests)
+		if err != nil || val < 0 {
+			return nil, fmt.Errorf("invalid value for cold_start_max_requests: %s", coldStartMaxRequests)
+		}
+		cfg.ColdStartMaxRequests = val
+	}
+
+	cfg.ColdStartMaxBytes = 32 * 1024 * 1024
+	if coldStartMaxBytes := hasEnv.Getenv("cold_start_max_bytes"); len(coldStartMaxBytes) > 0 {
+		val, err := strconv.ParseInt(coldStartMaxBytes, 10, 64)
+		if err != nil || val < 0 {
+			return nil, fmt.Errorf("invalid value for cold_start_max_bytes: %s", coldStartMaxBytes)
+		}
+		cfg.ColdStartMaxBytes = val
+	}
+
+	cfg.ColdStartMaxBodyBytes = 1024 * 1024
+	if coldStartMaxBodyBytes := hasEnv.Getenv("cold_start_max_body_bytes"); len(coldStartMaxBodyBytes) > 0 {
+		val, err := strconv.ParseInt(coldStartMaxBodyBytes, 10, 64)
+		if err != nil || val < 0 {
+			return nil, fmt.Errorf("invalid value for cold_start_max_body_bytes: %s", coldStartMaxBodyBytes)
+		}
+		cfg.ColdStartMaxBodyBytes = val
+	}
+
+	cfg.ScaleToZero = parseBoolValue(hasEnv.Getenv("scale_to_zero"))
+	cfg.ScaleToZeroDryRun = parseBoolValue(hasEnv.Getenv("scale_to_zero_dry_run"))
+	if scaleToZeroNamespaces := hasEnv.Getenv("scale_to_zero_namespaces"); len(scaleToZeroNamespaces) > 0 {
+		for _, namespace := range strings.Split(scaleToZeroNamespaces, ",") {
+			if namespace = strings.TrimSpace(namespace); len(namespace) > 0 {
+				cfg.ScaleToZeroNamespaces = append(cfg.ScaleToZeroNamespaces, namespace)
+			}
+		}
+	}
+
+	cfg.MaxReplicas = 5
+	if maxReplicas := hasEnv.Getenv("max_replicas"); len(maxReplicas) > 0 {
+		val, err := strconv.ParseUint(maxReplicas, 10, 64)
+		if err != nil || val == 0 {
+			return nil, fmt.Errorf("invalid value for max_replicas: %s", maxReplicas)
+		}
+		cfg.MaxReplicas = val
+	}
+
+	if alertScaleDownStep := hasEnv.Getenv("alert_scale_down_step"); len(alertScaleDownStep) > 0 {
+		val, err := strconv.ParseUint(alertScaleDownStep, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for alert_scale_down_step: %s", alertScaleDownStep)
+		}
+		cfg.AlertScaleDownStep = val
+	}
+	cfg.AlertScaleDownCooldown = parseIntOrDurationValue(hasEnv.Getenv("alert_scale_down_cooldown"), time.Second*30)
+
+	cfg.ScaleSchedules = parseBoolValue(hasEnv.Getenv("scale_schedules"))
+
+	cfg.ScaleEventsSize = 1000
+	if scaleEventsSize := hasEnv.Getenv("scale_events_size"); len(scaleEventsSize) > 0 {
+		val, err := strconv.Atoi(scaleEventsSize)
+		if err != nil || val < 0 {
+			return nil, fmt.Errorf("invalid value for scale_events_size: %s", scaleEventsSize)
+		}
+		cfg.ScaleEventsSize = val
+	}
+
+	cfg.Prewarm = parseBoolValue(hasEnv.Getenv("prewarm"))
+
+	cfg.PrewarmThreshold = 1
+	if prewarmThreshold := hasEnv.Getenv("prewarm_threshold"); len(prewarmThreshold) > 0 {
+		val, err := strconv.ParseFloat(prewarmThreshold, 64)
+		if err != nil || val <= 0 {
+			return nil, fmt.Errorf("invalid value for prewarm_threshold: %s", prewarmThreshold)
+		}
+		cfg.PrewarmThreshold = val
+	}
+
+	cfg.Autoscaler = parseBoolValue(hasEnv.Getenv("autoscaler"))
+	cfg.AutoscalerScaleUpWindow = parseIntOrDurationValue(hasEnv.Getenv("autoscaler_scale_up_window"), time.Second*15)
+	cfg.AutoscalerScaleDownWindow = parseIntOrDurationValue(hasEnv.Getenv("autoscaler_scale_down_window"), time.Minute*5)
+
 	cfg.MaxIdleConns = 1024
 	cfg.MaxIdleConnsPerHost = 1024
 
@@ -164,12 +280,157 @@ func (ReadConfig) Read(hasEnv HasEnv) (*GatewayConfig, error) {
 
 	cfg.AuthProxyURL = hasEnv.Getenv("auth_proxy_url")
 	cfg.AuthProxyPassBody = parseBoolValue(hasEnv.Getenv("auth_proxy_pass_body"))
+	cfg.AuthProxyFunctions = parseBoolValue(hasEnv.Getenv("auth_proxy_functions"))
+	cfg.AuthProxyCacheExpiry = parseIntOrDurationValue(hasEnv.Getenv("auth_proxy_cache_expiry"), time.Second*5)
+
+	cfg.WebSocketIdleTimeout = parseIntOrDurationValue(hasEnv.Getenv("websocket_idle_timeout"), time.Minute*5)
+
+	websocketMaxConnections := hasEnv.Getenv("websocket_max_connections")
+	if len(websocketMaxConnections) > 0 {
+		val, err := strconv.Atoi(websocketMaxConnections)
+		if err != nil || val < 0 {
+			return nil, fmt.Errorf("invalid value for websocket_max_connections: %s", websocketMaxConnections)
+		}
+		cfg.WebSocketMaxConnections = val
+	}
+
+	cfg.DomainSuffix = strings.ToLower(strings.Trim(hasEnv.Getenv("function_domain_suffix"), "."))
+
+	domainRoutes, err := parseDomainRoutes(hasEnv.Getenv("domain_routes"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for domain_routes: %s", err)
+	}
+	cfg.DomainRoutes = domainRoutes
+
+	cfg.APIRoutesFile = hasEnv.Getenv("api_routes_file")
+
+	cfg.ResponseCacheMaxBytes = 64 * 1024 * 1024
+	responseCacheMaxBytes := hasEnv.Getenv("response_cache_max_bytes")
+	if len(responseCacheMaxBytes) > 0 {
+		val, err := strconv.ParseInt(responseCacheMaxBytes, 10, 64)
+		if err != nil || val < 0 {
+			return nil, fmt.Errorf("invalid value for response_cache_max_bytes: %s", responseCacheMaxBytes)
+		}
+		cfg.ResponseCacheMaxBytes = val
+	}
+
+	cfg.CompressResponses = parseBoolValue(hasEnv.Getenv("compress_responses"))
+
+	cfg.CompressMinSize = 1024
+	compressMinSize := hasEnv.Getenv("compress_min_size")
+	if len(compressMinSize) > 0 {
+		val, err := strconv.Atoi(compressMinSize)
+		if err != nil || val < 0 {
+			return nil, fmt.Errorf("invalid value for compress_min_size: %s", compressMinSize)
+		}
+		cfg.CompressMinSize = val
+	}
+
+	cfg.CompressContentTypes = []string{"text/", "application/json", "application/javascript", "application/xml", "image/svg+xml"}
+	if compressContentTypes := hasEnv.Getenv("compress_content_types"); len(compressContentTypes) > 0 {
+		cfg.CompressContentTypes = []string{}
+		for _, contentType := range strings.Split(compressContentTypes, ",") {
+			if contentType = strings.TrimSpace(contentType); len(contentType) > 0 {
+				cfg.CompressContentTypes = append(cfg.CompressContentTypes, contentType)
+			}
+		}
+	}
+
+	upstreamRetries := hasEnv.Getenv("upstream_retries")
+	if len(upstreamRetries) > 0 {
+		val, err := strconv.Atoi(upstreamRetries)
+		if err != nil || val < 0 {
+			return nil, fmt.Errorf("invalid value for upstream_retries: %s", upstreamRetries)
+		}
+		cfg.UpstreamRetries = val
+	}
+
+	cfg.UpstreamRetryBackoff = parseIntOrDurationValue(hasEnv.Getenv("upstream_retry_backoff"), time.Millisecond*100)
+	cfg.UpstreamRetryMaxBackoff = parseIntOrDurationValue(hasEnv.Getenv("upstream_retry_max_backoff"), time.Second*2)
+
+	cfg.UpstreamRetryStatusCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable}
+	if upstreamRetryStatusCodes := hasEnv.Getenv("upstream_retry_status_codes"); len(upstreamRetryStatusCodes) > 0 {
+		cfg.UpstreamRetryStatusCodes = []int{}
+		for _, part := range strings.Split(upstreamRetryStatusCodes, ",") {
+			code, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || code < 100 || code > 599 {
+				return nil, fmt.Errorf("invalid value for upstream_retry_status_codes: %s", upstreamRetryStatusCodes)
+			}
+			cfg.UpstreamRetryStatusCodes = append(cfg.UpstreamRetryStatusCodes, code)
+		}
+	}
+
+	cfg.UpstreamRetryBudget = 0.2
+	upstreamRetryBudget := hasEnv.Getenv("upstream_retry_budget")
+	if len(upstreamRetryBudget) > 0 {
+		val, err := strconv.ParseFloat(upstreamRetryBudget, 64)
+		if err != nil || val < 0 {
+			return nil, fmt.Errorf("invalid value for upstream_retry_budget: %s", upstreamRetryBudget)
+		}
+		cfg.UpstreamRetryBudget = val
+	}
+
+	cfg.AdaptiveConcurrency = parseBoolValue(hasEnv.Getenv("adaptive_concurrency"))
+
+	limits := []struct {
+		name     string
+		value    *int
+		fallback int
+	}{
+		{name: "adaptive_concurrency_initial", value: &cfg.AdaptiveConcurrencyInitial, fallback: 200},
+		{name: "adaptive_concurrency_min", value: &cfg.AdaptiveConcurrencyMin, fallback: 20},
+		{name: "adaptive_concurrency_max", value: &cfg.AdaptiveConcurrencyMax, fallback: 5000},
+	}
+	for _, limit := range limits {
+		*limit.value = limit.fallback
+		if val := hasEnv.Getenv(limit.name); len(val) > 0 {
+			parsed, err := strconv.Atoi(val)
+			if err != nil || parsed < 1 {
+				return nil, fmt.Errorf("invalid value for %s: %s", limit.name, val)
+			}
+			*limit.value = parsed
+		}
+	}
+
+	if cfg.AdaptiveConcurrencyMin > cfg.AdaptiveConcurrencyMax ||
+		cfg.AdaptiveConcurrencyInitial < cfg.AdaptiveConcurrencyMin ||
+		cfg.AdaptiveConcurrencyInitial > cfg.AdaptiveConcurrencyMax {
+		return nil, fmt.Errorf("invalid value for adaptive_concurrency_initial: %d must be between adaptive_concurrency_min and adaptive_concurrency_max",
+			cfg.AdaptiveConcurrencyInitial)
+	}
 
 	cfg.Namespace = hasEnv.Getenv("function_namespace")
 
 	return &cfg, nil
 }
 
+// parseDomainRoutes reads a list of host=function pairs separated by commas
+// i.e. "api.example.com=checkout,shop.example.com=store.staging"
+func parseDomainRoutes(val string) (map[string]string, error) {
+	routes := map[string]string{}
+
+	for _, part := range strings.Split(val, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+
+		host, function, found := strings.Cut(part, "=")
+		host = strings.ToLower(strings.Trim(strings.TrimSpace(host), "."))
+		function = strings.TrimSpace(function)
+		if !found || len(host) == 0 || len(function) == 0 {
+			return nil, fmt.Errorf("expected host=function, got: %q", part)
+		}
+
+		if existing, ok := routes[host]; ok {
+			return nil, fmt.Errorf("%s is routed to both %s and %s", host, existing, function)
+		}
+		routes[host] = function
+	}
+
+	return routes, nil
+}
+
 // GatewayConfig provides config for the API Gateway server process
 type GatewayConfig struct {
 
@@ -182,6 +443,10 @@ type GatewayConfig struct {
 	// UpstreamTimeout maximum duration of HTTP call to upstream URL
 	UpstreamTimeout time.Duration
 
+	// MaxUpstreamTimeout is the ceiling for a per-function timeout set through
+	// the com.openfaas.timeout annotation, defaults to UpstreamTimeout
+	MaxUpstreamTimeout time.Duration
+
 	// URL for alternate functions provider.
 	FunctionsProviderURL *url.URL
 
@@ -215,6 +480,90 @@ type GatewayConfig struct {
 	// Enable the gateway to scale any service from 0 replicas to its configured "min replicas"
 	ScaleFromZero bool
 
+	// ScalePollCount is the number of times a function is queried for a
+	// ready replica after scaling from zero
+	ScalePollCount uint
+
+	// ScalePollInterval is the delay between queries for a ready replica
+	ScalePollInterval time.Duration
+
+	// ScaleSetRetries is the number of attempts to scale a function from zero
+	ScaleSetRetries uint
+
+	// ColdStartPolicy is what happens to a request when a function is not
+	// ready after ScalePollCount queries: reject, queue or wait. queue needs
+	// NATS and otherwise rejects the request.
+	ColdStartPolicy string
+
+	// ColdStartMaxWait is how long a request can wait in total for a
+	// function to be ready with the wait policy
+	ColdStartMaxWait time.Duration
+
+	// ColdStartMaxRequests is the most requests held in the waiting room of
+	// a function scaling from zero, 0 disables the waiting room
+	ColdStartMaxRequests int
+
+	// ColdStartMaxBytes is the most bytes of request bodies buffered in the
+	// waiting room of a function
+	ColdStartMaxBytes int64
+
+	// ColdStartMaxBodyBytes is the largest request body buffered in the
+	// waiting room
+	ColdStartMaxBodyBytes int64
+
+	// ScaleToZero scales functions with the com.openfaas.scale.zero
+	// annotation to zero replicas once they are idle
+	ScaleToZero bool
+
+	// ScaleToZeroDryRun logs the functions which would be scaled to zero
+	// without scaling them
+	ScaleToZeroDryRun bool
+
+	// ScaleToZeroNamespaces limits scaling to zero to these namespaces,
+	// when empty all namespaces are enabled
+	ScaleToZeroNamespaces []string
+
+	// MaxReplicas is the most replicas any function can be scaled to, it can
+	// be lowered for a namespace with the com.openfaas.scale.max annotation
+	MaxReplicas uint64
+
+	// AlertScaleDownStep is the number of replicas removed at a time when
+	// an alert resolves, 0 scales straight to the minimum replicas
+	AlertScaleDownStep uint64
+
+	// AlertScaleDownCooldown is how long after a function was last scaled
+	// that each step down is made
+	AlertScaleDownCooldown time.Duration
+
+	// ScaleSchedules scales functions with the com.openfaas.scale.schedule
+	// annotation to the replicas of their schedule
+	ScaleSchedules bool
+
+	// ScaleEventsSize is the number of scale events kept in memory for
+	// /system/scale-events, 0 disables the history
+	ScaleEventsSize int
+
+	// Prewarm keeps hourly invocation profiles of functions and scales
+	// those with the com.openfaas.scale.prewarm annotation ahead of the
+	// invocations predicted
+	Prewarm bool
+
+	// PrewarmThreshold is the average invocations in an hour from which
+	// the hour is predicted to have demand
+	PrewarmThreshold float64
+
+	// Autoscaler scales functions with the com.openfaas.scale.target
+	// annotation from the load seen by the gateway
+	Autoscaler bool
+
+	// AutoscalerScaleUpWindow is how long a higher replica count must be
+	// computed before a function is scaled up
+	AutoscalerScaleUpWindow time.Duration
+
+	// AutoscalerScaleDownWindow is how long a lower replica count must be
+	// computed before a function is scaled down
+	AutoscalerScaleDownWindow time.Duration
+
 	// MaxIdleConns with a default value of 1024, can be used for tuning HTTP proxy performance
 	MaxIdleConns int
 
@@ -227,6 +576,78 @@ type GatewayConfig struct {
 	// AuthProxyPassBody pass body to validation proxy
 	AuthProxyPassBody bool
 
+	// AuthProxyFunctions also validates /function/ invocations with the authenticating proxy
+	AuthProxyFunctions bool
+
+	// AuthProxyCacheExpiry is how long a decision from the authenticating proxy is re-used for, 0 disables caching
+	AuthProxyCacheExpiry time.Duration
+
+	// WebSocketIdleTimeout closes an upgraded connection to a function when no
+	// bytes have been sent in either direction for this long
+	WebSocketIdleTimeout time.Duration
+
+	// WebSocketMaxConnections limits the number of open upgraded connections, 0 for no limit
+	WebSocketMaxConnections int
+
+	// DomainSuffix routes requests for {function}.{namespace}.{suffix} or
+	// {function}.{suffix} to the function, disabled when blank
+	DomainSuffix string
+
+	// DomainRoutes maps a host name to a function, given as function or
+	// function.namespace
+	DomainRoutes map[string]string
+
+	// APIRoutesFile is a route table mapping external paths to functions,
+	// with one "[METHOD] PATH FUNCTION" route per line
+	APIRoutesFile string
+
+	// ResponseCacheMaxBytes is the capacity of the response cache used by
+	// functions with the com.openfaas.cache annotation, 0 disables the cache
+	ResponseCacheMaxBytes int64
+
+	// CompressResponses enables gzip, brotli and zstd compression of
+	// function, list and info responses based upon Accept-Encoding
+	CompressResponses bool
+
+	// CompressMinSize is the smallest response body in bytes to compress
+	CompressMinSize int
+
+	// CompressContentTypes are the content types which can be compressed,
+	// entries ending in / match all subtypes
+	CompressContentTypes []string
+
+	// UpstreamRetries is the number of times a failed call to a function is
+	// retried, 0 disables retries
+	UpstreamRetries int
+
+	// UpstreamRetryBackoff is the delay before the first retry, it doubles
+	// with jitter for each further retry
+	UpstreamRetryBackoff time.Duration
+
+	// UpstreamRetryMaxBackoff is the longest delay between retries
+	UpstreamRetryMaxBackoff time.Duration
+
+	// UpstreamRetryStatusCodes are the function responses which are retried
+	// in addition to refused and reset connections
+	UpstreamRetryStatusCodes []int
+
+	// UpstreamRetryBudget is the number of retries allowed per request to
+	// a function
+	UpstreamRetryBudget float64
+
+	// AdaptiveConcurrency limits the requests in-flight in the gateway to
+	// a limit adjusted by the latency of functions, and sheds the rest
+	AdaptiveConcurrency bool
+
+	// AdaptiveConcurrencyInitial is the limit when the gateway starts
+	AdaptiveConcurrencyInitial int
+
+	// AdaptiveConcurrencyMin is the lowest the limit can fall to
+	AdaptiveConcurrencyMin int
+
+	// AdaptiveConcurrencyMax is the highest the limit can grow to
+	AdaptiveConcurrencyMax int
+
 	// Namespace for endpoints
 	Namespace string
 }
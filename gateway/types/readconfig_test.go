@@ -7,6 +7,7 @@ package types
 
 import (
 	"fmt"
+	"net/http"
 	"testing"
 	"time"
 )
@@ -438,3 +439,375 @@ func TestRead_LogsProviderURL(t *testing.T) {
 		}
 	})
 }
+
+func TestRead_AuthProxyFunctionsAndCacheExpiry(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	config, _ := readConfig.Read(defaults)
+	if config.AuthProxyFunctions != false {
+		t.Errorf("config.AuthProxyFunctions, want: %t, got: %t", false, config.AuthProxyFunctions)
+	}
+	if config.AuthProxyCacheExpiry != time.Second*5 {
+		t.Errorf("config.AuthProxyCacheExpiry, want: %s, got: %s", time.Second*5, config.AuthProxyCacheExpiry)
+	}
+
+	defaults.Setenv("auth_proxy_functions", "true")
+	defaults.Setenv("auth_proxy_cache_expiry", "0")
+
+	config, _ = readConfig.Read(defaults)
+	if config.AuthProxyFunctions != true {
+		t.Errorf("config.AuthProxyFunctions, want: %t, got: %t", true, config.AuthProxyFunctions)
+	}
+	if config.AuthProxyCacheExpiry != 0 {
+		t.Errorf("config.AuthProxyCacheExpiry, want: %s, got: %s", time.Duration(0), config.AuthProxyCacheExpiry)
+	}
+}
+
+func TestRead_DomainRoutes(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	defaults.Setenv("function_domain_suffix", "fn.example.com.")
+	defaults.Setenv("domain_routes", "API.example.com=checkout, shop.example.com=store.staging")
+
+	config, err := readConfig.Read(defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.DomainSuffix != "fn.example.com" {
+		t.Errorf("config.DomainSuffix, want: %s, got: %s", "fn.example.com", config.DomainSuffix)
+	}
+	if got := config.DomainRoutes["api.example.com"]; got != "checkout" {
+		t.Errorf("config.DomainRoutes[api.example.com], want: %s, got: %s", "checkout", got)
+	}
+	if got := config.DomainRoutes["shop.example.com"]; got != "store.staging" {
+		t.Errorf("config.DomainRoutes[shop.example.com], want: %s, got: %s", "store.staging", got)
+	}
+
+	for _, invalid := range []string{"api.example.com", "api.example.com=a,api.example.com=b", "=checkout"} {
+		defaults.Setenv("domain_routes", invalid)
+		if _, err := readConfig.Read(defaults); err == nil {
+			t.Errorf("want error for domain_routes: %q", invalid)
+		}
+	}
+}
+
+func TestRead_Compression(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	config, _ := readConfig.Read(defaults)
+	if config.CompressResponses != false {
+		t.Errorf("config.CompressResponses, want: %t, got: %t", false, config.CompressResponses)
+	}
+	if config.CompressMinSize != 1024 {
+		t.Errorf("config.CompressMinSize, want: %d, got: %d", 1024, config.CompressMinSize)
+	}
+
+	defaults.Setenv("compress_responses", "true")
+	defaults.Setenv("compress_min_size", "256")
+	defaults.Setenv("compress_content_types", "application/json, text/")
+
+	config, _ = readConfig.Read(defaults)
+	if config.CompressResponses != true {
+		t.Errorf("config.CompressResponses, want: %t, got: %t", true, config.CompressResponses)
+	}
+	if config.CompressMinSize != 256 {
+		t.Errorf("config.CompressMinSize, want: %d, got: %d", 256, config.CompressMinSize)
+	}
+	if len(config.CompressContentTypes) != 2 || config.CompressContentTypes[1] != "text/" {
+		t.Errorf("config.CompressContentTypes, want: %v, got: %v", []string{"application/json", "text/"}, config.CompressContentTypes)
+	}
+
+	defaults.Setenv("compress_min_size", "-1")
+	if _, err := readConfig.Read(defaults); err == nil {
+		t.Errorf("want error for a negative compress_min_size")
+	}
+}
+
+func TestRead_ResponseCacheMaxBytes(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	config, _ := readConfig.Read(defaults)
+	if want := int64(64 * 1024 * 1024); config.ResponseCacheMaxBytes != want {
+		t.Errorf("config.ResponseCacheMaxBytes, want: %d, got: %d", want, config.ResponseCacheMaxBytes)
+	}
+
+	defaults.Setenv("response_cache_max_bytes", "0")
+	config, _ = readConfig.Read(defaults)
+	if config.ResponseCacheMaxBytes != 0 {
+		t.Errorf("config.ResponseCacheMaxBytes, want: %d, got: %d", 0, config.ResponseCacheMaxBytes)
+	}
+
+	defaults.Setenv("response_cache_max_bytes", "lots")
+	if _, err := readConfig.Read(defaults); err == nil {
+		t.Errorf("want error for an invalid response_cache_max_bytes")
+	}
+}
+
+func TestRead_UpstreamRetries(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	config, _ := readConfig.Read(defaults)
+	if config.UpstreamRetries != 0 {
+		t.Errorf("config.UpstreamRetries, want: %d, got: %d", 0, config.UpstreamRetries)
+	}
+	if len(config.UpstreamRetryStatusCodes) != 2 || config.UpstreamRetryStatusCodes[0] != http.StatusBadGateway {
+		t.Errorf("config.UpstreamRetryStatusCodes, want: %v, got: %v", []int{502, 503}, config.UpstreamRetryStatusCodes)
+	}
+
+	defaults.Setenv("upstream_retries", "3")
+	defaults.Setenv("upstream_retry_backoff", "20ms")
+	defaults.Setenv("upstream_retry_status_codes", "503, 504")
+	defaults.Setenv("upstream_retry_budget", "0.5")
+
+	config, _ = readConfig.Read(defaults)
+	if config.UpstreamRetries != 3 {
+		t.Errorf("config.UpstreamRetries, want: %d, got: %d", 3, config.UpstreamRetries)
+	}
+	if config.UpstreamRetryBackoff != time.Millisecond*20 {
+		t.Errorf("config.UpstreamRetryBackoff, want: %s, got: %s", time.Millisecond*20, config.UpstreamRetryBackoff)
+	}
+	if config.UpstreamRetryMaxBackoff != time.Second*2 {
+		t.Errorf("config.UpstreamRetryMaxBackoff, want: %s, got: %s", time.Second*2, config.UpstreamRetryMaxBackoff)
+	}
+	if len(config.UpstreamRetryStatusCodes) != 2 || config.UpstreamRetryStatusCodes[1] != http.StatusGatewayTimeout {
+		t.Errorf("config.UpstreamRetryStatusCodes, want: %v, got: %v", []int{503, 504}, config.UpstreamRetryStatusCodes)
+	}
+	if config.UpstreamRetryBudget != 0.5 {
+		t.Errorf("config.UpstreamRetryBudget, want: %f, got: %f", 0.5, config.UpstreamRetryBudget)
+	}
+
+	defaults.Setenv("upstream_retry_status_codes", "bad")
+	if _, err := readConfig.Read(defaults); err == nil {
+		t.Errorf("want error for an invalid upstream_retry_status_codes")
+	}
+}
+
+func TestRead_AdaptiveConcurrency(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	config, _ := readConfig.Read(defaults)
+	if config.AdaptiveConcurrency != false {
+		t.Errorf("config.AdaptiveConcurrency, want: %t, got: %t", false, config.AdaptiveConcurrency)
+	}
+	if config.AdaptiveConcurrencyInitial != 200 || config.AdaptiveConcurrencyMin != 20 || config.AdaptiveConcurrencyMax != 5000 {
+		t.Errorf("adaptive concurrency limits, want: 200 20 5000, got: %d %d %d",
+			config.AdaptiveConcurrencyInitial, config.AdaptiveConcurrencyMin, config.AdaptiveConcurrencyMax)
+	}
+
+	defaults.Setenv("adaptive_concurrency", "true")
+	defaults.Setenv("adaptive_concurrency_initial", "50")
+	defaults.Setenv("adaptive_concurrency_min", "10")
+	defaults.Setenv("adaptive_concurrency_max", "100")
+
+	config, _ = readConfig.Read(defaults)
+	if config.AdaptiveConcurrency != true {
+		t.Errorf("config.AdaptiveConcurrency, want: %t, got: %t", true, config.AdaptiveConcurrency)
+	}
+	if config.AdaptiveConcurrencyInitial != 50 || config.AdaptiveConcurrencyMin != 10 || config.AdaptiveConcurrencyMax != 100 {
+		t.Errorf("adaptive concurrency limits, want: 50 10 100, got: %d %d %d",
+			config.AdaptiveConcurrencyInitial, config.AdaptiveConcurrencyMin, config.AdaptiveConcurrencyMax)
+	}
+
+	defaults.Setenv("adaptive_concurrency_initial", "500")
+	if _, err := readConfig.Read(defaults); err == nil {
+		t.Errorf("want error for an initial limit above the maximum")
+	}
+}
+
+func TestRead_ColdStart(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	config, _ := readConfig.Read(defaults)
+	if config.ScalePollCount != 1000 || config.ScaleSetRetries != 20 || config.ScalePollInterval != time.Millisecond*100 {
+		t.Errorf("scale polling, want: 1000 20 100ms, got: %d %d %s", config.ScalePollCount, config.ScaleSetRetries, config.ScalePollInterval)
+	}
+	if config.ColdStartPolicy != "reject" || config.ColdStartMaxWait != time.Minute*5 {
+		t.Errorf("cold start, want: reject 5m, got: %s %s", config.ColdStartPolicy, config.ColdStartMaxWait)
+	}
+
+	defaults.Setenv("scale_poll_count", "50")
+	defaults.Setenv("scale_poll_interval", "250ms")
+	defaults.Setenv("scale_set_retries", "3")
+	defaults.Setenv("cold_start_policy", "wait")
+	defaults.Setenv("cold_start_max_wait", "30s")
+
+	config, _ = readConfig.Read(defaults)
+	if config.ScalePollCount != 50 || config.ScaleSetRetries != 3 || config.ScalePollInterval != time.Millisecond*250 {
+		t.Errorf("scale polling, want: 50 3 250ms, got: %d %d %s", config.ScalePollCount, config.ScaleSetRetries, config.ScalePollInterval)
+	}
+	if config.ColdStartPolicy != "wait" || config.ColdStartMaxWait != time.Second*30 {
+		t.Errorf("cold start, want: wait 30s, got: %s %s", config.ColdStartPolicy, config.ColdStartMaxWait)
+	}
+
+	defaults.Setenv("cold_start_policy", "drop")
+	if _, err := readConfig.Read(defaults); err == nil {
+		t.Errorf("want error for an unknown cold start policy")
+	}
+}
+
+func TestRead_ScaleToZero(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	config, _ := readConfig.Read(defaults)
+	if config.ScaleToZero || config.ScaleToZeroDryRun || len(config.ScaleToZeroNamespaces) != 0 {
+		t.Errorf("scale to zero, want: disabled, got: %t %t %v", config.ScaleToZero, config.ScaleToZeroDryRun, config.ScaleToZeroNamespaces)
+	}
+
+	defaults.Setenv("scale_to_zero", "true")
+	defaults.Setenv("scale_to_zero_dry_run", "true")
+	defaults.Setenv("scale_to_zero_namespaces", "staging, dev,")
+
+	config, _ = readConfig.Read(defaults)
+	if !config.ScaleToZero || !config.ScaleToZeroDryRun {
+		t.Errorf("scale to zero, want: enabled in dry-run, got: %t %t", config.ScaleToZero, config.ScaleToZeroDryRun)
+	}
+	if len(config.ScaleToZeroNamespaces) != 2 || config.ScaleToZeroNamespaces[0] != "staging" || config.ScaleToZeroNamespaces[1] != "dev" {
+		t.Errorf("config.ScaleToZeroNamespaces, want: [staging dev], got: %v", config.ScaleToZeroNamespaces)
+	}
+}
+
+func TestRead_Autoscaler(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	config, _ := readConfig.Read(defaults)
+	if config.Autoscaler || config.AutoscalerScaleUpWindow != time.Second*15 || config.AutoscalerScaleDownWindow != time.Minute*5 {
+		t.Errorf("autoscaler, want: false 15s 5m, got: %t %s %s", config.Autoscaler, config.AutoscalerScaleUpWindow, config.AutoscalerScaleDownWindow)
+	}
+
+	if config.ScaleSchedules {
+		t.Errorf("scale schedules, want: false, got: %t", config.ScaleSchedules)
+	}
+
+	defaults.Setenv("autoscaler", "true")
+	defaults.Setenv("scale_schedules", "true")
+	defaults.Setenv("autoscaler_scale_up_window", "0s")
+	defaults.Setenv("autoscaler_scale_down_window", "10m")
+
+	config, _ = readConfig.Read(defaults)
+	if !config.Autoscaler || config.AutoscalerScaleUpWindow != 0 || config.AutoscalerScaleDownWindow != time.Minute*10 {
+		t.Errorf("autoscaler, want: true 0s 10m, got: %t %s %s", config.Autoscaler, config.AutoscalerScaleUpWindow, config.AutoscalerScaleDownWindow)
+	}
+	if !config.ScaleSchedules {
+		t.Errorf("scale schedules, want: true, got: %t", config.ScaleSchedules)
+	}
+}
+
+func TestRead_MaxReplicas(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	config, _ := readConfig.Read(defaults)
+	if config.MaxReplicas != 5 {
+		t.Errorf("config.MaxReplicas, want: %d, got: %d", 5, config.MaxReplicas)
+	}
+
+	defaults.Setenv("max_replicas", "50")
+	config, _ = readConfig.Read(defaults)
+	if config.MaxReplicas != 50 {
+		t.Errorf("config.MaxReplicas, want: %d, got: %d", 50, config.MaxReplicas)
+	}
+
+	defaults.Setenv("max_replicas", "0")
+	if _, err := readConfig.Read(defaults); err == nil {
+		t.Errorf("want error for a ceiling of 0")
+	}
+}
+
+func TestRead_AlertScaleDown(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	config, _ := readConfig.Read(defaults)
+	if config.AlertScaleDownStep != 0 || config.AlertScaleDownCooldown != time.Second*30 {
+		t.Errorf("alert scale down, want: 0 30s, got: %d %s", config.AlertScaleDownStep, config.AlertScaleDownCooldown)
+	}
+
+	defaults.Setenv("alert_scale_down_step", "2")
+	defaults.Setenv("alert_scale_down_cooldown", "1m")
+
+	config, _ = readConfig.Read(defaults)
+	if config.AlertScaleDownStep != 2 || config.AlertScaleDownCooldown != time.Minute {
+		t.Errorf("alert scale down, want: 2 1m, got: %d %s", config.AlertScaleDownStep, config.AlertScaleDownCooldown)
+	}
+
+	defaults.Setenv("alert_scale_down_step", "-1")
+	if _, err := readConfig.Read(defaults); err == nil {
+		t.Errorf("want error for a negative scale down step")
+	}
+}
+
+func TestRead_ScaleEventsSize(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	config, _ := readConfig.Read(defaults)
+	if config.ScaleEventsSize != 1000 {
+		t.Errorf("config.ScaleEventsSize, want: %d, got: %d", 1000, config.ScaleEventsSize)
+	}
+
+	defaults.Setenv("scale_events_size", "0")
+	config, _ = readConfig.Read(defaults)
+	if config.ScaleEventsSize != 0 {
+		t.Errorf("config.ScaleEventsSize, want: %d, got: %d", 0, config.ScaleEventsSize)
+	}
+
+	defaults.Setenv("scale_events_size", "-1")
+	if _, err := readConfig.Read(defaults); err == nil {
+		t.Errorf("want error for a negative size")
+	}
+}
+
+func TestRead_ColdStartWaitingRoom(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	config, _ := readConfig.Read(defaults)
+	if config.ColdStartMaxRequests != 1000 || config.ColdStartMaxBytes != 32*1024*1024 || config.ColdStartMaxBodyBytes != 1024*1024 {
+		t.Errorf("waiting room, want: 1000 32MB 1MB, got: %d %d %d", config.ColdStartMaxRequests, config.ColdStartMaxBytes, config.ColdStartMaxBodyBytes)
+	}
+
+	defaults.Setenv("cold_start_max_requests", "0")
+	defaults.Setenv("cold_start_max_bytes", "4096")
+	defaults.Setenv("cold_start_max_body_bytes", "512")
+	config, _ = readConfig.Read(defaults)
+	if config.ColdStartMaxRequests != 0 || config.ColdStartMaxBytes != 4096 || config.ColdStartMaxBodyBytes != 512 {
+		t.Errorf("waiting room, want: 0 4096 512, got: %d %d %d", config.ColdStartMaxRequests, config.ColdStartMaxBytes, config.ColdStartMaxBodyBytes)
+	}
+
+	defaults.Setenv("cold_start_max_bytes", "lots")
+	if _, err := readConfig.Read(defaults); err == nil {
+		t.Errorf("want error for an invalid cold_start_max_bytes")
+	}
+}
+
+func TestRead_Prewarm(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	config, _ := readConfig.Read(defaults)
+	if config.Prewarm || config.PrewarmThreshold != 1 {
+		t.Errorf("prewarm, want: false 1, got: %t %f", config.Prewarm, config.PrewarmThreshold)
+	}
+
+	defaults.Setenv("prewarm", "true")
+	defaults.Setenv("prewarm_threshold", "2.5")
+	config, _ = readConfig.Read(defaults)
+	if !config.Prewarm || config.PrewarmThreshold != 2.5 {
+		t.Errorf("prewarm, want: true 2.5, got: %t %f", config.Prewarm, config.PrewarmThreshold)
+	}
+
+	defaults.Setenv("prewarm_threshold", "0")
+	if _, err := readConfig.Read(defaults); err == nil {
+		t.Errorf("want error for a threshold of 0")
+	}
+}
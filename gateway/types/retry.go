@@ -2,6 +2,8 @@ package types
 
 import (
 	"log"
+	"math/rand/v2"
+	"sync"
 	"time"
 )
 
@@ -23,3 +25,75 @@ func Retry(r routine, label string, attempts int, interval time.Duration) error
 	}
 	return err
 }
+
+// Backoff is an exponential backoff with jitter, the delay doubles from
+// Initial for each attempt up to Max
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// Delay returns how long to wait before the given retry, counting from 0.
+// The delay is chosen at random from the upper half of the exponential
+// value so that clients which failed together do not retry together.
+func (b Backoff) Delay(attempt int) time.Duration {
+	delay := b.Initial
+	for i := 0; i < attempt && delay < b.Max; i++ {
+		delay *= 2
+	}
+	if delay > b.Max {
+		delay = b.Max
+	}
+
+	if delay <= 1 {
+		return delay
+	}
+
+	half := delay / 2
+	return half + rand.N(delay-half)
+}
+
+// RetryBudget limits retries to a ratio of requests. Each request deposits
+// ratio tokens up to max and each retry withdraws a whole token, so during
+// an outage retries stop once the budget is spent instead of multiplying
+// the load on the upstream.
+type RetryBudget struct {
+	ratio  float64
+	max    float64
+	tokens float64
+	lock   sync.Mutex
+}
+
+// NewRetryBudget creates a full RetryBudget which allows ratio retries per
+// request and up to max retries at once
+func NewRetryBudget(ratio float64, max int) *RetryBudget {
+	return &RetryBudget{
+		ratio:  ratio,
+		max:    float64(max),
+		tokens: float64(max),
+	}
+}
+
+// Deposit records a request
+func (b *RetryBudget) Deposit() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.tokens += b.ratio
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+}
+
+// Withdraw takes a token for a retry, it returns false when the budget
+// has been spent
+func (b *RetryBudget) Withdraw() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
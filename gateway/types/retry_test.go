@@ -41,3 +41,50 @@ func Test_retry_until_max_attempts(t *testing.T) {
 		t.Errorf("want: %d, got: %d", want, called)
 	}
 }
+
+func Test_Backoff_Delay(t *testing.T) {
+	backoff := Backoff{Initial: time.Millisecond * 100, Max: time.Second}
+
+	scenarios := []struct {
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{attempt: 0, min: time.Millisecond * 50, max: time.Millisecond * 100},
+		{attempt: 1, min: time.Millisecond * 100, max: time.Millisecond * 200},
+		{attempt: 3, min: time.Millisecond * 400, max: time.Millisecond * 800},
+		{attempt: 10, min: time.Millisecond * 500, max: time.Second},
+	}
+
+	for _, s := range scenarios {
+		for i := 0; i < 100; i++ {
+			got := backoff.Delay(s.attempt)
+			if got < s.min || got > s.max {
+				t.Fatalf("attempt %d want between %s and %s, got: %s", s.attempt, s.min, s.max, got)
+			}
+		}
+	}
+}
+
+func Test_RetryBudget(t *testing.T) {
+	budget := NewRetryBudget(0.5, 2)
+
+	for i := 0; i < 2; i++ {
+		if !budget.Withdraw() {
+			t.Fatalf("want retry %d allowed from the initial budget", i)
+		}
+	}
+	if budget.Withdraw() {
+		t.Fatalf("want retry denied when the budget is spent")
+	}
+
+	budget.Deposit()
+	if budget.Withdraw() {
+		t.Fatalf("want retry denied after half a token was deposited")
+	}
+
+	budget.Deposit()
+	if !budget.Withdraw() {
+		t.Fatalf("want retry allowed after a whole token was deposited")
+	}
+}